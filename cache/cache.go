@@ -1,9 +1,16 @@
 package cache
 
 import (
+	"errors"
 	"time"
 )
 
+// ErrNotFound is returned by the drivers that don't have a specific error when a key is not found
+var ErrNotFound = errors.New("cache: key not found")
+
+// ErrNotSupported is returned when the driver doesn't support an operation
+var ErrNotSupported = errors.New("cache: operation not supported by the driver")
+
 type Config struct {
 	Engine string `config:"platform.cache.engine" default:"bigCache"`
 }
@@ -23,6 +30,16 @@ type Cache interface {
 	Keys(pattern string) (available []string)
 	// Clear removes all keys
 	Clear()
+	// GetOrSet retrieves the value at key and on a miss calls the loader, stores its result for ttl
+	// and decodes it in value. Concurrent calls for the same missing key call the loader only once
+	GetOrSet(key string, ttl time.Duration, value interface{}, loader func() (interface{}, error)) (err error)
+}
+
+// AtomicSetter is implemented by the drivers that can store a key only if it doesn't exist in a single
+// atomic operation
+type AtomicSetter interface {
+	// SetNX stores a key with a given life time if it doesn't exist and returns true if it was stored
+	SetNX(key string, value interface{}, ttl time.Duration) (ok bool, err error)
 }
 
 var defMgr = New()
@@ -74,11 +91,42 @@ func Get(key string, value interface{}) (err error) {
 	return defMgr.Default().Get(key, value)
 }
 
+// GetT retrieves the value at key from the default cache as a T. A missing key is reported with ok
+// set to false and a nil error
+//
+//	user, ok, err := cache.GetT[User]("user:1")
+func GetT[T any](key string) (value T, ok bool, err error) {
+	c := defMgr.Default()
+	if err = c.Get(key, &value); err != nil {
+		// drivers report a miss with their own errors, so check the key before failing
+		if errors.Is(err, ErrNotFound) || !c.Has(key) {
+			return value, false, nil
+		}
+		return value, false, err
+	}
+	return value, true, nil
+}
+
 // Set stores a key with a given life time. 0 for permanent
 func Set(key string, value interface{}, ttl time.Duration) (err error) {
 	return defMgr.Default().Set(key, value, ttl)
 }
 
+// SetNX stores a key in the default cache only if it doesn't exist and returns true if it was stored.
+// ErrNotSupported is returned if the driver doesn't implement AtomicSetter
+func SetNX(key string, value interface{}, ttl time.Duration) (ok bool, err error) {
+	s, ok := defMgr.Default().(AtomicSetter)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	return s.SetNX(key, value, ttl)
+}
+
+// GetOrSet retrieves the value at key or stores the result of the loader on a miss
+func GetOrSet(key string, ttl time.Duration, value interface{}, loader func() (interface{}, error)) (err error) {
+	return defMgr.Default().GetOrSet(key, ttl, value, loader)
+}
+
 // Del remove a key by name
 func Del(key string) (err error) {
 	return defMgr.Default().Del(key)
@@ -70,6 +70,11 @@ func (m *Manager) Set(key string, value interface{}, ttl time.Duration) (err err
 	return m.Default().Set(key, value, ttl)
 }
 
+// GetOrSet retrieves the value at key or stores the result of the loader on a miss
+func (m *Manager) GetOrSet(key string, ttl time.Duration, value interface{}, loader func() (interface{}, error)) (err error) {
+	return m.Default().GetOrSet(key, ttl, value, loader)
+}
+
 // Del remove a key by name
 func (m *Manager) Del(key string) (err error) {
 	return m.Default().Del(key)
@@ -0,0 +1,266 @@
+// Package memory implements a thread safe in-memory cache driver, useful for tests and single node
+// deployments
+package memory
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/najibulloShapoatov/server-core/cache"
+)
+
+type Config struct {
+	// CleanupInterval is how often the expired keys are evicted
+	CleanupInterval time.Duration `config:"platform.cache.memory.cleanupInterval" default:"1m"`
+}
+
+type item struct {
+	data    []byte
+	expires time.Time
+}
+
+func (i item) expired(now time.Time) bool {
+	return !i.expires.IsZero() && !now.Before(i.expires)
+}
+
+type Cache struct {
+	lock  sync.RWMutex
+	items map[string]item
+	quit  chan struct{}
+	once  sync.Once
+	// loaders running for GetOrSet by key
+	loadLock sync.Mutex
+	loading  map[string]*load
+}
+
+// load is a loader call shared by the concurrent GetOrSet calls of a key
+type load struct {
+	done chan struct{}
+	err  error
+}
+
+// the package registers an instance with the default configuration, so importing it is enough to use
+// cache.Use(cache.MemCache). The default driver is not changed
+func init() {
+	cache.DefManager().Register(cache.MemCache, New(nil))
+}
+
+// New returns an in-memory cache evicting the expired keys every cleanup interval, or every minute if
+// config is nil
+func New(config *Config) *Cache {
+	c := &Cache{
+		items:   make(map[string]item),
+		quit:    make(chan struct{}),
+		loading: make(map[string]*load),
+	}
+	interval := time.Minute
+	if config != nil && config.CleanupInterval > 0 {
+		interval = config.CleanupInterval
+	}
+	go c.cleanup(interval)
+	return c
+}
+
+// Type returns the type of the cache
+func (c *Cache) Type() string {
+	return cache.MemCache
+}
+
+// Get retrieves value at key from cache
+func (c *Cache) Get(key string, value interface{}) (err error) {
+	c.lock.RLock()
+	it, ok := c.items[key]
+	c.lock.RUnlock()
+	if !ok || it.expired(time.Now()) {
+		return cache.ErrNotFound
+	}
+	return json.Unmarshal(it.data, value)
+}
+
+// Has checks if key is available in cache
+func (c *Cache) Has(key string) (ok bool) {
+	c.lock.RLock()
+	it, ok := c.items[key]
+	c.lock.RUnlock()
+	return ok && !it.expired(time.Now())
+}
+
+// Set stores a key with a given life time. 0 for permanent
+func (c *Cache) Set(key string, value interface{}, ttl time.Duration) (err error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	it := item{data: data}
+	if ttl > 0 {
+		it.expires = time.Now().Add(ttl)
+	}
+	c.lock.Lock()
+	c.items[key] = it
+	c.lock.Unlock()
+	return nil
+}
+
+// SetNX stores a key with a given life time only if it doesn't exist or it expired and returns true
+// if it was stored. 0 for permanent
+func (c *Cache) SetNX(key string, value interface{}, ttl time.Duration) (ok bool, err error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	it := item{data: data}
+	if ttl > 0 {
+		it.expires = now.Add(ttl)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if old, ok := c.items[key]; ok && !old.expired(now) {
+		return false, nil
+	}
+	c.items[key] = it
+	return true, nil
+}
+
+// GetOrSet retrieves the value at key and on a miss calls the loader, stores its result for ttl
+// and decodes it in value. Concurrent calls for the same missing key wait for the first loader
+func (c *Cache) GetOrSet(key string, ttl time.Duration, value interface{}, loader func() (interface{}, error)) (err error) {
+	if err := c.Get(key, value); err == nil {
+		return nil
+	}
+
+	c.loadLock.Lock()
+	if l, ok := c.loading[key]; ok {
+		c.loadLock.Unlock()
+		<-l.done
+		if l.err != nil {
+			return l.err
+		}
+		return c.Get(key, value)
+	}
+	l := &load{done: make(chan struct{})}
+	c.loading[key] = l
+	c.loadLock.Unlock()
+
+	defer func() {
+		l.err = err
+		c.loadLock.Lock()
+		delete(c.loading, key)
+		c.loadLock.Unlock()
+		close(l.done)
+	}()
+
+	v, err := loader()
+	if err != nil {
+		return err
+	}
+	if err = c.Set(key, v, ttl); err != nil {
+		return err
+	}
+	return c.Get(key, value)
+}
+
+// Del removes a key by name
+func (c *Cache) Del(key string) (err error) {
+	c.lock.Lock()
+	delete(c.items, key)
+	c.lock.Unlock()
+	return nil
+}
+
+// Keys list all available cache keys matching the glob style pattern (*, ? and [abc] like redis)
+func (c *Cache) Keys(pattern string) (available []string) {
+	re, err := globToRegexp(pattern)
+	if err != nil {
+		return nil
+	}
+	now := time.Now()
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for k, it := range c.items {
+		if !it.expired(now) && re.MatchString(k) {
+			available = append(available, k)
+		}
+	}
+	return
+}
+
+// Clear removes all keys
+func (c *Cache) Clear() {
+	c.lock.Lock()
+	c.items = make(map[string]item)
+	c.lock.Unlock()
+}
+
+// Close stops the background eviction of the expired keys
+func (c *Cache) Close() {
+	c.once.Do(func() {
+		close(c.quit)
+	})
+}
+
+func (c *Cache) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.evict()
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// evict removes the expired keys
+func (c *Cache) evict() {
+	now := time.Now()
+	c.lock.Lock()
+	for k, it := range c.items {
+		if it.expired(now) {
+			delete(c.items, k)
+		}
+	}
+	c.lock.Unlock()
+}
+
+// globToRegexp converts a redis glob style pattern to a regular expression
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			b.WriteString("(?s:.*)")
+		case '?':
+			b.WriteString("(?s:.)")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "^") {
+				class = "^" + regexp.QuoteMeta(class[1:])
+			} else {
+				class = regexp.QuoteMeta(class)
+			}
+			// ranges like a-z are kept since QuoteMeta doesn't escape -
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/najibulloShapoatov/server-core/cache"
+)
+
+func TestRegistered(t *testing.T) {
+	c := cache.Driver(cache.MemCache)
+	if c == nil {
+		t.Fatal("the memory cache is not registered")
+	}
+	if c.Type() != cache.MemCache {
+		t.Errorf("expected type %s, got %s", cache.MemCache, c.Type())
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c := New(&Config{CleanupInterval: time.Hour})
+	defer c.Close()
+
+	const ttl = time.Millisecond * 50
+	if err := c.Set("short", "value", ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("permanent", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var v string
+	if err := c.Get("short", &v); err != nil || v != "value" {
+		t.Fatalf("expected the value before the expiry, got %q %v", v, err)
+	}
+	if ok, _ := c.SetNX("short", "other", ttl); ok {
+		t.Error("SetNX replaced a key that is not expired")
+	}
+
+	time.Sleep(ttl * 2)
+
+	tests := []struct {
+		key    string
+		exists bool
+	}{
+		{"short", false},
+		{"permanent", true},
+	}
+	for _, tt := range tests {
+		if c.Has(tt.key) != tt.exists {
+			t.Errorf("%s: expected Has %v", tt.key, tt.exists)
+		}
+		if err := c.Get(tt.key, &v); (err == nil) != tt.exists {
+			t.Errorf("%s: expected Get to find the key %v, got %v", tt.key, tt.exists, err)
+		}
+	}
+	if keys := c.Keys("*"); len(keys) != 1 || keys[0] != "permanent" {
+		t.Errorf("expected only the permanent key, got %v", keys)
+	}
+
+	// the expired key is kept until it is evicted
+	c.lock.RLock()
+	count := len(c.items)
+	c.lock.RUnlock()
+	if count != 2 {
+		t.Errorf("expected 2 stored items before the eviction, got %d", count)
+	}
+	c.evict()
+	c.lock.RLock()
+	_, ok := c.items["short"]
+	c.lock.RUnlock()
+	if ok {
+		t.Error("the expired key was not evicted")
+	}
+
+	if ok, err := c.SetNX("short", "other", ttl); !ok || err != nil {
+		t.Errorf("SetNX didn't store the expired key: %v", err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	c := New(&Config{CleanupInterval: time.Millisecond * 10})
+	defer c.Close()
+	_ = c.Set("a", 1, time.Millisecond)
+	_ = c.Set("b", 2, time.Millisecond)
+	_ = c.Set("c", 3, 0)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.lock.RLock()
+		var keys []string
+		for k := range c.items {
+			keys = append(keys, k)
+		}
+		c.lock.RUnlock()
+		sort.Strings(keys)
+		if len(keys) == 1 && keys[0] == "c" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the expired keys were not evicted in the background, got %v", keys)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
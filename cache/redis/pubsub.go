@@ -47,7 +47,11 @@ func (c *Cache) redisClientListener(subInfo SubscriptionInfo, redisClientHandler
 		case <-subInfo.closeChannel:
 			// Channel and unsubscribe is done in Unsubscribe()
 			return
-		case message := <-subInfo.subscription.Channel():
+		case message, ok := <-subInfo.subscription.Channel():
+			if !ok {
+				// the subscription was closed
+				return
+			}
 			// process redis message for current subscription
 			redisClientHandler(message)
 		}
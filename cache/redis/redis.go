@@ -1,6 +1,8 @@
 package redis
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/najibulloShapoatov/server-core/cache"
@@ -24,13 +26,25 @@ type Cache struct {
 
 var (
 	instance *Cache
-	once     sync.Once
+	lock     sync.Mutex
 )
 
-// New represents a new redis client
-func New(config *Config) *Cache {
+const (
+	// lifetime of the lock taken by GetOrSet while the loader runs
+	loaderLockTTL = time.Second * 5
+	// how often GetOrSet checks for the value while another node runs the loader
+	loaderPollInterval = time.Millisecond * 50
+	// number of keys requested on each SCAN call
+	scanCount = 1000
+)
+
+// New returns the redis client, connecting to the server on the first call. A client that failed to
+// connect is not kept so the next call tries to connect again
+func New(config *Config) (*Cache, error) {
+	lock.Lock()
+	defer lock.Unlock()
 	if instance != nil {
-		return instance
+		return instance, nil
 	}
 	options, err := redis.ParseURL(config.Addr)
 	if err != nil {
@@ -45,19 +59,17 @@ func New(config *Config) *Cache {
 	}
 
 	client := redis.NewClient(options)
-	_, err = client.Ping().Result()
-	if err != nil {
-		_ = fmt.Errorf("redis connection error: %s", err)
-	}
-	once.Do(func() {
-		instance = &Cache{
-			config:       config,
-			redis:        client,
-			closeChannel: make(chan struct{}),
-			subscription: make(map[string]*SubscriptionInfo),
-		}
-	})
-	return instance
+	if _, err = client.Ping().Result(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("redis connection error: %s", err)
+	}
+	instance = &Cache{
+		config:       config,
+		redis:        client,
+		closeChannel: make(chan struct{}),
+		subscription: make(map[string]*SubscriptionInfo),
+	}
+	return instance, nil
 }
 
 // Get retrieves value at key from cache
@@ -71,14 +83,8 @@ func (c *Cache) Get(key string, value interface{}) (err error) {
 
 // Has checks if key is available in cache
 func (c *Cache) Has(key string) (ok bool) {
-	item, err := c.redis.Keys(key).Result()
-	if err != nil {
-		return false
-	}
-	if len(item) != 0 {
-		return true
-	}
-	return false
+	n, err := c.redis.Exists(key).Result()
+	return err == nil && n != 0
 }
 
 // Set stores a key with a given life time. 0 for permanent
@@ -91,20 +97,138 @@ func (c *Cache) Set(key string, value interface{}, ttl time.Duration) (err error
 	return err
 }
 
+// SetNX stores a key with a given life time only if it doesn't exist, using SET NX, and returns true
+// if it was stored. 0 for permanent
+func (c *Cache) SetNX(key string, value interface{}, ttl time.Duration) (ok bool, err error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	return c.redis.SetNX(key, raw, ttl).Result()
+}
+
+// compare-and-delete and compare-and-expire scripts used to release and refresh locks
+var (
+	delIfEqual    = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+	expireIfEqual = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`)
+)
+
+// DelIfEqual removes the key only if it holds the given value and returns true if it was removed. The
+// value is compared with the stored one in a single atomic operation
+func (c *Cache) DelIfEqual(key string, value interface{}) (ok bool, err error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	n, err := delIfEqual.Run(c.redis, []string{key}, raw).Int64()
+	return n == 1, err
+}
+
+// ExpireIfEqual sets the life time of the key only if it holds the given value and returns true if it
+// was updated. The value is compared with the stored one in a single atomic operation
+func (c *Cache) ExpireIfEqual(key string, value interface{}, ttl time.Duration) (ok bool, err error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	n, err := expireIfEqual.Run(c.redis, []string{key}, raw, ttl.Milliseconds()).Int64()
+	return n == 1, err
+}
+
+// GetOrSet retrieves the value at key and on a miss calls the loader, stores its result for ttl and
+// decodes it in value. To prevent a cache stampede a lock key (<key>:lock) is set with SET NX for a few
+// seconds, so only one node calls the loader while the others wait for the value to be stored. If the
+// value is not stored before the lock expires the waiting nodes take the lock and call the loader themselves
+func (c *Cache) GetOrSet(key string, ttl time.Duration, value interface{}, loader func() (interface{}, error)) (err error) {
+	if err := c.Get(key, value); err == nil {
+		return nil
+	}
+
+	// the lock holds a random token so only this call can release it, after expiring it may be taken by
+	// another node which must keep its lock
+	lockKey := key + ":lock"
+	token, err := lockToken()
+	if err != nil {
+		return err
+	}
+	for {
+		locked, err := c.SetNX(lockKey, token, loaderLockTTL)
+		if err != nil {
+			return err
+		}
+		if locked {
+			break
+		}
+		// another node is loading the value
+		time.Sleep(loaderPollInterval)
+		if err := c.Get(key, value); err == nil {
+			return nil
+		}
+	}
+	defer func() {
+		_, _ = c.DelIfEqual(lockKey, token)
+	}()
+
+	// the value could have been stored while waiting for the lock
+	if err := c.Get(key, value); err == nil {
+		return nil
+	}
+	v, err := loader()
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := c.redis.Set(key, raw, ttl).Err(); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, value)
+}
+
+// lockToken returns a random value identifying the owner of a lock
+func lockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // Del removes a value from redis
 func (c *Cache) Del(key string) (err error) {
 	_, err = c.redis.Del(key).Result()
 	return err
 }
 
-// Keys list all available cache keys
+// Keys list all available cache keys matching the pattern. The keys are iterated with SCAN so the
+// redis server is never blocked, which means keys added or removed during the iteration may or may not
+// be returned. The iteration stops at the first error, returning the keys found so far
 func (c *Cache) Keys(pattern string) (available []string) {
-	result, err := c.redis.Keys(pattern).Result()
-	if err != nil {
-		panic(err)
+	seen := make(map[string]bool)
+	var cursor uint64
+	for {
+		keys, next, err := c.redis.Scan(cursor, pattern, scanCount).Result()
+		if err != nil {
+			return
+		}
+		for _, k := range keys {
+			// SCAN can return the same key more than once
+			if !seen[k] {
+				seen[k] = true
+				available = append(available, k)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return
+		}
 	}
-	return result
+}
 
+// Ping checks the connection to the redis server
+func (c *Cache) Ping() error {
+	return c.redis.Ping().Err()
 }
 
 // Type returns the type of the cache
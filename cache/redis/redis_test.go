@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+// testCache returns a cache connected to an in memory redis server
+func testCache(t *testing.T) (*Cache, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return &Cache{
+		config:       &Config{Addr: mr.Addr()},
+		redis:        client,
+		closeChannel: make(chan struct{}),
+		subscription: make(map[string]*SubscriptionInfo),
+	}, mr
+}
+
+func TestGetOrSetCallsLoaderOnce(t *testing.T) {
+	c, _ := testCache(t)
+	var calls int32
+	loader := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(loaderPollInterval * 2)
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v string
+			if err := c.GetOrSet("key", time.Minute, &v, loader); err != nil {
+				t.Error(err)
+			}
+			if v != "value" {
+				t.Errorf("expected value, got %q", v)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("expected the loader to be called once, got %d", calls)
+	}
+}
+
+func TestGetOrSetKeepsLockOfAnotherNode(t *testing.T) {
+	c, mr := testCache(t)
+	var v string
+	err := c.GetOrSet("key", time.Minute, &v, func() (interface{}, error) {
+		// the loader is slower than the lock, which expires and is taken by another node
+		mr.FastForward(loaderLockTTL + time.Second)
+		if ok, err := c.SetNX("key:lock", "other", loaderLockTTL); err != nil || !ok {
+			t.Fatalf("the lock was not released on expiry: %v", err)
+		}
+		return "value", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var owner string
+	if err := c.Get("key:lock", &owner); err != nil || owner != "other" {
+		t.Errorf("expected the lock of the other node to be kept, got %q %v", owner, err)
+	}
+}
+
+func TestGetOrSetReleasesLock(t *testing.T) {
+	c, _ := testCache(t)
+	var v string
+	if err := c.GetOrSet("key", time.Minute, &v, func() (interface{}, error) {
+		return "value", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Has("key:lock") {
+		t.Error("expected the lock to be released")
+	}
+}
@@ -0,0 +1,21 @@
+package redis
+
+func (c *Cache) SAdd(key string, members ...string) error {
+	return c.redis.SAdd(key, toInterfaces(members)...).Err()
+}
+
+func (c *Cache) SRem(key string, members ...string) error {
+	return c.redis.SRem(key, toInterfaces(members)...).Err()
+}
+
+func (c *Cache) SMembers(key string) ([]string, error) {
+	return c.redis.SMembers(key).Result()
+}
+
+func toInterfaces(values []string) []interface{} {
+	res := make([]interface{}, len(values))
+	for i, v := range values {
+		res[i] = v
+	}
+	return res
+}
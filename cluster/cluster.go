@@ -4,22 +4,33 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	redisDriver "github.com/go-redis/redis"
 	"github.com/najibulloShapoatov/server-core/cache"
 	"github.com/najibulloShapoatov/server-core/cache/redis"
+	"github.com/najibulloShapoatov/server-core/monitoring/log"
+	"github.com/najibulloShapoatov/server-core/utils"
 	"github.com/najibulloShapoatov/server-core/utils/net"
 )
 
 type MessageHandler func(*Message)
 
-type nodeInfo struct {
+// NodeInfo describes a node of the cluster
+type NodeInfo struct {
+	ID       int       `json:"id"`
 	IP       string    `json:"ip"`
 	LastSeen time.Time `json:"lastSeen"`
 }
 
+// stale returns true if the node didn't ping the cluster in time
+func (n *NodeInfo) stale(now time.Time) bool {
+	return n.LastSeen.Add(pingTime).Before(now)
+}
+
 type sharedLock struct {
 	Name   string
 	Time   time.Time
@@ -34,8 +45,17 @@ type Cluster struct {
 
 	handler MessageHandler
 
-	cache  *redis.Cache
-	pubSub *redisDriver.PubSub
+	// connection hooks
+	onDisconnect func()
+	onReconnect  func()
+
+	cache   *redis.Cache
+	subLock sync.Mutex
+	pubSub  *redisDriver.PubSub
+
+	// connection state, only used by the ping loop
+	connected bool
+	failures  int
 
 	key         string
 	channelName string
@@ -44,6 +64,10 @@ type Cluster struct {
 	stop chan bool
 
 	activeLocks []sharedLock
+
+	// replies channels of the requests waiting for replies by correlation id
+	pendingLock sync.Mutex
+	pending     map[string]chan *Message
 }
 
 // Join a cluster by name
@@ -61,17 +85,17 @@ func Join(name string) (cluster *Cluster, err error) {
 		name:        name,
 		cache:       red,
 		stop:        make(chan bool),
+		pending:     make(map[string]chan *Message),
 		ip:          net.GetLocalAddr(),
+		connected:   true,
 	}
 
 	// obtain node id
 	cluster.nodeID = red.HInc(cluster.key, redisIncrementProp)
 
-	cluster.writeNodeInfo()
-
-	cluster.pubSub = red.Subscribe(cluster.channelName, cluster.listener)
-	msg, _ := cluster.wrapMessage(nodeJoined, cluster.nodeID)
-	if err = cluster.cache.Publish(cluster.channelName, msg).Err(); err != nil {
+	cluster.subscribe()
+	if err = cluster.announce(); err != nil {
+		cluster.unsubscribe()
 		return nil, err
 	}
 	go cluster.ping()
@@ -88,13 +112,93 @@ func (c *Cluster) Leave() (err error) {
 	// Remove itself from cluster table
 	_ = c.cache.HDel(c.key, fmt.Sprintf("%d", c.nodeID))
 
-	if c.pubSub != nil {
-		_ = c.pubSub.Close()
-	}
+	c.unsubscribe()
 	close(c.stop)
 	return
 }
 
+// OnDisconnect registers a callback called when the connection to redis is lost
+func (c *Cluster) OnDisconnect(callback func()) {
+	c.onDisconnect = callback
+}
+
+// OnReconnect registers a callback called when the connection to redis is restored, after the node
+// subscribed again to the cluster messages and announced itself
+func (c *Cluster) OnReconnect(callback func()) {
+	c.onReconnect = callback
+}
+
+// subscribe listens for the cluster messages
+func (c *Cluster) subscribe() {
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+	c.pubSub = c.cache.Subscribe(c.channelName, c.listener)
+}
+
+// unsubscribe stops listening for the cluster messages
+func (c *Cluster) unsubscribe() {
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+	if c.pubSub == nil {
+		return
+	}
+	c.cache.Unsubscribe(c.channelName)
+	_ = c.pubSub.Close()
+	c.pubSub = nil
+}
+
+// announce writes the node info and tells the other nodes that this node joined
+func (c *Cluster) announce() error {
+	if err := c.writeNodeInfo(); err != nil {
+		return err
+	}
+	msg, _ := c.wrapMessage(nodeJoined, c.nodeID)
+	return c.cache.Publish(c.channelName, msg).Err()
+}
+
+// checkConnection pings redis and when the connection is restored subscribes again and announces the
+// node. It returns the delay until the next check, which grows while redis can't be reached
+func (c *Cluster) checkConnection() time.Duration {
+	err := c.cache.Ping()
+	if err == nil && c.connected {
+		return checkTime
+	}
+	if err != nil {
+		if c.connected {
+			c.connected = false
+			c.failures = 0
+			log.Warnf("cluster %s: redis connection lost: %s", c.name, err)
+			if c.onDisconnect != nil {
+				go c.onDisconnect()
+			}
+		}
+		return c.backoff()
+	}
+
+	c.unsubscribe()
+	c.subscribe()
+	if err := c.announce(); err != nil {
+		return c.backoff()
+	}
+	c.connected = true
+	log.Infof("cluster %s: redis connection restored", c.name)
+	if c.onReconnect != nil {
+		go c.onReconnect()
+	}
+	return checkTime
+}
+
+// backoff returns the delay before the next reconnection attempt, doubling after each failure up to
+// the ping time
+func (c *Cluster) backoff() time.Duration {
+	c.failures++
+	delay := time.Second << uint(c.failures-1)
+	if delay <= 0 || delay > pingTime {
+		delay = pingTime
+	}
+	return delay
+}
+
 // Send a message to all other nodes
 func (c *Cluster) Broadcast(payload interface{}) (err error) {
 	msg, err := c.wrapMessage(nodeBroadcast, payload)
@@ -104,6 +208,83 @@ func (c *Cluster) Broadcast(payload interface{}) (err error) {
 	return c.cache.Publish(c.channelName, msg).Err()
 }
 
+// Request sends a message to all the nodes and collects the replies sent with Reply until the timeout
+// expires. The handlers registered with OnMessage receive the request and can check it with IsRequest
+func (c *Cluster) Request(payload interface{}, timeout time.Duration) ([]*Message, error) {
+	msg, err := c.wrapMessage(nodeRequest, payload)
+	if err != nil {
+		return nil, err
+	}
+	msg.CorrelationID = utils.NewUID().String()
+
+	replies := make(chan *Message, 64)
+	c.pendingLock.Lock()
+	c.pending[msg.CorrelationID] = replies
+	c.pendingLock.Unlock()
+	defer func() {
+		c.pendingLock.Lock()
+		delete(c.pending, msg.CorrelationID)
+		c.pendingLock.Unlock()
+	}()
+
+	if err = c.cache.Publish(c.channelName, msg).Err(); err != nil {
+		return nil, err
+	}
+
+	var res []*Message
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case reply := <-replies:
+			res = append(res, reply)
+		case <-timer.C:
+			return res, nil
+		}
+	}
+}
+
+// Reply sends the payload back to the node that sent the request
+func (c *Cluster) Reply(request *Message, payload interface{}) error {
+	if !request.IsRequest() {
+		return errors.New("message is not a request")
+	}
+	msg, err := c.wrapMessage(nodeReply, payload)
+	if err != nil {
+		return err
+	}
+	msg.CorrelationID = request.CorrelationID
+	return c.cache.Publish(c.channelName, msg).Err()
+}
+
+// Nodes returns the active nodes of the cluster, ordered by id. Nodes that didn't ping the cluster
+// recently are left out
+func (c *Cluster) Nodes() ([]NodeInfo, error) {
+	records, err := c.cache.HGetAll(c.key)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	res := make([]NodeInfo, 0, len(records))
+	for k, v := range records {
+		if k == redisIncrementProp {
+			continue
+		}
+		var node NodeInfo
+		if err := json.Unmarshal([]byte(v), &node); err != nil {
+			continue
+		}
+		if node.stale(now) {
+			continue
+		}
+		// the field name is the node id, entries written before the id was stored don't have it
+		node.ID, _ = strconv.Atoi(k)
+		res = append(res, node)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
 func (c *Cluster) ID() int {
 	return c.nodeID
 }
@@ -132,41 +313,71 @@ func (c *Cluster) Lock(name string) error {
 		}
 	}
 
-	var lock sharedLock
-	if _ = c.cache.Get(fmt.Sprintf(redisLocksKey, c.name, name), &lock); !lock.Time.IsZero() {
-		return fmt.Errorf("lock already acquired by %d", lock.NodeId)
-	}
-
-	lock = sharedLock{
+	lock := sharedLock{
 		Name:   name,
 		Time:   time.Now(),
 		NodeId: c.nodeID,
 	}
+	// SET NX makes sure only one node acquires the lock
+	key := fmt.Sprintf(redisLocksKey, c.name, name)
+	acquired, err := c.cache.SetNX(key, lock, lockTTL)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		var owner sharedLock
+		_ = c.cache.Get(key, &owner)
+		return fmt.Errorf("lock already acquired by %d", owner.NodeId)
+	}
 	c.activeLocks = append(c.activeLocks, lock)
-	return c.cache.Set(fmt.Sprintf(redisLocksKey, c.name, name), lock, lockTTL)
+	return nil
 }
 
 func (c *Cluster) Unlock(name string) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	var found bool
+	var (
+		lock  sharedLock
+		found bool
+	)
 	// remove from active locks
 	for idx, n := range c.activeLocks {
 		if n.Name == name {
-			found = true
-			if idx == len(c.activeLocks)-1 {
-				c.activeLocks = c.activeLocks[:idx]
-			} else {
-				c.activeLocks = append(c.activeLocks[:idx], c.activeLocks[idx+1:]...)
-			}
+			lock, found = n, true
+			c.activeLocks = append(c.activeLocks[:idx], c.activeLocks[idx+1:]...)
 			break
 		}
 	}
 	if !found {
 		return errors.New("no such lock")
 	}
-	return c.cache.Del(fmt.Sprintf(redisLocksKey, c.name, name))
+	// the key is removed only if it still holds our lock, it may have expired and been taken by another node
+	deleted, err := c.cache.DelIfEqual(fmt.Sprintf(redisLocksKey, c.name, name), lock)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errors.New("lock is no longer owned by this node")
+	}
+	return nil
+}
+
+// refreshLocks extends the life time of the locks held by this node. Locks that expired and were
+// acquired by another node are dropped
+func (c *Cluster) refreshLocks() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	active := c.activeLocks[:0]
+	for _, lock := range c.activeLocks {
+		ok, err := c.cache.ExpireIfEqual(fmt.Sprintf(redisLocksKey, c.name, lock.Name), lock, lockTTL)
+		if err == nil && !ok {
+			continue
+		}
+		active = append(active, lock)
+	}
+	c.activeLocks = active
 }
 
 // Register a callback to handle cluster messages
@@ -189,39 +400,63 @@ func (c *Cluster) listener(data *redisDriver.Message) {
 		// id, _ := msg.Int()
 	case nodeLeave:
 		// id, _ := msg.Int()
-	case nodeBroadcast:
+	case nodeBroadcast, nodeRequest:
 		if c.handler != nil {
 			c.handler(&msg)
 		}
+	case nodeReply:
+		c.pendingLock.Lock()
+		replies, ok := c.pending[msg.CorrelationID]
+		c.pendingLock.Unlock()
+		if ok {
+			select {
+			case replies <- &msg:
+			default:
+				// the requester is not keeping up, drop the reply
+			}
+		}
 	}
 }
 
-func (c *Cluster) writeNodeInfo() {
+func (c *Cluster) writeNodeInfo() error {
 	// write node info
-	var n = nodeInfo{
+	var n = NodeInfo{
+		ID:       c.nodeID,
 		IP:       c.ip,
 		LastSeen: time.Now(),
 	}
 	data, _ := json.Marshal(n)
-	_ = c.cache.HSet(c.key, fmt.Sprintf("%d", c.nodeID), string(data))
+	return c.cache.HSet(c.key, fmt.Sprintf("%d", c.nodeID), string(data))
 }
 
 func (c *Cluster) ping() {
 	timer := time.NewTicker(pingTime)
 	gcTimer := time.NewTicker(pingTime * 2)
-	lockTimer := time.NewTicker(lockTTL)
+	// refresh the locks before they expire
+	lockTimer := time.NewTicker(lockTTL / 2)
+	checkTimer := time.NewTimer(checkTime)
+	defer func() {
+		timer.Stop()
+		gcTimer.Stop()
+		lockTimer.Stop()
+		checkTimer.Stop()
+	}()
 	for {
 		select {
 
+		// detect lost connections and reconnect
+		case <-checkTimer.C:
+			checkTimer.Reset(c.checkConnection())
+
 		// maintain acquired locks by this node
 		case <-lockTimer.C:
-			for _, lock := range c.activeLocks {
-				_ = c.cache.Set(fmt.Sprintf(redisLocksKey, c.name, lock.Name), lock, lockTTL)
-			}
+			c.refreshLocks()
 
 		// update cluster nodes list to set current time
 		case <-timer.C:
-			c.writeNodeInfo()
+			if c.connected {
+				_ = c.writeNodeInfo()
+			}
 
 		// stop everything
 		case <-c.stop:
@@ -241,11 +476,11 @@ func (c *Cluster) ping() {
 				if k == "nodeId" {
 					continue
 				}
-				var node nodeInfo
+				var node NodeInfo
 				if err := json.Unmarshal([]byte(v), &node); err != nil {
 					continue
 				}
-				if node.LastSeen.Add(pingTime).Before(now) {
+				if node.stale(now) {
 					_ = c.cache.HDel(c.key, k)
 				}
 			}
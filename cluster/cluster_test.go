@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/najibulloShapoatov/server-core/cache/redis"
+)
+
+var (
+	testRedis     *miniredis.Miniredis
+	testRedisOnce sync.Once
+)
+
+// testNodes returns nodes of the same cluster sharing an in memory redis server. The redis cache is a
+// singleton so the server is shared by the tests and emptied for each of them
+func testNodes(t *testing.T, count int) ([]*Cluster, *miniredis.Miniredis) {
+	t.Helper()
+	testRedisOnce.Do(func() {
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		testRedis = mr
+	})
+	testRedis.FlushAll()
+	c, err := redis.New(&redis.Config{Addr: testRedis.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes []*Cluster
+	for i := 1; i <= count; i++ {
+		nodes = append(nodes, &Cluster{name: "test", nodeID: i, cache: c})
+	}
+	return nodes, testRedis
+}
+
+func TestLockRace(t *testing.T) {
+	nodes, _ := testNodes(t, 10)
+
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		acquired []int
+	)
+	start := make(chan struct{})
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n *Cluster) {
+			defer wg.Done()
+			<-start
+			if n.Lock("job") == nil {
+				lock.Lock()
+				acquired = append(acquired, n.nodeID)
+				lock.Unlock()
+			}
+		}(n)
+	}
+	close(start)
+	wg.Wait()
+	if len(acquired) != 1 {
+		t.Fatalf("expected exactly one node to acquire the lock, got %v", acquired)
+	}
+
+	owner := nodes[acquired[0]-1]
+	other := nodes[acquired[0]%len(nodes)]
+	if err := other.Unlock("job"); err == nil {
+		t.Error("expected a node to fail releasing a lock it doesn't hold")
+	}
+	if err := owner.Unlock("job"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Lock("job"); err != nil {
+		t.Errorf("expected the released lock to be acquired, got %v", err)
+	}
+}
+
+func TestLockOwnership(t *testing.T) {
+	tests := []struct {
+		name string
+		// expire the lock of the first node and let the second one acquire it
+		takenOver bool
+		unlockErr bool
+		kept      bool
+	}{
+		{"owner releases its lock", false, false, false},
+		{"expired lock taken by another node", true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, mr := testNodes(t, 2)
+			first, second := nodes[0], nodes[1]
+			if err := first.Lock("job"); err != nil {
+				t.Fatal(err)
+			}
+			if err := second.Lock("job"); err == nil {
+				t.Fatal("expected the lock to be held by the first node")
+			}
+			if tt.takenOver {
+				mr.FastForward(lockTTL * 2)
+				if err := second.Lock("job"); err != nil {
+					t.Fatalf("expected the expired lock to be acquired, got %v", err)
+				}
+				// the refresh drops the lock the node lost
+				first.refreshLocks()
+				if len(first.activeLocks) != 0 {
+					t.Errorf("expected the lost lock to be dropped, got %v", first.activeLocks)
+				}
+				first.activeLocks = append(first.activeLocks, sharedLock{Name: "job", NodeId: first.nodeID})
+			}
+
+			if err := first.Unlock("job"); (err != nil) != tt.unlockErr {
+				t.Errorf("expected unlock error %v, got %v", tt.unlockErr, err)
+			}
+			if got := mr.Exists(fmt.Sprintf(redisLocksKey, "test", "job")); got != tt.kept {
+				t.Errorf("expected the lock key to be kept %v", tt.kept)
+			}
+		})
+	}
+}
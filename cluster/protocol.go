@@ -8,6 +8,8 @@ import (
 var (
 	pingTime = time.Second * 30
 	lockTTL  = time.Second * 3
+	// how often the redis connection is checked
+	checkTime = time.Second * 5
 )
 
 const (
@@ -24,12 +26,21 @@ const (
 	nodeJoined                       // 1
 	nodeLeave                        // 2
 	nodeBroadcast                    // 3
+	nodeRequest                      // 4
+	nodeReply                        // 5
 )
 
 type Message struct {
 	Type   messageType     `json:"type"`
 	NodeID int             `json:"nodeID"`
 	Data   json.RawMessage `json:"data"`
+	// CorrelationID links the replies to the request they answer
+	CorrelationID string `json:"correlationID,omitempty"`
+}
+
+// IsRequest returns true if the message was sent with Request and expects a reply
+func (m *Message) IsRequest() bool {
+	return m.Type == nodeRequest
 }
 
 func (m *Message) UnmarshalBinary(data []byte) error {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sort"
 	"time"
 )
 
@@ -28,6 +29,102 @@ func (e *Entry) Tag(key string, value interface{}) *Entry {
 	return e
 }
 
+// WithField returns a log entry carrying the given field
+func WithField(key string, value interface{}) *Entry {
+	return getEntry(0).Tag(key, value)
+}
+
+// WithFields returns a log entry carrying the given fields. The entry is logged by calling one of
+// its level methods and must not be used afterwards
+//
+//	log.WithFields(map[string]interface{}{"user": id, "ip": addr}).Info("user logged in")
+func WithFields(fields map[string]interface{}) *Entry {
+	return getEntry(0).WithFields(fields)
+}
+
+// WithField adds the field to the entry
+func (e *Entry) WithField(key string, value interface{}) *Entry {
+	return e.Tag(key, value)
+}
+
+// WithFields adds the fields to the entry, sorted by name
+func (e *Entry) WithFields(fields map[string]interface{}) *Entry {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e.Tag(k, fields[k])
+	}
+	return e
+}
+
+func (e *Entry) Panic(args ...interface{}) {
+	e.log(PanicLevel, fmt.Sprint(args...))
+}
+
+func (e *Entry) Panicf(format string, args ...interface{}) {
+	e.log(PanicLevel, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Fatal(args ...interface{}) {
+	e.log(FatalLevel, fmt.Sprint(args...))
+}
+
+func (e *Entry) Fatalf(format string, args ...interface{}) {
+	e.log(FatalLevel, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Error(args ...interface{}) {
+	e.log(ErrorLevel, fmt.Sprint(args...))
+}
+
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	e.log(ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Warn(args ...interface{}) {
+	e.log(WarnLevel, fmt.Sprint(args...))
+}
+
+func (e *Entry) Warnf(format string, args ...interface{}) {
+	e.log(WarnLevel, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Info(args ...interface{}) {
+	e.log(InfoLevel, fmt.Sprint(args...))
+}
+
+func (e *Entry) Infof(format string, args ...interface{}) {
+	e.log(InfoLevel, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Debug(args ...interface{}) {
+	e.log(DebugLevel, fmt.Sprint(args...))
+}
+
+func (e *Entry) Debugf(format string, args ...interface{}) {
+	e.log(DebugLevel, fmt.Sprintf(format, args...))
+}
+
+// log writes the message with the given level and hands the entry to the writer
+func (e *Entry) log(lvl Level, msg string) {
+	if logLevel < lvl || closing {
+		entries.Put(e)
+		return
+	}
+	e.level = lvl
+	e.time = time.Now()
+	e.message.WriteString(msg)
+	if lvl == FatalLevel {
+		annotate(e)
+		printLog(e)
+		return
+	}
+	enqueue(e)
+}
+
 func encode(buf io.Writer, key string, value interface{}) {
 	_, _ = fmt.Fprintf(buf, "%s=\"%v\"\x00", key, value)
 }
@@ -48,10 +48,10 @@ func (f *textFormatter) Format(entry *Entry) []byte {
 
 	// write entry tags
 	if entry.tags.Len() != 0 {
-		buf.Write(bytes.ReplaceAll(entry.tags.Bytes(), []byte("\x00"), []byte(" ")))
+		buf.Write(bytes.ReplaceAll(sanitize(entry.tags.Bytes()), []byte("\x00"), []byte(" ")))
 	}
 	// write entry message
-	buf.Write(entry.message.Bytes())
+	buf.Write(sanitize(entry.message.Bytes()))
 	buf.WriteString("\n")
 
 	return buf.Bytes()
@@ -78,15 +78,15 @@ func (f *jsonFormatter) Format(entry *Entry) []byte {
 	}
 	// write entry tags
 	if entry.tags.Len() != 0 {
-		tags := strings.Split(entry.tags.String(), "\x00")
+		tags := strings.Split(string(sanitize(entry.tags.Bytes())), "\x00")
 		for _, tag := range tags {
-			parts := strings.Split(tag, "=")
+			parts := strings.SplitN(tag, "=", 2)
 			if len(parts) == 2 {
 				msg[parts[0]] = strings.Trim(parts[1], `"`)
 			}
 		}
 	}
-	msg["message"] = entry.message.String()
+	msg["message"] = string(sanitize(entry.message.Bytes()))
 	res, err := json.Marshal(msg)
 	if err != nil {
 		return nil
@@ -0,0 +1,43 @@
+package log
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFormattersEscapeNewlines(t *testing.T) {
+	const injected = "login failed\r\n[FATAL] injected"
+	tests := []struct {
+		name      string
+		formatter Formatter
+	}{
+		{"text", NewTextFormatter(nil)},
+		{"json", NewJSONFormatter(nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := getEntry(InfoLevel)
+			defer entries.Put(entry)
+			encode(entry.tags, "user", "john\n[FATAL] tag")
+			entry.message.WriteString(injected)
+
+			out := strings.TrimSuffix(string(tt.formatter.Format(entry)), "\n")
+			if strings.ContainsAny(out, "\r\n") {
+				t.Fatalf("expected a single line, got %q", out)
+			}
+			if tt.name == "json" {
+				var msg map[string]interface{}
+				if err := json.Unmarshal([]byte(out), &msg); err != nil {
+					t.Fatal(err)
+				}
+				out = msg["message"].(string) + " " + msg["user"].(string)
+			}
+			for _, want := range []string{`login failed\n[FATAL] injected`, `john\n[FATAL] tag`} {
+				if !strings.Contains(out, want) {
+					t.Errorf("expected the escaped %q in %q", want, out)
+				}
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"regexp"
 	"strings"
+	"time"
 )
 
 var (
@@ -15,20 +16,31 @@ var (
 	logWriter io.WriteCloser
 	// default log formatter
 	logFormatter Formatter
+	// what happens with new entries when the queue is full
+	queuePolicy QueuePolicy
 )
 
+// LevelWriter is implemented by writers that handle the level of the entries themselves
+type LevelWriter interface {
+	WriteLevel(lvl Level, p []byte) (n int, err error)
+}
+
 // Formatter is responsible to generate a byte array representing a log event in a specific format (json/txt/xml/etc)
 type Formatter interface {
 	Format(Entry *Entry) []byte
 }
 
-// Level of severity for a log entry
+// Level of severity for a log entry. Levels are ordered from the most severe to the most verbose and
+// the configured level enables itself and all the levels before it, so WarnLevel logs warnings, errors,
+// fatal and panic entries while OffLevel disables logging entirely
 type Level byte
 
 const (
+	// OffLevel disables all the log entries
+	OffLevel Level = iota
 	// PanicLevel level, highest level of severity. Logs and then calls panic with the
 	// message passed to Debug, Info, ...
-	PanicLevel Level = iota + 1
+	PanicLevel
 	// FatalLevel level. Logs and then calls `os.Exit(1)`. It will exit even if the
 	// logging level is set to Panic.
 	FatalLevel
@@ -58,47 +70,105 @@ func (l Level) String() string {
 		return "INFO"
 	case DebugLevel:
 		return "DEBUG"
+	case OffLevel:
+		return "OFF"
 	}
 	return "UNKNOWN"
 }
 
-type Config struct {
-	Writer    string `config:"log.writer" default:"stdout"`
-	Formatter string `config:"log.format" default:"text"`
-	Level     string `config:"log.level" default:"warning"`
-	MaxSize   int64  `config:"log.maxFileSize" default:"10000000"` // 10MB
-}
-
-func Setup(cfg Config) error {
-	// parse debug level
-	low := strings.ToLower(cfg.Level)
-	switch low {
-	default:
+// ParseLevel returns the level with the given name (off, panic, fatal, error, warning, info or debug)
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
 	case "off", "disabled", "none":
-		SetLevel(0)
+		return OffLevel, nil
 	case "panic":
-		SetLevel(PanicLevel)
+		return PanicLevel, nil
 	case "fatal":
-		SetLevel(FatalLevel)
+		return FatalLevel, nil
 	case "error":
-		SetLevel(ErrorLevel)
+		return ErrorLevel, nil
 	case "warning", "warn":
-		SetLevel(WarnLevel)
+		return WarnLevel, nil
 	case "info":
-		SetLevel(InfoLevel)
+		return InfoLevel, nil
 	case "debug":
-		SetLevel(DebugLevel)
+		return DebugLevel, nil
+	}
+	return OffLevel, fmt.Errorf("invalid log level: %s", name)
+}
+
+// QueuePolicy decides what happens with new log entries when the queue of entries waiting to be
+// written is full
+type QueuePolicy byte
+
+const (
+	// DropPolicy drops the new entries and counts them so logging never slows down the caller.
+	// The number of dropped entries is reported periodically
+	DropPolicy QueuePolicy = iota
+	// BlockPolicy blocks the caller until there is room in the queue so no entry is lost
+	BlockPolicy
+)
+
+type Config struct {
+	// Writer is stdout, none, syslog, syslog://host:port or the path of a log file
+	Writer      string        `config:"log.writer" default:"stdout"`
+	Formatter   string        `config:"log.format" default:"text"`
+	Level       string        `config:"log.level" default:"warning"`
+	MaxSize     int64         `config:"log.maxFileSize" default:"10000000"` // 10MB
+	MaxBackups  int           `config:"log.maxBackups" default:"0"`         // rotated files to keep, 0 keeps all
+	MaxAge      time.Duration `config:"log.maxAge" default:"0"`             // age of the rotated files to keep, 0 keeps all
+	Compress    bool          `config:"log.compress" default:"no"`          // gzip the rotated files
+	QueuePolicy string        `config:"log.queuePolicy" default:"drop"`     // drop or block
+	// ReportCaller adds the source of the log call to the entries of all levels
+	ReportCaller bool `config:"log.reportCaller" default:"no"`
+}
+
+func Setup(cfg Config) error {
+	// parse debug level
+	lvl, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	SetLevel(lvl)
+	SetReportCaller(cfg.ReportCaller)
+
+	// parse queue policy
+	switch strings.ToLower(cfg.QueuePolicy) {
+	case "", "drop":
+		SetQueuePolicy(DropPolicy)
+	case "block":
+		SetQueuePolicy(BlockPolicy)
+	default:
+		return fmt.Errorf("invalid log queue policy: %s", cfg.QueuePolicy)
 	}
 
 	// parse writer
-	low = strings.ToLower(cfg.Writer)
+	low := strings.ToLower(cfg.Writer)
 	switch {
 	case low == "none", low == "disabled":
 		SetWriter(NewNilWriter())
 	case low == "stdout":
 		SetWriter(NewDefaultWriter())
+	case low == "syslog":
+		w, err := NewSyslogWriter("", "", "")
+		if err != nil {
+			return err
+		}
+		SetWriter(w)
+	case strings.HasPrefix(low, "syslog://"):
+		// remote syslog daemon, ex: syslog://logs.example.com:514
+		w, err := NewSyslogWriter("udp", cfg.Writer[len("syslog://"):], "")
+		if err != nil {
+			return err
+		}
+		SetWriter(w)
 	case isFilePath(low):
-		f, err := NewFileWriter(cfg.Writer, cfg.MaxSize)
+		f, err := NewFileWriterWithOptions(cfg.Writer, FileWriterOptions{
+			MaxSize:    cfg.MaxSize,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAge,
+			Compress:   cfg.Compress,
+		})
 		if err != nil {
 			return err
 		}
@@ -135,8 +205,13 @@ func SetLevel(lvl Level) {
 	logLevel = lvl
 }
 
+func SetQueuePolicy(policy QueuePolicy) {
+	queuePolicy = policy
+}
+
 func init() {
 	go processLogs()
+	go reportDropped()
 }
 
 // isFilePath check is a string is Win or Unix file path
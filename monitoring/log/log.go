@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"reflect"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var (
@@ -25,15 +28,24 @@ var (
 	// closing means the server is going down and we want to flush the queue
 	// so no new logs should be accepted
 	closing bool
+	// number of entries dropped because the queue was full
+	dropped int64
+	// number of dropped entries that were not yet reported
+	droppedUnreported int64
+	// add the source of the log call to the entries of all levels
+	reportCaller bool
 )
 
+// how often a warning is emitted with the number of dropped log entries
+const droppedReportInterval = time.Second * 10
+
 func Panic(args ...interface{}) {
 	if logLevel < PanicLevel || closing {
 		return
 	}
 	entry := getEntry(PanicLevel)
 	_, _ = fmt.Fprint(entry.message, args...)
-	queue <- entry
+	enqueue(entry)
 }
 
 func Panicf(format string, args ...interface{}) {
@@ -42,7 +54,7 @@ func Panicf(format string, args ...interface{}) {
 	}
 	entry := getEntry(PanicLevel)
 	_, _ = fmt.Fprintf(entry.message, format, args...)
-	queue <- entry
+	enqueue(entry)
 }
 
 func Fatal(args ...interface{}) {
@@ -51,6 +63,7 @@ func Fatal(args ...interface{}) {
 	}
 	entry := getEntry(FatalLevel)
 	_, _ = fmt.Fprint(entry.message, args...)
+	annotate(entry)
 	printLog(entry)
 }
 
@@ -60,6 +73,7 @@ func Fatalf(format string, args ...interface{}) {
 	}
 	entry := getEntry(FatalLevel)
 	_, _ = fmt.Fprintf(entry.message, format, args...)
+	annotate(entry)
 	printLog(entry)
 }
 
@@ -69,7 +83,7 @@ func Error(args ...interface{}) {
 	}
 	entry := getEntry(ErrorLevel)
 	_, _ = fmt.Fprint(entry.message, args...)
-	queue <- entry
+	enqueue(entry)
 }
 
 func Errorf(format string, args ...interface{}) {
@@ -78,7 +92,7 @@ func Errorf(format string, args ...interface{}) {
 	}
 	entry := getEntry(ErrorLevel)
 	_, _ = fmt.Fprintf(entry.message, format, args...)
-	queue <- entry
+	enqueue(entry)
 }
 
 func Warn(args ...interface{}) {
@@ -87,7 +101,7 @@ func Warn(args ...interface{}) {
 	}
 	entry := getEntry(WarnLevel)
 	_, _ = fmt.Fprint(entry.message, args...)
-	queue <- entry
+	enqueue(entry)
 }
 
 func Warnf(format string, args ...interface{}) {
@@ -96,7 +110,7 @@ func Warnf(format string, args ...interface{}) {
 	}
 	entry := getEntry(WarnLevel)
 	_, _ = fmt.Fprintf(entry.message, format, args...)
-	queue <- entry
+	enqueue(entry)
 }
 
 func Info(args ...interface{}) {
@@ -105,7 +119,7 @@ func Info(args ...interface{}) {
 	}
 	entry := getEntry(InfoLevel)
 	_, _ = fmt.Fprint(entry.message, args...)
-	queue <- entry
+	enqueue(entry)
 }
 
 func Infof(format string, args ...interface{}) {
@@ -114,7 +128,7 @@ func Infof(format string, args ...interface{}) {
 	}
 	entry := getEntry(InfoLevel)
 	_, _ = fmt.Fprintf(entry.message, format, args...)
-	queue <- entry
+	enqueue(entry)
 }
 
 func Debug(args ...interface{}) {
@@ -122,9 +136,8 @@ func Debug(args ...interface{}) {
 		return
 	}
 	entry := getEntry(DebugLevel)
-	debugAnnotations(entry)
 	_, _ = fmt.Fprint(entry.message, args...)
-	queue <- entry
+	enqueue(entry)
 }
 
 func Debugf(format string, args ...interface{}) {
@@ -132,29 +145,91 @@ func Debugf(format string, args ...interface{}) {
 		return
 	}
 	entry := getEntry(DebugLevel)
-	debugAnnotations(entry)
 	_, _ = fmt.Fprintf(entry.message, format, args...)
-	queue <- entry
-}
-
-func debugAnnotations(entry *Entry) {
-	pc, file, line, ok := runtime.Caller(2)
-	caller := runtime.FuncForPC(pc)
-	if ok {
-		short := file
-		for i := len(file) - 1; i > 0; i-- {
-			if file[i] == '/' {
-				short = file[i+1:]
-				break
+	enqueue(entry)
+}
+
+// SetReportCaller adds the source of the log call to the entries of all the levels. The source is
+// always added to debug entries
+func SetReportCaller(enabled bool) {
+	reportCaller = enabled
+}
+
+// package path used to skip the frames of this package when looking for the caller
+var pkgPath = reflect.TypeOf(Entry{}).PkgPath() + "."
+
+// annotate tags the entry with the source file, line, function and goroutine of the code that called
+// the log package. It must be called from the goroutine of the caller
+func annotate(entry *Entry) {
+	if !reportCaller && entry.level != DebugLevel {
+		return
+	}
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, pkgPath) {
+			if frame.Function == "" || strings.HasPrefix(frame.Function, "runtime.") {
+				return
+			}
+			short := frame.File
+			if i := strings.LastIndexByte(short, '/'); i >= 0 {
+				short = short[i+1:]
 			}
+			b := make([]byte, 20)
+			runtime.Stack(b, false)
+			var goroutineNum int
+			_, _ = fmt.Sscanf(string(b), "goroutine %d ", &goroutineNum)
+
+			entry.Tag("source", fmt.Sprintf("%s:%d:%s[%d]", short, frame.Line, frame.Function, goroutineNum))
+			return
+		}
+		if !more {
+			return
 		}
-		b := make([]byte, 20)
-		runtime.Stack(b, false)
-		var goroutineNum int
-		_, _ = fmt.Sscanf(string(b), "goroutine %d ", &goroutineNum)
+	}
+}
 
-		entry.Tag("source", fmt.Sprintf("%s:%d:%s[%d]", short, line, caller.Name(), goroutineNum))
+// enqueue adds the entry to the queue. If the queue is full the entry is dropped and counted so
+// it can be reported later, unless the queue policy is set to block the caller
+func enqueue(entry *Entry) {
+	annotate(entry)
+	if queuePolicy == BlockPolicy {
+		queue <- entry
+		return
 	}
+	select {
+	case queue <- entry:
+	default:
+		atomic.AddInt64(&dropped, 1)
+		atomic.AddInt64(&droppedUnreported, 1)
+		entries.Put(entry)
+	}
+}
+
+// Dropped returns the total number of log entries dropped because the queue was full
+func Dropped() int64 {
+	return atomic.LoadInt64(&dropped)
+}
+
+// reportDropped periodically emits a warning with the number of entries dropped since the last report
+func reportDropped() {
+	ticker := time.NewTicker(droppedReportInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		flushDropped()
+	}
+}
+
+// flushDropped emits a warning with the number of entries dropped since the last call
+func flushDropped() {
+	n := atomic.SwapInt64(&droppedUnreported, 0)
+	if n == 0 || logLevel < WarnLevel || closing {
+		return
+	}
+	entry := getEntry(WarnLevel)
+	_, _ = fmt.Fprintf(entry.message, "dropped %d log entries", n)
+	enqueue(entry)
 }
 
 // This flag is so that the unit tests won't exist with os.Exit(1) on Fatal messages
@@ -170,16 +245,18 @@ func processLogs() {
 }
 
 func printLog(entry *Entry) {
-	if entry.level == DebugLevel {
-		debugAnnotations(entry)
-	}
 	if logWriter == nil {
 		logWriter = NewDefaultWriter()
 	}
 	if logFormatter == nil {
 		logFormatter = NewTextFormatter(nil)
 	}
-	_, err := logWriter.Write(logFormatter.Format(entry))
+	var err error
+	if w, ok := logWriter.(LevelWriter); ok {
+		_, err = w.WriteLevel(entry.level, logFormatter.Format(entry))
+	} else {
+		_, err = logWriter.Write(logFormatter.Format(entry))
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,218 @@
+package log
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingWriter holds the first write until it is released, which keeps the queue from being drained
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+	lock    sync.Mutex
+	buf     bytes.Buffer
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	<-w.release
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *blockingWriter) Close() error { return nil }
+
+func (w *blockingWriter) String() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.String()
+}
+
+// waitFor polls the condition until it is true or the timeout expires
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	defer func() {
+		SetWriter(NewDefaultWriter())
+		SetQueuePolicy(DropPolicy)
+	}()
+	SetLevel(InfoLevel)
+	SetFormatter(NewTextFormatter(nil))
+
+	const extra = 10
+	tests := []struct {
+		name    string
+		policy  QueuePolicy
+		blocked bool
+	}{
+		{"drop", DropPolicy, false},
+		{"block", BlockPolicy, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newBlockingWriter()
+			SetWriter(w)
+			SetQueuePolicy(tt.policy)
+			atomic.StoreInt64(&droppedUnreported, 0)
+			before := Dropped()
+
+			// the first entry is held by the writer, the next ones fill the queue
+			Info("first")
+			<-w.started
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < cap(queue)+extra; i++ {
+					Info("entry")
+				}
+			}()
+
+			select {
+			case <-done:
+				if tt.blocked {
+					t.Fatal("expected the callers to be blocked while the queue is full")
+				}
+			case <-time.After(time.Millisecond * 200):
+				if !tt.blocked {
+					t.Fatal("the callers are blocked by the full queue")
+				}
+			}
+			close(w.release)
+			<-done
+			waitFor(t, func() bool { return len(queue) == 0 })
+
+			want := int64(0)
+			if !tt.blocked {
+				want = extra
+			}
+			if got := Dropped() - before; got != want {
+				t.Fatalf("expected %d dropped entries, got %d", want, got)
+			}
+			flushDropped()
+			// the writer is replaced by the next test once the queue is drained
+			Info("last")
+			waitFor(t, func() bool { return strings.Contains(w.String(), "last") })
+			if reported := strings.Contains(w.String(), "dropped 10 log entries"); reported == tt.blocked {
+				t.Errorf("expected the dropped entries to be reported %v", !tt.blocked)
+			}
+		})
+	}
+}
+
+// bufferWriter keeps the written logs
+type bufferWriter struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (w *bufferWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *bufferWriter) Close() error { return nil }
+
+func (w *bufferWriter) String() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.String()
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Level
+		wantErr bool
+	}{
+		{"off", OffLevel, false},
+		{"disabled", OffLevel, false},
+		{"PANIC", PanicLevel, false},
+		{"fatal", FatalLevel, false},
+		{" error ", ErrorLevel, false},
+		{"warn", WarnLevel, false},
+		{"warning", WarnLevel, false},
+		{"Info", InfoLevel, false},
+		{"debug", DebugLevel, false},
+		{"trace", OffLevel, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: expected error %v, got %v", tt.name, tt.wantErr, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestLevelThresholds(t *testing.T) {
+	w := &bufferWriter{}
+	SetWriter(w)
+	SetFormatter(NewTextFormatter(nil))
+	defer func() {
+		SetWriter(NewDefaultWriter())
+		SetLevel(InfoLevel)
+	}()
+
+	tests := []struct {
+		level Level
+		want  []string
+	}{
+		{OffLevel, nil},
+		{PanicLevel, []string{"panic"}},
+		{FatalLevel, []string{"panic"}},
+		{ErrorLevel, []string{"panic", "error"}},
+		{WarnLevel, []string{"panic", "error", "warn"}},
+		{InfoLevel, []string{"panic", "error", "warn", "info"}},
+		{DebugLevel, []string{"panic", "error", "warn", "info", "debug"}},
+	}
+	for i, tt := range tests {
+		SetLevel(tt.level)
+		Panic("panic")
+		Error("error")
+		Warn("warn")
+		Info("info")
+		Debug("debug")
+		// the entries are written in order so the marker is written after the accepted entries
+		SetLevel(InfoLevel)
+		marker := "marker " + string(rune('a'+i))
+		Info(marker)
+		waitFor(t, func() bool { return strings.Contains(w.String(), marker) })
+
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(w.String()), "\n") {
+			for _, msg := range []string{"panic", "error", "warn", "info", "debug"} {
+				if strings.HasSuffix(line, " "+msg) {
+					got = append(got, msg)
+				}
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: expected %v, got %v", tt.level, tt.want, got)
+		}
+		w.lock.Lock()
+		w.buf.Reset()
+		w.lock.Unlock()
+	}
+}
@@ -0,0 +1,103 @@
+package log
+
+import (
+	"context"
+)
+
+// Logger logs entries carrying a set of default fields, like the trace id of a request
+type Logger struct {
+	fields map[string]interface{}
+}
+
+type loggerKey struct{}
+
+// NewLogger returns a logger adding the given fields to all its entries
+func NewLogger(fields map[string]interface{}) *Logger {
+	l := &Logger{fields: make(map[string]interface{}, len(fields))}
+	for k, v := range fields {
+		l.fields[k] = v
+	}
+	return l
+}
+
+// NewContext returns a copy of ctx carrying a logger with the fields of the logger already stored in
+// ctx, if any, and the given fields
+func NewContext(ctx context.Context, fields map[string]interface{}) context.Context {
+	return context.WithValue(ctx, loggerKey{}, FromContext(ctx).WithFields(fields))
+}
+
+// FromContext returns the logger stored in ctx by NewContext or a logger without fields
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+			return l
+		}
+	}
+	return &Logger{}
+}
+
+// WithField returns a copy of the logger with the additional field
+func (l *Logger) WithField(key string, value interface{}) *Logger {
+	return l.WithFields(map[string]interface{}{key: value})
+}
+
+// WithFields returns a copy of the logger with the additional fields
+func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
+	res := NewLogger(l.fields)
+	for k, v := range fields {
+		res.fields[k] = v
+	}
+	return res
+}
+
+func (l *Logger) entry() *Entry {
+	return WithFields(l.fields)
+}
+
+func (l *Logger) Panic(args ...interface{}) {
+	l.entry().Panic(args...)
+}
+
+func (l *Logger) Panicf(format string, args ...interface{}) {
+	l.entry().Panicf(format, args...)
+}
+
+func (l *Logger) Fatal(args ...interface{}) {
+	l.entry().Fatal(args...)
+}
+
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.entry().Fatalf(format, args...)
+}
+
+func (l *Logger) Error(args ...interface{}) {
+	l.entry().Error(args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.entry().Errorf(format, args...)
+}
+
+func (l *Logger) Warn(args ...interface{}) {
+	l.entry().Warn(args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.entry().Warnf(format, args...)
+}
+
+func (l *Logger) Info(args ...interface{}) {
+	l.entry().Info(args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.entry().Infof(format, args...)
+}
+
+func (l *Logger) Debug(args ...interface{}) {
+	l.entry().Debug(args...)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.entry().Debugf(format, args...)
+}
@@ -0,0 +1,55 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package log
+
+import (
+	"io"
+	"log/syslog"
+)
+
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+// NewSyslogWriter will return a writer that sends the entries to the syslog daemon at the given address.
+// If network is empty it connects to the local syslog daemon and if tag is empty the program name is used.
+// The level of the entries is mapped to the syslog severity
+func NewSyslogWriter(network, addr, tag string) (io.WriteCloser, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{w: w}, nil
+}
+
+func (w *syslogWriter) Write(p []byte) (n int, err error) {
+	return w.WriteLevel(InfoLevel, p)
+}
+
+// WriteLevel writes the entry with the syslog severity matching the level
+func (w *syslogWriter) WriteLevel(lvl Level, p []byte) (n int, err error) {
+	msg := string(p)
+	switch lvl {
+	case PanicLevel:
+		err = w.w.Emerg(msg)
+	case FatalLevel:
+		err = w.w.Crit(msg)
+	case ErrorLevel:
+		err = w.w.Err(msg)
+	case WarnLevel:
+		err = w.w.Warning(msg)
+	case DebugLevel:
+		err = w.w.Debug(msg)
+	default:
+		err = w.w.Info(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *syslogWriter) Close() error {
+	return w.w.Close()
+}
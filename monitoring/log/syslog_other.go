@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package log
+
+import (
+	"errors"
+	"io"
+)
+
+// NewSyslogWriter is not supported on this platform and always returns an error
+func NewSyslogWriter(network, addr, tag string) (io.WriteCloser, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -1,11 +1,17 @@
 package log
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -40,15 +46,39 @@ func (w defaultWriter) Close() error {
 }
 
 type fileWriter struct {
+	lock     sync.Mutex
 	filename string
 	file     *os.File
 	size     int64
-	maxSize  int64
+	opts     FileWriterOptions
+	// the rotation is not attempted before retryAt after it failed
+	retryAt time.Time
+	// pending compressions of rotated files
+	compressing sync.WaitGroup
+}
+
+// FileWriterOptions configures the rotation of the log files
+type FileWriterOptions struct {
+	// MaxSize of the file after which it is rotated. If set to 0 the file is never rotated
+	MaxSize int64
+	// MaxBackups is the number of rotated files to keep. If set to 0 all the rotated files are kept
+	MaxBackups int
+	// MaxAge of the rotated files after which they are deleted. If set to 0 the files are kept regardless of their age
+	MaxAge time.Duration
+	// Compress the rotated files with gzip. The compressed files get the .gz extension
+	Compress bool
 }
 
 // NewFileWriter will return a writer that writes to the given file up to the maxSize after which it will
 // it will rotate the file. If maxSize is set to 0, then it will not rotate the file.
 func NewFileWriter(filename string, maxSize int64) (io.WriteCloser, error) {
+	return NewFileWriterWithOptions(filename, FileWriterOptions{MaxSize: maxSize})
+}
+
+// NewFileWriterWithOptions will return a writer that writes to the given file and rotates it based on
+// the given options. Rotated files are renamed to <name>_<date>_<timestamp><ext> and the old ones are
+// deleted after each rotation according to MaxBackups and MaxAge
+func NewFileWriterWithOptions(filename string, opts FileWriterOptions) (io.WriteCloser, error) {
 	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 
 	if err != nil {
@@ -57,7 +87,7 @@ func NewFileWriter(filename string, maxSize int64) (io.WriteCloser, error) {
 	w := &fileWriter{
 		filename: filename,
 		file:     f,
-		maxSize:  maxSize,
+		opts:     opts,
 	}
 	// get file size since we need to know when to rotate the files
 	if stat, er := f.Stat(); er == nil {
@@ -66,31 +96,110 @@ func NewFileWriter(filename string, maxSize int64) (io.WriteCloser, error) {
 	return w, nil
 }
 
+// rotateBackoff is the delay before a failed rotation is retried
+const rotateBackoff = time.Minute
+
+// rename moves the rotated file, it is replaced in the tests
+var rename = os.Rename
+
+// checkSize rotates the file when it reaches the max size. It must be called with the lock held
 func (w *fileWriter) checkSize() error {
-	if w.maxSize == 0 {
+	if w.opts.MaxSize == 0 || w.size < w.opts.MaxSize || time.Now().Before(w.retryAt) {
 		return nil
 	}
-	if w.size >= w.maxSize {
-		if er := w.file.Close(); er != nil {
-			return er
+	// the handle can't be used anymore even if Close failed, so the file is always reopened
+	if er := w.file.Close(); er != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "error closing log file: %s\n", er)
+	}
+	ext := filepath.Ext(w.filename)
+	name := strings.TrimSuffix(w.filename, ext)
+	name = fmt.Sprintf("%s_%s_%d%s", name, time.Now().Format("02-Jan-2006"), time.Now().UnixNano(), ext)
+	renameErr := rename(w.filename, name)
+
+	f, er := os.OpenFile(w.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if er != nil {
+		return er
+	}
+	w.file = f
+	if renameErr != nil {
+		// keep writing to the same file and don't retry the rotation on every write
+		_, _ = fmt.Fprintf(os.Stderr, "error rotating log file: %s\n", renameErr)
+		w.retryAt = time.Now().Add(rotateBackoff)
+		return nil
+	}
+	w.retryAt = time.Time{}
+	w.size = 0
+	if w.opts.Compress {
+		w.compressing.Add(1)
+		go w.compress(name)
+	}
+	w.prune()
+	return nil
+}
+
+// backup is a rotated log file
+type backup struct {
+	path string
+	time time.Time
+}
+
+// backups returns the rotated files of the writer, the newest first
+func (w *fileWriter) backups() ([]backup, error) {
+	dir, base := filepath.Split(w.filename)
+	ext := filepath.Ext(base)
+	pattern, err := regexp.Compile("^" + regexp.QuoteMeta(strings.TrimSuffix(base, ext)) +
+		`_\d{2}-[A-Za-z]{3}-\d{4}_(\d+)` + regexp.QuoteMeta(ext) + `(\.gz)?$`)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		dir = "."
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []backup
+	for _, f := range files {
+		m := pattern.FindStringSubmatch(f.Name())
+		if m == nil || f.IsDir() {
+			continue
 		}
-		ext := filepath.Ext(w.filename)
-		name := strings.TrimSuffix(w.filename, ext)
-		name = fmt.Sprintf("%s_%s_%d%s", name, time.Now().Format("02-Jan-2006"), time.Now().UnixNano(), ext)
-		if err := os.Rename(w.filename, name); err != nil {
-			return fmt.Errorf("error rotating log file: %s", err)
+		ns, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			continue
 		}
-		f, er := os.OpenFile(w.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
-		if er != nil {
-			return er
+		res = append(res, backup{path: filepath.Join(dir, f.Name()), time: time.Unix(0, ns)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].time.After(res[j].time)
+	})
+	return res, nil
+}
+
+// prune deletes the rotated files exceeding MaxBackups or older than MaxAge
+func (w *fileWriter) prune() {
+	if w.opts.MaxBackups == 0 && w.opts.MaxAge == 0 {
+		return
+	}
+	files, err := w.backups()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "error listing rotated log files: %s\n", err)
+		return
+	}
+	for i, f := range files {
+		if w.opts.MaxBackups != 0 && i >= w.opts.MaxBackups || w.opts.MaxAge != 0 && time.Since(f.time) > w.opts.MaxAge {
+			if err := os.Remove(f.path); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "error removing rotated log file: %s\n", err)
+			}
 		}
-		w.file = f
-		w.size = 0
 	}
-	return nil
 }
 
 func (w *fileWriter) Write(p []byte) (n int, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
 	if e := w.checkSize(); e != nil {
 		return 0, e
 	}
@@ -100,5 +209,45 @@ func (w *fileWriter) Write(p []byte) (n int, err error) {
 }
 
 func (w *fileWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.compressing.Wait()
 	return w.file.Close()
 }
+
+// compress gzips the rotated file to <name>.gz and removes the original
+func (w *fileWriter) compress(name string) {
+	defer w.compressing.Done()
+	// the file could have been pruned in the meantime
+	if err := compressFile(name); err != nil && !os.IsNotExist(err) {
+		_, _ = fmt.Fprintf(os.Stderr, "error compressing rotated log file: %s\n", err)
+	}
+}
+
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if er := gz.Close(); err == nil {
+		err = er
+	}
+	if er := dst.Close(); err == nil {
+		err = er
+	}
+	if err != nil {
+		// don't leave a partial archive behind, the original file is kept
+		_ = os.Remove(name + ".gz")
+		return err
+	}
+	_ = src.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,125 @@
+package log
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logContent returns the content of the log file and its rotated files
+func logContent(t *testing.T, dir string) (string, int) {
+	t.Helper()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb.Write(data)
+	}
+	return sb.String(), len(files)
+}
+
+func TestFileWriterConcurrentRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewFileWriter(filepath.Join(dir, "app.log"), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, lines = 8, 50
+	line := []byte(strings.Repeat("x", 15) + "\n")
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < lines; j++ {
+				if _, err := w.Write(line); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, count := logContent(t, dir)
+	if want := writers * lines * len(line); len(content) != want {
+		t.Errorf("expected %d bytes across the files, got %d", want, len(content))
+	}
+	if count < 2 {
+		t.Errorf("expected the file to be rotated, got %d files", count)
+	}
+}
+
+func TestFileWriterRotationErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		renameErr error
+		closeFile bool
+		files     int
+	}{
+		{"rotated", nil, false, 3},
+		{"rename failed", errors.New("rename failed"), false, 1},
+		{"close failed", nil, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renames := 0
+			rename = func(from, to string) error {
+				renames++
+				if tt.renameErr != nil {
+					return tt.renameErr
+				}
+				return os.Rename(from, to)
+			}
+			defer func() { rename = os.Rename }()
+
+			dir := t.TempDir()
+			wc, err := NewFileWriter(filepath.Join(dir, "app.log"), 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := wc.(*fileWriter)
+			defer w.Close()
+			for i, s := range []string{"first\n", "second\n", "third\n"} {
+				if tt.closeFile && i == 1 {
+					// Close fails on a handle that is already closed
+					_ = w.file.Close()
+				}
+				if _, err := w.Write([]byte(s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			content, count := logContent(t, dir)
+			if count != tt.files {
+				t.Errorf("expected %d files, got %d", tt.files, count)
+			}
+			if len(content) != len("first\nsecond\nthird\n") {
+				t.Errorf("expected every line to be written, got %q", content)
+			}
+			if tt.renameErr != nil {
+				if renames != 1 {
+					t.Errorf("expected the rotation to back off after it failed, got %d renames", renames)
+				}
+				if !w.retryAt.After(time.Now()) {
+					t.Error("expected the rotation to be retried later")
+				}
+			}
+		})
+	}
+}
@@ -2,25 +2,65 @@ package scheduler
 
 import (
 	"errors"
+	"math"
+	"sync"
+	"time"
+
 	"github.com/najibulloShapoatov/server-core/cluster"
 	"github.com/najibulloShapoatov/server-core/monitoring/log"
 	"github.com/robfig/cron/v3"
 )
 
+// Backoff defines how the delay between the retries of a task grows
+type Backoff int
+
+const (
+	// FixedBackoff waits RetryDelay between all the retries
+	FixedBackoff Backoff = iota
+	// ExponentialBackoff doubles the delay after each retry, starting with RetryDelay
+	ExponentialBackoff
+)
+
 type Task struct {
 	Name     string
 	Spec     string
 	MaxRetry int
 	Job      ScheduleFunc
-	Cluster  *cluster.Cluster
+	// RetryDelay is the delay before retrying a failed run
+	RetryDelay time.Duration
+	// RetryBackoff defines how the delay grows between the retries
+	RetryBackoff Backoff
+	// MaxRetryDelay caps the delay between the retries when set
+	MaxRetryDelay time.Duration
+	// ClusterName is the cluster joined to run the task on a single node at a time. The task runs
+	// locally when it is empty and Cluster is nil
+	ClusterName string
+	// Cluster already joined by the application, it takes precedence over ClusterName
+	Cluster *cluster.Cluster
 
 	entryID cron.EntryID
+	paused  bool
+
+	// run metadata
+	statusLock sync.Mutex
+	lastRun    time.Time
+	lastErr    error
+	running    int
 }
 
 type ScheduleFunc func() error
 
 var scheduler = newCron()
 
+// sleep waits between the retries of a task
+var sleep = time.Sleep
+
+// clusters joined by the tasks by name
+var (
+	clustersLock sync.Mutex
+	clusters     = make(map[string]*cluster.Cluster)
+)
+
 func newCron() *cron.Cron {
 	c := cron.New(cron.WithSeconds())
 	c.Start()
@@ -34,15 +74,7 @@ func RegisterJob(task *Task) error {
 		return errors.New("please define a task")
 	}
 
-	job := func() {
-		err := runJob(task)
-		if err != nil {
-			log.Error(task.Name, err.Error())
-		} else {
-			log.Info("job success", task.Name)
-		}
-	}
-	entryID, err := scheduler.AddFunc(task.Spec, job)
+	entryID, err := scheduler.AddFunc(task.Spec, task.run)
 	task.entryID = entryID
 	return err
 }
@@ -54,39 +86,182 @@ func UnregisterJob(task *Task) error {
 	}
 
 	scheduler.Remove(task.entryID)
+	task.entryID = 0
 	return nil
 }
 
-func runJob(task *Task) error {
-	if task.Cluster == nil {
-		c, err := cluster.Join("scheduler")
-		if err != nil {
-			return runWithRetry(task, task.MaxRetry)
-		} else {
-			task.Cluster = c
-			return runOnCluster(task)
-		}
-	} else {
-		return runOnCluster(task)
+// ScheduleOnce runs the job a single time at the given time, or right away if the time is in the past
+func ScheduleOnce(name string, at time.Time, job ScheduleFunc) error {
+	return ScheduleTaskOnce(&Task{Name: name, Job: job}, at)
+}
+
+// ScheduleTaskOnce runs the task a single time at the given time, or right away if the time is in the
+// past. The task spec is ignored while the retries and the cluster settings are honored
+func ScheduleTaskOnce(task *Task, at time.Time) error {
+	if task == nil {
+		return errors.New("please define a task")
+	}
+	if !at.After(time.Now()) {
+		go task.run()
+		return nil
 	}
+
+	// the job waits for the entry id to be stored before running
+	ready := make(chan struct{})
+	var entryID cron.EntryID
+	entryID = scheduler.Schedule(onceSchedule(at), cron.FuncJob(func() {
+		<-ready
+		task.run()
+		scheduler.Remove(entryID)
+	}))
+	task.entryID = entryID
+	close(ready)
+	return nil
 }
 
-func runWithRetry(task *Task, attempts int) error {
-	if err := task.Job(); err != nil {
-		if attempts--; attempts > 0 {
-			return runWithRetry(task, attempts)
-		}
+// onceSchedule is a cron schedule activated only once at the given time
+type onceSchedule time.Time
+
+// Next returns the activation time until it is reached, then the zero time so it never runs again
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if at := time.Time(s); t.Before(at) {
+		return at
+	}
+	return time.Time{}
+}
+
+// PauseJob stops running the job until ResumeJob is called, keeping the task definition. Pausing a
+// paused job does nothing
+func PauseJob(task *Task) error {
+	if task == nil {
+		return errors.New("please define a task")
+	}
+	if task.paused {
+		return nil
+	}
+
+	scheduler.Remove(task.entryID)
+	task.entryID = 0
+	task.paused = true
+	return nil
+}
+
+// ResumeJob schedules a paused job again with its original spec. Resuming a job that is not paused
+// does nothing
+func ResumeJob(task *Task) error {
+	if task == nil {
+		return errors.New("please define a task")
+	}
+	if !task.paused {
+		return nil
+	}
+
+	entryID, err := scheduler.AddFunc(task.Spec, task.run)
+	if err != nil {
 		return err
 	}
+	task.entryID = entryID
+	task.paused = false
 	return nil
 }
 
-func runOnCluster(task *Task) error {
-	err := task.Cluster.Lock(task.Name)
+// run is the cron job of the task, recording the run metadata
+func (task *Task) run() {
+	task.statusLock.Lock()
+	task.lastRun = time.Now()
+	task.running++
+	task.statusLock.Unlock()
+
+	err := runJob(task)
+
+	task.statusLock.Lock()
+	task.lastErr = err
+	task.running--
+	task.statusLock.Unlock()
+	if err != nil {
+		log.Error(task.Name, err.Error())
+	} else {
+		log.Info("job success", task.Name)
+	}
+}
+
+// TaskStatus returns the next time the task is scheduled to run, the time the last run started, the
+// error returned by the last run and whether the task is running. The next run is zero for tasks that
+// are not registered
+func TaskStatus(task *Task) (nextRun time.Time, lastRun time.Time, lastErr error, running bool) {
+	if task == nil {
+		return
+	}
+	if task.entryID != 0 {
+		nextRun = scheduler.Entry(task.entryID).Next
+	}
+	task.statusLock.Lock()
+	defer task.statusLock.Unlock()
+	return nextRun, task.lastRun, task.lastErr, task.running > 0
+}
+
+func runJob(task *Task) error {
+	if task.Cluster != nil {
+		return runOnCluster(task, task.Cluster)
+	}
+	if task.ClusterName == "" {
+		return runWithRetry(task, task.MaxRetry)
+	}
+	c, err := joinCluster(task.ClusterName)
+	if err != nil {
+		return err
+	}
+	return runOnCluster(task, c)
+}
+
+// joinCluster joins the named cluster once and shares it between the tasks
+func joinCluster(name string) (*cluster.Cluster, error) {
+	clustersLock.Lock()
+	defer clustersLock.Unlock()
+	if c, ok := clusters[name]; ok {
+		return c, nil
+	}
+	c, err := cluster.Join(name)
+	if err != nil {
+		return nil, err
+	}
+	clusters[name] = c
+	return c, nil
+}
+
+// runWithRetry runs the job up to attempts times until it succeeds, waiting between the attempts
+// according to the retry settings of the task
+func runWithRetry(task *Task, attempts int) (err error) {
+	delay := task.RetryDelay
+	for attempt := 1; ; attempt++ {
+		if err = task.Job(); err == nil {
+			return nil
+		}
+		if attempt >= attempts {
+			return err
+		}
+		if task.MaxRetryDelay > 0 && delay > task.MaxRetryDelay {
+			delay = task.MaxRetryDelay
+		}
+		log.Warnf("job %s attempt %d of %d failed, retrying in %s: %s", task.Name, attempt, attempts, delay, err)
+		sleep(delay)
+		if task.RetryBackoff == ExponentialBackoff {
+			delay *= 2
+			// without MaxRetryDelay the doubled delay overflows after enough retries
+			if delay < 0 {
+				delay = math.MaxInt64
+			}
+		}
+	}
+}
+
+// runOnCluster runs the task only if this node acquires the task lock
+func runOnCluster(task *Task, c *cluster.Cluster) error {
+	err := c.Lock(task.Name)
 
 	if err == nil {
 		err = runWithRetry(task, task.MaxRetry)
-		_ = task.Cluster.Unlock(task.Name)
+		_ = c.Unlock(task.Name)
 		return err
 	}
 	return nil
@@ -0,0 +1,181 @@
+package scheduler
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunWithRetry(t *testing.T) {
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+
+	tests := []struct {
+		name     string
+		task     *Task
+		failures int
+		wantErr  bool
+		delays   []time.Duration
+	}{
+		{
+			name:     "success",
+			task:     &Task{MaxRetry: 3, RetryDelay: time.Second},
+			failures: 0,
+		},
+		{
+			name:     "fixed backoff",
+			task:     &Task{MaxRetry: 4, RetryDelay: time.Second},
+			failures: 3,
+			delays:   []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name:     "attempts exhausted",
+			task:     &Task{MaxRetry: 3, RetryDelay: time.Second},
+			failures: 5,
+			wantErr:  true,
+			delays:   []time.Duration{time.Second, time.Second},
+		},
+		{
+			name:     "exponential backoff",
+			task:     &Task{MaxRetry: 5, RetryDelay: time.Second, RetryBackoff: ExponentialBackoff},
+			failures: 4,
+			delays:   []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 8},
+		},
+		{
+			name:     "exponential backoff capped",
+			task:     &Task{MaxRetry: 5, RetryDelay: time.Second, RetryBackoff: ExponentialBackoff, MaxRetryDelay: time.Second * 3},
+			failures: 4,
+			delays:   []time.Duration{time.Second, time.Second * 2, time.Second * 3, time.Second * 3},
+		},
+		{
+			name:     "exponential backoff overflow",
+			task:     &Task{MaxRetry: 4, RetryDelay: 1 << 62, RetryBackoff: ExponentialBackoff},
+			failures: 3,
+			delays:   []time.Duration{1 << 62, math.MaxInt64, math.MaxInt64},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var delays []time.Duration
+			sleep = func(d time.Duration) { delays = append(delays, d) }
+			runs := 0
+			task := tt.task
+			task.Name = tt.name
+			task.Job = func() error {
+				runs++
+				if runs <= tt.failures {
+					return errors.New("failed")
+				}
+				return nil
+			}
+
+			err := runWithRetry(task, task.MaxRetry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if len(delays) != len(tt.delays) {
+				t.Fatalf("expected the delays %v, got %v", tt.delays, delays)
+			}
+			for i := range delays {
+				if delays[i] != tt.delays[i] {
+					t.Errorf("expected the delays %v, got %v", tt.delays, delays)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleOnce(t *testing.T) {
+	tests := []struct {
+		name string
+		at   time.Time
+	}{
+		{"past", time.Now().Add(-time.Hour)},
+		{"future", time.Now().Add(time.Millisecond * 100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs int32
+			done := make(chan struct{})
+			err := ScheduleOnce(tt.name, tt.at, func() error {
+				if atomic.AddInt32(&runs, 1) == 1 {
+					close(done)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-done:
+			case <-time.After(time.Second * 3):
+				t.Fatal("the job did not run")
+			}
+			if time.Now().Before(tt.at) {
+				t.Error("the job ran before its time")
+			}
+			// the job is not run again
+			time.Sleep(time.Millisecond * 1500)
+			if n := atomic.LoadInt32(&runs); n != 1 {
+				t.Errorf("expected a single run, got %d", n)
+			}
+		})
+	}
+	if err := ScheduleTaskOnce(nil, time.Now()); err == nil {
+		t.Error("expected an error for a nil task")
+	}
+}
+
+func TestPauseResumeJob(t *testing.T) {
+	var runs int32
+	task := &Task{
+		Name: "pause",
+		Spec: "* * * * * *",
+		Job: func() error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		},
+	}
+	if err := RegisterJob(task); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = UnregisterJob(task) }()
+
+	for i := 0; i < 2; i++ {
+		// pausing a paused job does nothing
+		if err := PauseJob(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if next, _, _, _ := TaskStatus(task); !next.IsZero() {
+		t.Errorf("expected no next run for a paused job, got %s", next)
+	}
+	before := atomic.LoadInt32(&runs)
+	time.Sleep(time.Millisecond * 1500)
+	if n := atomic.LoadInt32(&runs); n != before {
+		t.Errorf("expected the paused job not to run, got %d runs", n-before)
+	}
+
+	for i := 0; i < 2; i++ {
+		// resuming a job that is not paused does nothing
+		if err := ResumeJob(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if next, _, _, _ := TaskStatus(task); next.IsZero() {
+		t.Error("expected a next run for the resumed job")
+	}
+	deadline := time.Now().Add(time.Second * 3)
+	for atomic.LoadInt32(&runs) == before {
+		if time.Now().After(deadline) {
+			t.Fatal("the resumed job did not run")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if PauseJob(nil) == nil || ResumeJob(nil) == nil {
+		t.Error("expected an error for a nil task")
+	}
+}
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"crypto/tls"
+	"fmt"
 	"github.com/najibulloShapoatov/server-core/server/session"
+	"strings"
 	"time"
 )
 
@@ -30,6 +33,10 @@ type Config struct {
 	// ReadTimeout for client requests. A timeout of 0 means no timeout.
 	// Default value is 0
 	ReadTimeout time.Duration `config:"platform.sever.readTimeout" default:"0"`
+	// ReadHeaderTimeout is the amount of time allowed to read the request headers. It protects the
+	// server against slowloris attacks where clients trickle the headers to keep connections open.
+	// Default value is 10s
+	ReadHeaderTimeout time.Duration `config:"platform.server.readHeaderTimeout" default:"10s"`
 	// WriteTimeout for client responses. A timeout of 0 means no timeout.
 	// Default value is 0
 	WriteTimeout time.Duration `config:"platform.server.writeTimeout" default:"0"`
@@ -39,6 +46,12 @@ type Config struct {
 	// PostMaxSize is the maximum amount of payload a client can send.
 	// Default value is 100MB
 	PostMaxSize int `config:"platform.server.maxPostSize" default:"100MB"`
+	// AccessLogFormat is the format of the access log lines. Available options are "clf" for the Apache
+	// common log format and "json" for structured entries logged with fields.
+	// Default value is clf
+	AccessLogFormat string `config:"platform.server.accessLogFormat" default:"clf"`
+	// Metrics settings
+	Metrics *MetricsConfig `config:"."`
 	// Session settings
 	Session *session.Config `config:"."`
 	// Cache settings
@@ -52,6 +65,10 @@ type Config struct {
 	// EnableTracing will enable the TraceHeader on all responses.
 	// Default value is enabled
 	EnableTracing bool `config:"platform.server.security.tracing.enabled" default:"yes"`
+	// W3CTrace enables the W3C trace context format. The trace is read from and propagated using the
+	// traceparent header while the trace id is still added on the TraceHeader.
+	// Default value is disabled
+	W3CTrace bool `config:"platform.server.security.tracing.w3c" default:"no"`
 	// TraceRequired indicates that this service will be a middleware or backend service and
 	// all requests coming to it should come from a service that emits the tracing header, thus making
 	// it a requirement on all incoming requests.
@@ -72,13 +89,95 @@ type HTTPSConfig struct {
 	Cert string `config:"platform.server.https.cert"`
 	// Path to server private key
 	Key string `config:"platform.server.https.key"`
+	// CertReloadInterval is how often the certificate files are checked for changes so rotated
+	// certificates are loaded without restarting the server. A value of 0 disables the reload.
+	// Default value is 1m
+	CertReloadInterval time.Duration `config:"platform.server.https.certReloadInterval" default:"1m"`
+	// MinTLSVersion is the minimum TLS version accepted by the server. Available options are 1.0, 1.1, 1.2 and 1.3
+	// Default value is 1.2
+	MinTLSVersion string `config:"platform.server.https.minTLSVersion" default:"1.2"`
+	// CipherSuites contains a comma separated list of cipher suite names (ex: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)
+	// allowed for TLS 1.0-1.2 connections. TLS 1.3 cipher suites are not configurable.
+	// Default value is empty which uses the Go default cipher suites
+	CipherSuites string `config:"platform.server.https.cipherSuites"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsVersion returns the TLS version matching the MinTLSVersion setting
+func (cfg *HTTPSConfig) tlsVersion() (uint16, error) {
+	v := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(cfg.MinTLSVersion)), "tls")
+	if v == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsVersions[strings.TrimSpace(v)]
+	if !ok {
+		return 0, fmt.Errorf("invalid TLS version: %s", cfg.MinTLSVersion)
+	}
+	return version, nil
+}
+
+// cipherSuites returns the ids of the cipher suites from the CipherSuites setting
+func (cfg *HTTPSConfig) cipherSuites() ([]uint16, error) {
+	if strings.TrimSpace(cfg.CipherSuites) == "" {
+		return nil, nil
+	}
+	available := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+	var res []uint16
+	for _, name := range strings.Split(cfg.CipherSuites, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid or insecure cipher suite: %s", name)
+		}
+		res = append(res, id)
+	}
+	return res, nil
+}
+
+// tlsOptions are the minimum TLS version and cipher suites of the HTTPSConfig, they are applied on the TLS
+// configuration of every certificate manager
+type tlsOptions struct {
+	minVersion   uint16
+	cipherSuites []uint16
+}
+
+// options returns the TLS options of the configuration
+func (cfg *HTTPSConfig) options() (tlsOptions, error) {
+	version, err := cfg.tlsVersion()
+	if err != nil {
+		return tlsOptions{}, err
+	}
+	suites, err := cfg.cipherSuites()
+	if err != nil {
+		return tlsOptions{}, err
+	}
+	return tlsOptions{minVersion: version, cipherSuites: suites}, nil
+}
+
+// apply sets the minimum TLS version and cipher suites on the given TLS configuration and returns it
+func (o tlsOptions) apply(tlsConfig *tls.Config) *tls.Config {
+	tlsConfig.MinVersion = o.minVersion
+	if len(o.cipherSuites) != 0 {
+		tlsConfig.CipherSuites = o.cipherSuites
+	}
+	return tlsConfig
 }
 
 type SecurityConfig struct {
 	// BruteForce protection configuration
 	BruteForce *BruteForceConfig `config:"."`
-	// CSRFTokenRequired indicates that POST, PUT, PATCH methods should have a CSRF token header
-	// or they will be discarded.
+	// CSRFTokenRequired indicates that POST, PUT, PATCH and DELETE methods should have a CSRF token header
+	// or they will be discarded. Authenticated requests must send the token of their session while
+	// anonymous requests must send the value of the csrf_token cookie, which is set on safe requests.
 	// Default value is disabled.
 	CSRFTokenRequired bool `config:"platform.server.security.csrfRequired" default:"no"`
 	// DNT flag indicates if the server should respect Do Not Track requests
@@ -120,12 +219,20 @@ type SecurityConfig struct {
 	// Blacklist contains a comma separated list of IP's that cannot access the application.
 	// The IP's can be defined as simple IP addresses, IP ranges, CIDR ranges and *
 	Blacklist string `config:"platform.server.security.blacklist"`
+	// TrustedProxies contains a comma separated list of the proxies allowed to report the client IP in the
+	// X-Forwarded-For and X-Real-Ip headers. The headers are ignored for requests sent by any other address.
+	// The IP's can be defined as simple IP addresses, IP ranges and CIDR ranges
+	TrustedProxies string `config:"platform.server.security.trustedProxies"`
 	// Server security with url scan
 	URLScanner bool `config:"platform.server.security.urlScanner" default:"false"`
 	// IP ban time for url scan detection
 	BanDuration time.Duration `config:"platform.server.security.banDuration" default:"5h"`
+	// How often the expired IP bans are removed
+	BanSweepInterval time.Duration `config:"platform.server.security.banSweepInterval" default:"1m"`
 	// The Access-Control-Allow-Origin response header indicates whether the response can be
 	// shared with requesting code from the given origin.
+	// The value is either * or a comma separated list of origins matched exactly, like https://example.com.
+	// Subdomains can be allowed with a wildcard like https://*.example.com
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Access-Control-Allow-Origin
 	CORSOrigin string `config:"platform.server.security.cors.origin"`
 	// The Access-Control-Allow-Headers response header is used in response to a preflight request
@@ -158,6 +265,15 @@ type BruteForceConfig struct {
 	Capacity int64 `config:"platform.server.security.bruteForce.capacity" default:"10"`
 }
 
+type MetricsConfig struct {
+	// Enabled exposes the server metrics in the Prometheus text format
+	// Default value is disabled
+	Enabled bool `config:"platform.server.metrics.enabled" default:"no"`
+	// Path of the metrics endpoint
+	// Default value is /metrics
+	Path string `config:"platform.server.metrics.path" default:"/metrics"`
+}
+
 type CacheConfig struct {
 	// Enable cache headers for static resources
 	Enabled bool `config:"platform.server.cache.enable" default:"yes"`
@@ -166,5 +282,24 @@ type CacheConfig struct {
 }
 
 func (cfg *Config) Validate() error {
+	switch strings.ToLower(cfg.AccessLogFormat) {
+	case "", "clf", "json":
+	default:
+		return fmt.Errorf("invalid access log format: %s", cfg.AccessLogFormat)
+	}
+	if _, err := cfg.HTTPS.tlsVersion(); err != nil {
+		return err
+	}
+	if _, err := cfg.HTTPS.cipherSuites(); err != nil {
+		return err
+	}
 	return nil
 }
+
+// traceHeader returns the name of the header holding the trace id, X-Trace-Id when it is not configured
+func (cfg *Config) traceHeader() string {
+	if cfg.TraceHeader == "" {
+		return headerXTrace
+	}
+	return cfg.TraceHeader
+}
@@ -1,16 +1,22 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"mime/multipart"
 	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/najibulloShapoatov/server-core/monitoring/log"
 	"github.com/najibulloShapoatov/server-core/platform"
 	"github.com/najibulloShapoatov/server-core/server/session"
+	"github.com/najibulloShapoatov/server-core/utils"
 	"github.com/najibulloShapoatov/server-core/utils/net"
 )
 
@@ -33,6 +39,14 @@ type Context struct {
 	Consent bool
 	// private
 	parsed bool
+	// path parameters of explicit routes
+	params map[string]string
+	// request scoped logger
+	logger *log.Logger
+	// set once the session of the request was looked up
+	sessionRestored bool
+	// release the contexts created by SetTimeout
+	cancels []context.CancelFunc
 }
 
 func newContext(w http.ResponseWriter, r *http.Request) *Context {
@@ -48,6 +62,42 @@ func (c *Context) RemoteAddr() string {
 	return net.GetClientIP(c.Request)
 }
 
+// Log returns a logger for the request which adds the trace id to all the entries when tracing is enabled.
+// Fields stored in the request context with log.NewContext are added as well
+func (c *Context) Log() *log.Logger {
+	if c.logger == nil {
+		c.logger = log.FromContext(c.Request.Context())
+		if c.Server != nil {
+			if traceID := c.Request.Header.Get(c.Server.Config.traceHeader()); traceID != "" {
+				c.logger = c.logger.WithField("trace", traceID)
+			}
+		}
+	}
+	return c.logger
+}
+
+// Context returns the context of the request, which is canceled when the client goes away or the
+// request deadline expires. It should be passed to the calls made by the handler (database, HTTP, etc)
+func (c *Context) Context() context.Context {
+	return c.Request.Context()
+}
+
+// SetTimeout shortens the deadline of the request context returned by Context. The deadline given by the
+// server write timeout can't be extended
+func (c *Context) SetTimeout(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	c.Request = c.Request.WithContext(ctx)
+	c.cancels = append(c.cancels, cancel)
+}
+
+// release cancels the contexts created by SetTimeout once the request is handled
+func (c *Context) release() {
+	for _, cancel := range c.cancels {
+		cancel()
+	}
+	c.cancels = nil
+}
+
 // UserAgent returns the client's User-Agent, if sent in the request.
 func (c *Context) UserAgent() string {
 	return c.Request.UserAgent()
@@ -128,6 +178,54 @@ func (c *Context) AccountID() *string {
 	return c.Session.AccountID
 }
 
+// restoreSession adds the session of the request to the context, once per request. Sessions inactive for
+// longer than the TTL are removed and the active ones are kept alive
+func (c *Context) restoreSession() {
+	if c.sessionRestored || c.Session != nil || c.Server == nil {
+		return
+	}
+	c.sessionRestored = true
+	cfg := c.Server.Config.Session
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+
+	var sessionID session.Token
+	// search for a session id on the session cookie
+	var cookie *http.Cookie
+	if cfg.UseCookie {
+		cookie, _ = c.Cookie(cfg.CookieName)
+	}
+	// if session cookie is not present try on the session header
+	if cookie == nil {
+		sessionID = session.Token(c.Request.Header.Get(cfg.HeaderName))
+	} else {
+		sessionID = session.Token(cookie.Value)
+	}
+	// if a valid session id is found restore the session
+	if sessionID.Valid() {
+		c.Session = session.Restore(sessionID)
+	}
+	if c.Session != nil {
+		if c.Session.Expired() {
+			c.Session.Destroy()
+			c.Session = nil
+		} else {
+			c.Session.Touch()
+		}
+	}
+}
+
+// EffectiveAccountID returns the account the user acts as, which is the impersonated account if the
+// session impersonates one, otherwise the real account returned by AccountID
+func (c *Context) EffectiveAccountID() *string {
+	if c.Authenticated() && c.Session.Impersonating() {
+		id := strconv.Itoa(*c.Session.ImpersonateAccountID)
+		return &id
+	}
+	return c.AccountID()
+}
+
 // Can verifies if the user can perform the given operations
 func (c *Context) Can(permission platform.Permission) bool {
 	if !c.Authenticated() {
@@ -156,48 +254,173 @@ func (c *Context) OK() (int, error) {
 	return http.StatusOK, nil
 }
 
+// Redirect sends the client to the given url using a 3xx status code
+func (c *Context) Redirect(status int, url string) error {
+	if status < http.StatusMultipleChoices || status > http.StatusPermanentRedirect {
+		return fmt.Errorf("invalid redirect status code %d", status)
+	}
+	c.Response.Header().Set("Location", url)
+	c.Response.WriteHeader(status)
+	return nil
+}
+
+// Cookie returns the named cookie provided in the request
+func (c *Context) Cookie(name string) (*http.Cookie, error) {
+	return c.Request.Cookie(name)
+}
+
+// SetCookie adds a Set-Cookie header to the response. The cookie is marked as Secure when
+// the server runs on HTTPS and defaults to SameSite=Lax if no SameSite mode is given
+func (c *Context) SetCookie(cookie *http.Cookie) {
+	if c.Server != nil && c.Server.Config.HTTPS.Enabled {
+		cookie.Secure = true
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	http.SetCookie(c.Response, cookie)
+}
+
+// Param returns the value of the path parameter with the given name for routes registered
+// with RegisterHandler, or an empty string if the parameter doesn't exist
+func (c *Context) Param(name string) string {
+	return c.params[name]
+}
+
+// Upgrade switches the connection to the WebSocket protocol. The Origin header is validated against
+// the CORS origins allowed in the security configuration. After the upgrade the response can no longer
+// be used and the handler should return nil
+func (c *Context) Upgrade() (*websocket.Conn, error) {
+	upgrader := websocket.Upgrader{
+		HandshakeTimeout: time.Second * 10,
+		CheckOrigin: func(r *http.Request) bool {
+			return allowedOrigin(c.Server.Config.Security, r)
+		},
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			c.Response.WriteHeader(status)
+		},
+	}
+	conn, err := upgrader.Upgrade(c.Response.Writer, c.Request, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.Response.Status = http.StatusSwitchingProtocols
+	c.Response.Committed = true
+	return conn, nil
+}
+
 func (c *Context) Set(key string, val interface{}) {
 	c.Data[key] = val
 }
 
 func (c *Context) FormFile(name string) (file multipart.File, header *multipart.FileHeader, err error) {
-	if !c.parsed {
-		err = c.Request.ParseMultipartForm(int64(c.Server.Config.PostMaxSize))
-		if err != nil {
-			return
-		}
-		c.parsed = true
+	if err = c.parseForm(); err != nil {
+		return
 	}
 	file, header, err = c.Request.FormFile(name)
 	return
 }
 
 func (c *Context) FormValue(name string) (string, error) {
-	if !c.parsed {
-		err := c.Request.ParseMultipartForm(int64(c.Server.Config.PostMaxSize))
-		if err != nil {
-			return "", err
-		}
-		c.parsed = true
+	if err := c.parseForm(); err != nil {
+		return "", err
 	}
 	return c.Request.FormValue(name), nil
 }
 
+// FormInt returns the form value with the given name parsed as an int
+func (c *Context) FormInt(name string) (int, error) {
+	val, err := c.FormValue(name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil {
+		return 0, fmt.Errorf("form value %s is not a number", name)
+	}
+	return i, nil
+}
+
+// FormBool returns the form value with the given name parsed as a truthy value (true, yes, on, 1, etc)
+func (c *Context) FormBool(name string) (bool, error) {
+	val, err := c.FormValue(name)
+	if err != nil {
+		return false, err
+	}
+	val = strings.ToLower(strings.TrimSpace(val))
+	if !utils.IsTruthy(val) {
+		return false, fmt.Errorf("form value %s is not a boolean", name)
+	}
+	return utils.Truthy(val), nil
+}
+
+// FormTime returns the form value with the given name parsed as time using the given layout
+func (c *Context) FormTime(name, layout string) (time.Time, error) {
+	val, err := c.FormValue(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(layout, strings.TrimSpace(val))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("form value %s is not a valid time: %s", name, err)
+	}
+	return t, nil
+}
+
+// parseForm parses the request body as a multipart or url encoded form only once per request
+func (c *Context) parseForm() error {
+	if c.parsed {
+		return nil
+	}
+	err := c.Request.ParseMultipartForm(int64(c.Server.Config.PostMaxSize))
+	if err == http.ErrNotMultipart {
+		err = c.Request.ParseForm()
+	}
+	if err != nil {
+		return err
+	}
+	c.parsed = true
+	return nil
+}
+
+// Get retrieves the value stored under the given key into val, which must be a pointer.
+// Values of the exact same type are assigned directly while any other value is converted
+// through a JSON round-trip
 func (c *Context) Get(key string, val interface{}) error {
 	v, ok := c.Data[key]
 	if !ok {
 		return fmt.Errorf("not found")
 	}
-	if reflect.TypeOf(val) != reflect.TypeOf(v) {
-		d, _ := json.Marshal(v)
-		_ = json.Unmarshal(d, val)
+
+	target := reflect.ValueOf(val)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("pointer required")
+	}
+	elem := target.Elem()
+
+	src := reflect.ValueOf(v)
+	switch {
+	case !src.IsValid():
+		elem.Set(reflect.Zero(elem.Type()))
+		return nil
+	case src.Type() == elem.Type():
+		elem.Set(src)
+		return nil
+	case src.Type() == target.Type():
+		if src.IsNil() {
+			elem.Set(reflect.Zero(elem.Type()))
+		} else {
+			elem.Set(src.Elem())
+		}
 		return nil
 	}
 
-	valPtr := reflect.ValueOf(val).Elem()
-	if !valPtr.CanSet() {
-		return fmt.Errorf("pointer required")
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
 	}
-	valPtr.Set(reflect.ValueOf(v).Elem())
-	return nil
+	return json.Unmarshal(data, val)
 }
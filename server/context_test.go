@@ -0,0 +1,184 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestContextUpgrade(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := newContext(w, r)
+		ctx.Server = &Server{Config: &Config{Security: &SecurityConfig{CORSOrigin: "https://allowed.example.com"}}}
+		conn, err := ctx.Upgrade()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// echo the messages until the client closes the connection
+		for {
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(typ, data); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	tests := []struct {
+		name   string
+		origin string
+		status int
+	}{
+		{"no origin", "", http.StatusSwitchingProtocols},
+		{"same origin", srv.URL, http.StatusSwitchingProtocols},
+		{"allowed origin", "https://allowed.example.com", http.StatusSwitchingProtocols},
+		{"foreign origin", "https://evil.example.com", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			conn, res, err := websocket.DefaultDialer.Dial(wsURL, header)
+			if res == nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, res.StatusCode)
+			}
+			if conn == nil {
+				return
+			}
+			defer conn.Close()
+			if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != websocket.TextMessage || string(data) != "hello" {
+				t.Errorf("expected the text message to be echoed, got %d %q", typ, data)
+			}
+		})
+	}
+
+	// a request that doesn't ask for an upgrade is rejected
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d for a plain request, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func TestContextGet(t *testing.T) {
+	type point struct {
+		X, Y int
+	}
+	tests := []struct {
+		name    string
+		stored  interface{}
+		target  func() interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"struct value", point{1, 2}, func() interface{} { return &point{} }, &point{1, 2}, false},
+		{"struct pointer", &point{1, 2}, func() interface{} { return &point{} }, &point{1, 2}, false},
+		{"pointer target", &point{1, 2}, func() interface{} { p := &point{}; return &p }, func() interface{} { p := &point{1, 2}; return &p }(), false},
+		{"slice", []string{"a", "b"}, func() interface{} { return &[]string{} }, &[]string{"a", "b"}, false},
+		{"map", map[string]int{"a": 1}, func() interface{} { return &map[string]int{} }, &map[string]int{"a": 1}, false},
+		{"scalar", 42, func() interface{} { var i int; return &i }, func() interface{} { i := 42; return &i }(), false},
+		{"converted scalar", 42, func() interface{} { var f float64; return &f }, func() interface{} { f := 42.0; return &f }(), false},
+		{"converted slice", []interface{}{"a", "b"}, func() interface{} { return &[]string{} }, &[]string{"a", "b"}, false},
+		{"nil", nil, func() interface{} { s := []string{"a"}; return &s }, new([]string), false},
+		{"not a pointer", 42, func() interface{} { return 0 }, nil, true},
+		{"incompatible", "text", func() interface{} { var i int; return &i }, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := testContext(http.MethodGet, "/")
+			ctx.Set("key", tt.stored)
+			target := tt.target()
+			err := ctx.Get("key", target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(target, tt.want) {
+				t.Errorf("expected %#v, got %#v", tt.want, target)
+			}
+		})
+	}
+
+	ctx, _ := testContext(http.MethodGet, "/")
+	var s string
+	if err := ctx.Get("missing", &s); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
+
+func TestFormValues(t *testing.T) {
+	fields := map[string]string{"count": " 42 ", "active": "yes", "disabled": "off", "name": "john", "date": "2021-03-04"}
+	tests := []struct {
+		name string
+		body func(t *testing.T) (io.Reader, string)
+	}{
+		{"urlencoded", func(t *testing.T) (io.Reader, string) {
+			form := url.Values{}
+			for k, v := range fields {
+				form.Set(k, v)
+			}
+			return strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"
+		}},
+		{"multipart", func(t *testing.T) (io.Reader, string) {
+			return multipartBody(t, fields, "")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := testContext(http.MethodPost, "/")
+			ctx.Server.Config.PostMaxSize = 1 << 20
+			body, contentType := tt.body(t)
+			ctx.Request = httptest.NewRequest(http.MethodPost, "/", body)
+			ctx.Request.Header.Set("Content-Type", contentType)
+
+			if i, err := ctx.FormInt("count"); err != nil || i != 42 {
+				t.Errorf("expected count 42, got %d %v", i, err)
+			}
+			if _, err := ctx.FormInt("name"); err == nil {
+				t.Error("expected an error for a value that is not a number")
+			}
+			if b, err := ctx.FormBool("active"); err != nil || !b {
+				t.Errorf("expected active true, got %v %v", b, err)
+			}
+			if b, err := ctx.FormBool("disabled"); err != nil || b {
+				t.Errorf("expected disabled false, got %v %v", b, err)
+			}
+			if _, err := ctx.FormBool("name"); err == nil {
+				t.Error("expected an error for a value that is not a boolean")
+			}
+			want := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+			if d, err := ctx.FormTime("date", "2006-01-02"); err != nil || !d.Equal(want) {
+				t.Errorf("expected date %v, got %v %v", want, d, err)
+			}
+			if _, err := ctx.FormTime("date", time.RFC3339); err == nil {
+				t.Error("expected an error for a value not matching the layout")
+			}
+		})
+	}
+}
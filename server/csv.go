@@ -0,0 +1,275 @@
+package server
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// csvColumn is a column of the CSV output, read from the field at the given index path
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// csvOutputEncoder encodes the handler results as CSV in memory, see csvStreamEncoder
+func csvOutputEncoder(ctx *Context, params ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := csvStreamEncoder(ctx, &buf, params...)
+	return buf.Bytes(), err
+}
+
+// csvStreamEncoder writes a slice, an array or a channel of structures as CSV, one row per element
+// after a header row with the field names or the names given in the `csv` tag. Nested structures
+// are flattened into Parent.Field columns and values that have no text form are written as JSON.
+// The rows are written as they are encoded so channels can be used to send large exports without
+// holding them in memory. The encoder stops reading the channel when a row can't be written or the
+// request context is done, so producers must select on ctx.Context().Done() when sending, it is
+// cancelled once the request is handled
+//
+//	rows := make(chan Row)
+//	go func() {
+//		defer close(rows)
+//		for _, row := range query() {
+//			select {
+//			case rows <- row:
+//			case <-ctx.Context().Done():
+//				return
+//			}
+//		}
+//	}()
+//	return rows, http.StatusOK, nil
+func csvStreamEncoder(ctx *Context, w io.Writer, params ...interface{}) error {
+	var v reflect.Value
+	if len(params) == 1 {
+		v = reflect.ValueOf(params[0])
+	} else {
+		v = reflect.ValueOf(params)
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	cw := csv.NewWriter(w)
+	var columns []csvColumn
+	writeHeader := func(typ reflect.Type) error {
+		columns = csvColumns(typ)
+		header := make([]string, len(columns))
+		for i, col := range columns {
+			header[i] = col.name
+		}
+		return cw.Write(header)
+	}
+	write := func(item reflect.Value) error {
+		for item.Kind() == reflect.Ptr || item.Kind() == reflect.Interface {
+			if item.IsNil() {
+				return nil
+			}
+			item = item.Elem()
+		}
+		// elements of interface type only know their type once they are read
+		if columns == nil {
+			if err := writeHeader(item.Type()); err != nil {
+				return err
+			}
+		}
+		return cw.Write(csvRow(item, columns))
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Chan:
+		if elem := indirectType(v.Type().Elem()); elem.Kind() != reflect.Interface {
+			if err := writeHeader(elem); err != nil {
+				return err
+			}
+		}
+		if v.Kind() == reflect.Chan {
+			cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: v}}
+			if ctx != nil {
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Context().Done())})
+			}
+			for {
+				chosen, item, ok := reflect.Select(cases)
+				if chosen == 1 {
+					return ctx.Context().Err()
+				}
+				if !ok {
+					// the producer may close the channel because the request was cancelled
+					if ctx != nil && ctx.Context().Err() != nil {
+						return ctx.Context().Err()
+					}
+					break
+				}
+				if err := write(item); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := write(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Invalid:
+	default:
+		if err := write(v); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvColumns returns the columns of the rows of the given type. Structures are flattened, any other
+// type is written as a single value column
+func csvColumns(typ reflect.Type) []csvColumn {
+	typ = indirectType(typ)
+	if !csvNested(typ) {
+		return []csvColumn{{name: "value"}}
+	}
+	return appendCSVColumns(nil, typ, "", nil, nil)
+}
+
+// appendCSVColumns adds the columns of the typ structure fields. parents holds the structures being
+// flattened so recursive types are written as a single value instead of being flattened forever
+func appendCSVColumns(res []csvColumn, typ reflect.Type, prefix string, index []int, parents []reflect.Type) []csvColumn {
+	parents = append(parents, typ)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := strings.Split(field.Tag.Get("csv"), ",")[0]
+		if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+		fieldType := indirectType(field.Type)
+		switch fieldType.Kind() {
+		case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+			continue
+		}
+		nested := csvNested(fieldType) && !containsType(parents, fieldType)
+
+		if field.Anonymous && tag == "" {
+			if nested {
+				res = appendCSVColumns(res, fieldType, prefix, fieldIndex, parents)
+			}
+			continue
+		}
+		name := tag
+		if name == "" {
+			name = field.Name
+		}
+		if nested {
+			res = appendCSVColumns(res, fieldType, prefix+name+".", fieldIndex, parents)
+			continue
+		}
+		res = append(res, csvColumn{name: prefix + name, index: fieldIndex})
+	}
+	return res
+}
+
+// csvNested returns true for the structures that are flattened into columns, the ones with a text
+// form like time.Time are written as a single value
+func csvNested(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Struct && !reflect.PtrTo(typ).Implements(textMarshalerType)
+}
+
+func containsType(types []reflect.Type, typ reflect.Type) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func indirectType(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// csvRow returns the values of a row
+func csvRow(v reflect.Value, columns []csvColumn) []string {
+	res := make([]string, len(columns))
+	for i, col := range columns {
+		if col.index == nil {
+			res[i] = csvValue(v)
+			continue
+		}
+		if field, ok := csvField(v, col.index); ok {
+			res[i] = csvValue(field)
+		}
+	}
+	return res
+}
+
+// csvField returns the field at the index path, or false if the path goes through a nil pointer or
+// the value is not of the type of the header row
+func csvField(v reflect.Value, index []int) (reflect.Value, bool) {
+	for _, i := range index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct || i >= v.NumField() {
+			return reflect.Value{}, false
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+// csvValue returns the text form of a value. Values without one are encoded as JSON and the ones
+// that can't be encoded, like functions or channels, are left empty
+func csvValue(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if !v.CanInterface() {
+		return ""
+	}
+	if v.Type().Implements(textMarshalerType) || reflect.PtrTo(v.Type()).Implements(textMarshalerType) {
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		if text, err := ptr.Interface().(encoding.TextMarshaler).MarshalText(); err == nil {
+			return string(text)
+		}
+		return ""
+	}
+
+	switch v.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface())
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return ""
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
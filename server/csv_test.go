@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type csvRowTest struct {
+	ID   int    `csv:"id"`
+	Name string `csv:"name"`
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+// produce sends rows until the request context is done, the returned channel is closed when it stops
+func produce(ctx *Context, rows chan<- csvRowTest) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(rows)
+		for i := 0; ; i++ {
+			select {
+			case rows <- csvRowTest{ID: i, Name: "row"}:
+			case <-ctx.Context().Done():
+				return
+			}
+		}
+	}()
+	return done
+}
+
+func TestCSVStreamEncoder(t *testing.T) {
+	ctx, _ := testContext(http.MethodGet, "/")
+	rows := make(chan csvRowTest, 2)
+	rows <- csvRowTest{ID: 1, Name: "john"}
+	rows <- csvRowTest{ID: 2, Name: "jane, jr"}
+	close(rows)
+
+	var buf bytes.Buffer
+	if err := csvStreamEncoder(ctx, &buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,name\n1,john\n2,\"jane, jr\"\n"; buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestCSVStreamEncoderStops(t *testing.T) {
+	tests := []struct {
+		name   string
+		w      io.Writer
+		cancel bool
+	}{
+		{"request cancelled", &bytes.Buffer{}, true},
+		{"write error", failingWriter{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := testContext(http.MethodGet, "/")
+			c, cancel := context.WithCancel(ctx.Request.Context())
+			ctx.Request = ctx.Request.WithContext(c)
+			rows := make(chan csvRowTest)
+			stopped := produce(ctx, rows)
+
+			result := make(chan error, 1)
+			go func() {
+				result <- csvStreamEncoder(ctx, tt.w, rows)
+			}()
+			if tt.cancel {
+				cancel()
+			}
+			select {
+			case err := <-result:
+				if tt.cancel && !errors.Is(err, context.Canceled) {
+					t.Errorf("expected the context error, got %v", err)
+				}
+				if !tt.cancel && err == nil {
+					t.Error("expected the write error")
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatal("the encoder did not stop")
+			}
+
+			// the request context is cancelled once the request is handled, which releases the producer
+			cancel()
+			select {
+			case <-stopped:
+			case <-time.After(time.Second * 5):
+				t.Fatal("the producer is blocked")
+			}
+		})
+	}
+}
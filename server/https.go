@@ -16,43 +16,82 @@ import (
 	"io/ioutil"
 	"math/big"
 	"net"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/acme/autocert"
 )
 
-// Manager interface is required by the server to be used as certificate provider
+// Manager interface is required by the server to be used as certificate provider. TLSConfig returns the
+// configuration the server is started with, including the minimum TLS version and cipher suites
 type Manager interface {
 	GetCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error)
 	GetCertificateFiles() (string, string)
 	TLSConfig() *tls.Config
 }
 
-// externalCertManager returns the HTTPS certificate defined from system files
+// externalCertManager returns the HTTPS certificate defined from system files. The files are checked
+// for changes every reloadInterval so rotated certificates are used without restarting the server
 type externalCertManager struct {
-	cert     *tls.Certificate
-	certFile string
-	keyFile  string
+	cert           *tls.Certificate
+	certFile       string
+	keyFile        string
+	reloadInterval time.Duration
+	lastCheck      time.Time
+	certMod        time.Time
+	keyMod         time.Time
+	opts           tlsOptions
+	lock           sync.Mutex
 }
 
-func newExternalCertificate(certFile, keyFile string) (m Manager) {
-	return &externalCertManager{certFile: certFile, keyFile: keyFile}
+func newExternalCertificate(certFile, keyFile string, reloadInterval time.Duration, opts tlsOptions) (m Manager) {
+	return &externalCertManager{certFile: certFile, keyFile: keyFile, reloadInterval: reloadInterval, opts: opts}
+}
+func (v *externalCertManager) TLSConfig() *tls.Config {
+	return v.opts.apply(&tls.Config{GetCertificate: v.GetCertificate})
 }
-func (v *externalCertManager) TLSConfig() *tls.Config                { return nil }
 func (v *externalCertManager) GetCertificateFiles() (string, string) { return v.certFile, v.keyFile }
 func (v *externalCertManager) GetCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	if v.cert != nil {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.cert != nil && (v.reloadInterval <= 0 || time.Since(v.lastCheck) < v.reloadInterval) {
+		return v.cert, nil
+	}
+	v.lastCheck = time.Now()
+
+	certMod, keyMod := modTime(v.certFile), modTime(v.keyFile)
+	if v.cert != nil && certMod.Equal(v.certMod) && keyMod.Equal(v.keyMod) {
 		return v.cert, nil
 	}
 
 	cert, err := tls.LoadX509KeyPair(v.certFile, v.keyFile)
 	if err != nil {
+		if v.cert != nil {
+			// keep serving the old certificate, the files might be in the middle of being replaced
+			log.Errorf("failed to reload certificate: %s", err)
+			return v.cert, nil
+		}
 		return nil, err
 	}
+	if v.cert != nil {
+		log.Infof("certificate %s reloaded", v.certFile)
+	}
 	v.cert = &cert
+	v.certMod, v.keyMod = certMod, keyMod
 
-	return &cert, err
+	return &cert, nil
+}
+
+// modTime returns the last modification time of the file or zero time if the file can't be read
+func modTime(name string) time.Time {
+	stat, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return stat.ModTime()
 }
 
 // letsEncryptManager returns generates a certificate signed by Let's Encrypt on the first request it receives
@@ -60,9 +99,10 @@ func (v *externalCertManager) GetCertificate(clientHello *tls.ClientHelloInfo) (
 type letsEncryptManager struct {
 	manager *autocert.Manager
 	dir     string
+	opts    tlsOptions
 }
 
-func newLetsEncryptManager(hosts []string) (m Manager) {
+func newLetsEncryptManager(hosts []string, opts tlsOptions) (m Manager) {
 	dir, err := ioutil.TempDir("", "")
 	if err != nil {
 		log.Fatalf("could not create temp folder: %s", err)
@@ -74,40 +114,56 @@ func newLetsEncryptManager(hosts []string) (m Manager) {
 			HostPolicy: autocert.HostWhitelist(hosts...),
 			Cache:      autocert.DirCache(dir),
 		},
-		dir: dir,
+		dir:  dir,
+		opts: opts,
 	}
 }
-func (v *letsEncryptManager) TLSConfig() *tls.Config                { return v.manager.TLSConfig() }
+func (v *letsEncryptManager) TLSConfig() *tls.Config                { return v.opts.apply(v.manager.TLSConfig()) }
 func (v *letsEncryptManager) GetCertificateFiles() (string, string) { return "", "" }
 func (v *letsEncryptManager) GetCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	return v.manager.GetCertificate(clientHello)
 }
 
+// maxSelfSignedCerts is the number of generated certificates kept by the selfSignManager, the client
+// chooses the server name so the oldest ones are dropped to keep the memory bounded
+const maxSelfSignedCerts = 64
+
 // selfSignManager will use GO to generate a certificate. This is mostly used during development
 type selfSignManager struct {
-	cert *tls.Certificate
+	// generated certificates by server name
+	certs map[string]*tls.Certificate
+	// server names in the order the certificates were generated
+	names []string
+	opts  tlsOptions
+	lock  sync.Mutex
 }
 
-func newSelfSignManager() (m Manager) {
-	return &selfSignManager{}
+func newSelfSignManager(opts tlsOptions) (m Manager) {
+	return &selfSignManager{certs: make(map[string]*tls.Certificate), opts: opts}
 }
 func (v *selfSignManager) TLSConfig() *tls.Config {
-	return &tls.Config{
+	return v.opts.apply(&tls.Config{
 		GetCertificate: v.GetCertificate,
-	}
+	})
 }
 func (v *selfSignManager) GetCertificateFiles() (string, string) { return "", "" }
 func (v *selfSignManager) GetCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	if v.cert != nil {
-		return v.cert, nil
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	serverName := strings.ToLower(clientHello.ServerName)
+	if cert, ok := v.certs[serverName]; ok {
+		return cert, nil
 	}
 
 	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %s", err)
+	}
 
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
 	if err != nil {
-		log.Fatalf("Failed to generate serial number: %v", err)
+		return nil, fmt.Errorf("failed to generate serial number: %s", err)
 	}
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
@@ -122,8 +178,11 @@ func (v *selfSignManager) GetCertificate(clientHello *tls.ClientHelloInfo) (*tls
 		BasicConstraintsValid: true,
 	}
 
-	hosts := strings.Split(fmt.Sprintf("localhost,%s", clientHello.ServerName), ",")
+	hosts := strings.Split(fmt.Sprintf("localhost,%s", serverName), ",")
 	for _, h := range hosts {
+		if h == "" {
+			continue
+		}
 		if ip := net.ParseIP(h); ip != nil {
 			template.IPAddresses = append(template.IPAddresses, ip)
 		} else {
@@ -147,6 +206,9 @@ func (v *selfSignManager) GetCertificate(clientHello *tls.ClientHelloInfo) (*tls
 
 	keyBuf := &bytes.Buffer{}
 	block, err := pemBlockForKey(priv)
+	if err != nil {
+		return nil, err
+	}
 	if err := pem.Encode(keyBuf, block); err != nil {
 		return nil, err
 	}
@@ -155,7 +217,12 @@ func (v *selfSignManager) GetCertificate(clientHello *tls.ClientHelloInfo) (*tls
 	if err != nil {
 		return nil, err
 	}
-	v.cert = &cert
+	if len(v.names) >= maxSelfSignedCerts {
+		delete(v.certs, v.names[0])
+		v.names = v.names[1:]
+	}
+	v.certs[serverName] = &cert
+	v.names = append(v.names, serverName)
 
 	return &cert, err
 }
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestSelfSignManagerCache(t *testing.T) {
+	m := newSelfSignManager(tlsOptions{}).(*selfSignManager)
+	get := func(name string) *tls.Certificate {
+		t.Helper()
+		cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	first := get("example.com")
+	if get("EXAMPLE.com") != first {
+		t.Error("expected the certificate of the server name to be reused")
+	}
+	leaf, err := x509.ParseCertificate(first.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("example.com"); err != nil {
+		t.Error(err)
+	}
+	if get("") == nil {
+		t.Error("expected a certificate without server name")
+	}
+
+	for i := 0; i < maxSelfSignedCerts*2; i++ {
+		get(fmt.Sprintf("host%d.example.com", i))
+	}
+	if len(m.certs) != maxSelfSignedCerts || len(m.names) != maxSelfSignedCerts {
+		t.Errorf("expected %d cached certificates, got %d", maxSelfSignedCerts, len(m.certs))
+	}
+	if get("example.com") == first {
+		t.Error("expected the oldest certificate to be dropped")
+	}
+}
+
+func TestManagersTLSConfig(t *testing.T) {
+	cfg := &HTTPSConfig{MinTLSVersion: "1.1", CipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+	opts, err := cfg.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	letsEncrypt := newLetsEncryptManager([]string{"example.com"}, opts)
+	defer os.RemoveAll(letsEncrypt.(*letsEncryptManager).dir)
+
+	tests := []struct {
+		name    string
+		manager Manager
+	}{
+		{"external", newExternalCertificate("cert.pem", "key.pem", 0, opts)},
+		{"self-signed", newSelfSignManager(opts)},
+		{"lets-encrypt", letsEncrypt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.manager.TLSConfig()
+			if c == nil || c.GetCertificate == nil {
+				t.Fatal("expected a configuration getting the certificates from the manager")
+			}
+			if c.MinVersion != tls.VersionTLS11 {
+				t.Errorf("expected the minimum version %x, got %x", tls.VersionTLS11, c.MinVersion)
+			}
+			if len(c.CipherSuites) != 1 || c.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+				t.Errorf("expected the configured cipher suites, got %v", c.CipherSuites)
+			}
+		})
+	}
+}
@@ -2,14 +2,21 @@ package server
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/binary"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"reflect"
+	"strings"
 
 	"github.com/go-restruct/restruct"
+	"github.com/najibulloShapoatov/server-core/utils/reflection"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
 )
 
 // InputFunc is the signature a decoder must implement to be registered as valid input decoder
@@ -18,11 +25,17 @@ type InputFunc func(ctx *Context, h *handler) ([]interface{}, error)
 // OutputFunc is the signature a encoder must implement to be registered as valid output encoder
 type OutputFunc func(ctx *Context, params ...interface{}) ([]byte, error)
 
+// StreamOutputFunc is the signature a streaming encoder must implement. It writes the handler results
+// directly to w, so large responses don't have to be buffered in memory
+type StreamOutputFunc func(ctx *Context, w io.Writer, params ...interface{}) error
+
 var (
 	// map of registered decoders
 	inputDecoders = map[string]InputFunc{}
 	// map of registered encoders
-	outputEncoder   = map[string]OutputFunc{}
+	outputEncoder = map[string]OutputFunc{}
+	// map of registered streaming encoders
+	streamEncoder   = map[string]StreamOutputFunc{}
 	invalidInputErr = fmt.Errorf("invalid input")
 )
 
@@ -36,6 +49,29 @@ func RegisterEncoder(contentType string, outFunc OutputFunc) {
 	outputEncoder[contentType] = outFunc
 }
 
+// RegisterStreamEncoder registers a StreamOutputFunc encoder that can handle the given MIME type. The
+// streaming encoder is preferred over the OutputFunc registered for the same MIME type
+func RegisterStreamEncoder(contentType string, outFunc StreamOutputFunc) {
+	streamEncoder[contentType] = outFunc
+}
+
+// encoderFor returns the encoder registered for the MIME type. If there's only a streaming encoder,
+// its output is buffered
+func encoderFor(contentType string) (OutputFunc, bool) {
+	if enc, ok := outputEncoder[contentType]; ok {
+		return enc, true
+	}
+	stream, ok := streamEncoder[contentType]
+	if !ok {
+		return nil, false
+	}
+	return func(ctx *Context, params ...interface{}) ([]byte, error) {
+		var buf bytes.Buffer
+		err := stream(ctx, &buf, params...)
+		return buf.Bytes(), err
+	}, true
+}
+
 func xmlInputDecoder(ctx *Context, h *handler) (res []interface{}, err error) {
 	defer func() {
 		e := recover()
@@ -150,7 +186,7 @@ func jsonOutputEncoder(ctx *Context, params ...interface{}) ([]byte, error) {
 	return json.Marshal(params)
 }
 
-func grpcInputDecoder(ctx *Context, h *handler) (res []interface{}, err error) {
+func msgpackInputDecoder(ctx *Context, h *handler) (res []interface{}, err error) {
 	defer func() {
 		e := recover()
 		if e != nil {
@@ -162,6 +198,16 @@ func grpcInputDecoder(ctx *Context, h *handler) (res []interface{}, err error) {
 	if err != nil {
 		return
 	}
+
+	var temp = make([]msgpack.RawMessage, 0)
+	if h.FuncRef.NumIn() > 3 {
+		_ = msgpackUnmarshal(data, &temp)
+	}
+
+	if h.FuncRef.NumIn() > 3 && len(temp) != h.FuncRef.NumIn()-2 {
+		return nil, fmt.Errorf("invalid number of input parameters")
+	}
+
 	_ = ctx.Request.Body.Close()
 	for i := 2; i < h.FuncRef.NumIn(); i++ {
 		var typ = h.FuncRef.In(i)
@@ -170,21 +216,66 @@ func grpcInputDecoder(ctx *Context, h *handler) (res []interface{}, err error) {
 		if typ.Kind() == reflect.Ptr {
 			x = reflect.New(typ.Elem()).Interface()
 		}
-		if bytes.Equal(data, []byte("null")) {
+
+		src := data
+		if len(temp) != 0 {
+			src = temp[i-2]
+		}
+		if bytes.Equal(src, []byte{msgpcode.Nil}) {
 			nilType := h.FuncRef.In(i)
 			res = add(res, typ.Kind(), x, reflect.Zero(nilType).Interface())
 		} else {
-			if err := restruct.Unpack(data, binary.BigEndian, x); err == nil {
+			if err := msgpackUnmarshal(src, x); err == nil {
 				res = add(res, typ.Kind(), x, x)
 			} else {
-				nilType := h.FuncRef.In(i)
-				res = add(res, typ.Kind(), x, reflect.Zero(nilType).Interface())
+				return nil, invalidInputErr
 			}
 		}
 	}
 	return
 }
 
+func msgpackOutputEncoder(ctx *Context, params ...interface{}) ([]byte, error) {
+	if len(params) == 1 {
+		return msgpackMarshal(params[0])
+	}
+	return msgpackMarshal(params)
+}
+
+// msgpackMarshal encodes v as MessagePack using the `json` tags of the structures, so the fields have
+// the same names as in the JSON output
+func msgpackMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func msgpackUnmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func grpcInputDecoder(ctx *Context, h *handler) (res []interface{}, err error) {
+	defer func() {
+		e := recover()
+		if e != nil {
+			res = nil
+			err = invalidInputErr
+		}
+	}()
+	data, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return
+	}
+	_ = ctx.Request.Body.Close()
+	return unpackInput(data, h)
+}
+
 func grpcOutputEncoder(ctx *Context, params ...interface{}) ([]byte, error) {
 	if len(params) == 1 {
 		return restruct.Pack(binary.BigEndian, params[0])
@@ -204,23 +295,15 @@ func binaryInputDecoder(ctx *Context, h *handler) (res []interface{}, err error)
 	if err != nil {
 		return
 	}
-
-	add := func(typ reflect.Kind, def, item interface{}) {
-		if typ == reflect.Ptr {
-			res = append(res, item)
-		} else {
-			if item == nil {
-				item = def
-			}
-			if reflect.TypeOf(item).Kind() == reflect.Ptr {
-				res = append(res, reflect.ValueOf(item).Elem().Interface())
-			} else {
-				res = append(res, item)
-			}
-		}
-	}
-
 	_ = ctx.Request.Body.Close()
+	return unpackInput(data, h)
+}
+
+// unpackInput decodes the handler parameters packed one after the other in data. A payload that
+// doesn't hold exactly the handler parameters is rejected
+func unpackInput(data []byte, h *handler) (res []interface{}, err error) {
+	null := bytes.Equal(data, []byte("null"))
+	offset := 0
 	for i := 2; i < h.FuncRef.NumIn(); i++ {
 		var typ = h.FuncRef.In(i)
 		var x = reflect.New(typ).Interface()
@@ -228,17 +311,22 @@ func binaryInputDecoder(ctx *Context, h *handler) (res []interface{}, err error)
 		if typ.Kind() == reflect.Ptr {
 			x = reflect.New(typ.Elem()).Interface()
 		}
-		if bytes.Equal(data, []byte("null")) {
-			nilType := h.FuncRef.In(i)
-			add(typ.Kind(), x, reflect.Zero(nilType).Interface())
-		} else {
-			if err := restruct.Unpack(data, binary.BigEndian, x); err == nil {
-				add(typ.Kind(), x, x)
-			} else {
-				nilType := h.FuncRef.In(i)
-				add(typ.Kind(), x, reflect.Zero(nilType).Interface())
-			}
+		if null {
+			res = add(res, typ.Kind(), x, reflect.Zero(typ).Interface())
+			continue
 		}
+		if err := restruct.Unpack(data[offset:], binary.BigEndian, x); err != nil {
+			return nil, invalidInputErr
+		}
+		size, err := restruct.SizeOf(x)
+		if err != nil {
+			return nil, invalidInputErr
+		}
+		offset += size
+		res = add(res, typ.Kind(), x, x)
+	}
+	if !null && h.FuncRef.NumIn() > 2 && offset != len(data) {
+		return nil, invalidInputErr
 	}
 	return
 }
@@ -266,8 +354,264 @@ func binaryOutputEncoder(ctx *Context, params ...interface{}) ([]byte, error) {
 	return restruct.Pack(binary.BigEndian, params)
 }
 
-func multipartInputDecoder(ctx *Context, h *handler) ([]interface{}, error) {
-	return nil, nil
+// multipartInputDecoder maps a multipart form to the handler parameters. Structure parameters are filled by
+// matching the form fields to the structure fields (using the `form`, `query` or `json` tags or the field
+// name). Go doesn't keep the parameter names so simple values, multipart.File and *multipart.FileHeader
+// parameters are read from the form fields named by the module ParamNamer, the handler fails with an
+// invalid input error if they are not named. Opened files are closed once the handler returns
+func multipartInputDecoder(ctx *Context, h *handler) (res []interface{}, err error) {
+	defer func() {
+		e := recover()
+		if e != nil {
+			res = nil
+			err = invalidInputErr
+		}
+	}()
+	if !ctx.parsed {
+		if err = ctx.Request.ParseMultipartForm(int64(ctx.Server.Config.PostMaxSize)); err != nil {
+			return nil, err
+		}
+		ctx.parsed = true
+	}
+	form := ctx.Request.MultipartForm
+	if form == nil {
+		return nil, invalidInputErr
+	}
+	return formParams(form, h)
+}
+
+// formInputDecoder maps an url encoded form to the handler parameters the same way as the multipart
+// decoder: structures are filled by field name and simple values are read from the form field with
+// the parameter name
+func formInputDecoder(ctx *Context, h *handler) (res []interface{}, err error) {
+	defer func() {
+		e := recover()
+		if e != nil {
+			res = nil
+			err = invalidInputErr
+		}
+	}()
+	if !ctx.parsed {
+		if err = ctx.Request.ParseForm(); err != nil {
+			return nil, err
+		}
+		ctx.parsed = true
+	}
+	return formParams(&multipart.Form{Value: ctx.Request.PostForm}, h)
+}
+
+// formParams decodes the handler parameters from the form values and files
+func formParams(form *multipart.Form, h *handler) (res []interface{}, err error) {
+	for i := 2; i < h.FuncRef.NumIn(); i++ {
+		var typ = h.FuncRef.In(i)
+		var isStruct = typ.Kind() == reflect.Struct || typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Struct
+
+		var name string
+		if len(h.ParamNames) != 0 {
+			name = h.ParamNames[i-2]
+		} else if !isStruct || typ == fileHeaderType {
+			closeFiles(res)
+			return nil, fmt.Errorf("parameter %d of %s has no name", i-2, h.FuncRef.Name)
+		}
+
+		switch {
+		case typ == fileHeaderType:
+			res = append(res, formFile(form, name))
+
+		case typ == fileType:
+			fh := formFile(form, name)
+			if fh == nil {
+				closeFiles(res)
+				return nil, fmt.Errorf("missing file %q", name)
+			}
+			file, err := fh.Open()
+			if err != nil {
+				closeFiles(res)
+				return nil, err
+			}
+			res = append(res, file)
+
+		case reflection.IsSimpleType(typ.Kind()):
+			val := reflect.Zero(typ)
+			if values := form.Value[name]; len(values) != 0 {
+				v, err := reflection.ReflectSimpleValue(values[0], typ)
+				if err != nil {
+					closeFiles(res)
+					return nil, invalidInputErr
+				}
+				val = v.Convert(typ)
+			}
+			res = append(res, val.Interface())
+
+		case isStruct:
+			x := reflect.New(typ)
+			if typ.Kind() == reflect.Ptr {
+				x = reflect.New(typ.Elem())
+			}
+			if err := bindForm(x.Elem(), form); err != nil {
+				closeFiles(res)
+				return nil, invalidInputErr
+			}
+			res = add(res, typ.Kind(), x.Interface(), x.Interface())
+
+		default:
+			x := reflect.New(typ)
+			if values := form.Value[name]; len(values) != 0 {
+				if err := json.Unmarshal([]byte(values[0]), x.Interface()); err != nil {
+					closeFiles(res)
+					return nil, invalidInputErr
+				}
+			}
+			res = append(res, x.Elem().Interface())
+		}
+	}
+	return
+}
+
+// closeFiles closes the multipart files opened for the handler parameters
+func closeFiles(params []interface{}) {
+	for _, p := range params {
+		if f, ok := p.(multipart.File); ok && f != nil {
+			_ = f.Close()
+		}
+	}
+}
+
+// queryInputDecoder fills the structure parameters of the handler from the query string values
+// using the same field mapping as the multipart decoder
+func queryInputDecoder(ctx *Context, h *handler) (res []interface{}, err error) {
+	defer func() {
+		e := recover()
+		if e != nil {
+			res = nil
+			err = invalidInputErr
+		}
+	}()
+	form := &multipart.Form{Value: ctx.Request.URL.Query()}
+	for i := 2; i < h.FuncRef.NumIn(); i++ {
+		var typ = h.FuncRef.In(i)
+		x := reflect.New(typ)
+		if typ.Kind() == reflect.Ptr {
+			x = reflect.New(typ.Elem())
+		}
+		if err := bindForm(x.Elem(), form); err != nil {
+			return nil, invalidInputErr
+		}
+		res = add(res, typ.Kind(), x.Interface(), x.Interface())
+	}
+	return
+}
+
+var (
+	fileHeaderType    = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileHeadersType   = reflect.TypeOf([]*multipart.FileHeader(nil))
+	fileType          = reflect.TypeOf((*multipart.File)(nil)).Elem()
+	textUnmarshalType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func formFile(form *multipart.Form, name string) *multipart.FileHeader {
+	if files := form.File[name]; len(files) != 0 {
+		return files[0]
+	}
+	return nil
+}
+
+// bindForm fills the structure fields with the values from the multipart form
+func bindForm(v reflect.Value, form *multipart.Form) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := formFieldName(field)
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+
+		switch field.Type {
+		case fileHeaderType:
+			if files := formFiles(form.File, name); len(files) != 0 {
+				fv.Set(reflect.ValueOf(files[0]))
+			}
+			continue
+		case fileHeadersType:
+			if files := formFiles(form.File, name); len(files) != 0 {
+				fv.Set(reflect.ValueOf(files))
+			}
+			continue
+		}
+
+		values := formValues(form.Value, name)
+		if len(values) == 0 {
+			continue
+		}
+		switch {
+		case reflect.PtrTo(field.Type).Implements(textUnmarshalType):
+			if err := fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(values[0])); err != nil {
+				return err
+			}
+		case reflection.IsSimpleType(field.Type.Kind()):
+			val, err := reflection.ReflectSimpleValue(values[0], field.Type)
+			if err != nil {
+				return err
+			}
+			fv.Set(val.Convert(field.Type))
+		case field.Type.Kind() == reflect.Slice && reflection.IsSimpleType(field.Type.Elem().Kind()):
+			list := reflect.MakeSlice(field.Type, 0, len(values))
+			for _, value := range values {
+				val, err := reflection.ReflectSimpleValue(value, field.Type.Elem())
+				if err != nil {
+					return err
+				}
+				list = reflect.Append(list, val.Convert(field.Type.Elem()))
+			}
+			fv.Set(list)
+		default:
+			// complex values are expected to be sent as JSON
+			if err := json.Unmarshal([]byte(values[0]), fv.Addr().Interface()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formFieldName returns the name of the form field bound to the structure field
+func formFieldName(field reflect.StructField) string {
+	for _, key := range []string{"form", "query", "json"} {
+		if tag := strings.Split(field.Tag.Get(key), ",")[0]; tag != "" {
+			return tag
+		}
+	}
+	return field.Name
+}
+
+// formValues looks up the form values by name, ignoring the case if there's no exact match
+func formValues(values map[string][]string, name string) []string {
+	if res, ok := values[name]; ok {
+		return res
+	}
+	for key, res := range values {
+		if strings.EqualFold(key, name) {
+			return res
+		}
+	}
+	return nil
+}
+
+// formFiles looks up the form files by name, ignoring the case if there's no exact match
+func formFiles(files map[string][]*multipart.FileHeader, name string) []*multipart.FileHeader {
+	if res, ok := files[name]; ok {
+		return res
+	}
+	for key, res := range files {
+		if strings.EqualFold(key, name) {
+			return res
+		}
+	}
+	return nil
 }
 
 func init() {
@@ -275,14 +619,22 @@ func init() {
 	RegisterDecoder("application/xml", xmlInputDecoder)
 	RegisterDecoder("text/json", jsonInputDecoder)
 	RegisterDecoder("application/json", jsonInputDecoder)
+	RegisterDecoder("application/msgpack", msgpackInputDecoder)
+	RegisterDecoder("application/x-msgpack", msgpackInputDecoder)
 	RegisterDecoder("application/grpc+octet-stream", grpcInputDecoder)
 	RegisterDecoder("application/octet-stream", binaryInputDecoder)
 	RegisterDecoder("multipart/form-data", multipartInputDecoder)
+	RegisterDecoder("application/x-www-form-urlencoded", formInputDecoder)
 
 	RegisterEncoder("text/xml", xmlOutputEncoder)
 	RegisterEncoder("application/xml", xmlOutputEncoder)
 	RegisterEncoder("text/json", jsonOutputEncoder)
 	RegisterEncoder("application/json", jsonOutputEncoder)
+	RegisterEncoder("application/msgpack", msgpackOutputEncoder)
+	RegisterEncoder("application/x-msgpack", msgpackOutputEncoder)
 	RegisterEncoder("application/grpc+octet-stream", grpcOutputEncoder)
 	RegisterEncoder("application/octet-stream", binaryOutputEncoder)
+	RegisterEncoder("text/csv", csvOutputEncoder)
+
+	RegisterStreamEncoder("text/csv", csvStreamEncoder)
 }
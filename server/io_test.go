@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+type testUser struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+}
+
+func (testService) AddUser(ctx *Context, user *testUser) (*testUser, int, error) {
+	return user, http.StatusOK, nil
+}
+
+func (testService) CreateGreeting(ctx *Context, user *testUser, count int) (string, int, error) {
+	return user.Name, count, nil
+}
+
+func (testService) ParamNames() map[string][]string {
+	return map[string][]string{
+		"AddUpload":      {"title", "count", "file"},
+		"CreateGreeting": {"user", "count"},
+	}
+}
+
+// uploaded is the last file received by AddUpload
+var uploaded multipart.File
+
+func (testService) AddUpload(ctx *Context, title string, count int, file multipart.File) (string, int, error) {
+	uploaded = file
+	if title == "panic" {
+		panic(errors.New("boom"))
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	return fmt.Sprintf("%s %d %s", title, count, data), http.StatusOK, nil
+}
+
+func (testService) EditScore(ctx *Context, score int) (int, error) {
+	return score, nil
+}
+
+// testHandler returns the handler of the testService for the given method and name
+func testHandler(t *testing.T, key string) handler {
+	t.Helper()
+	handlers, err := analyze(testService{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, ok := handlers[key]
+	if !ok {
+		t.Fatalf("handler %s not registered", key)
+	}
+	return h
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	user := &testUser{Name: "john"}
+	data, err := msgpackOutputEncoder(nil, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := msgpackUnmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["name"] != "john" {
+		t.Errorf("expected the fields named by their json tags, got %v", got)
+	}
+}
+
+func TestMsgpackInputDecoder(t *testing.T) {
+	single, _ := msgpackMarshal(&testUser{Name: "john", Email: "john@example.com"})
+	nilValue, _ := msgpackMarshal(nil)
+	multiple, _ := msgpackMarshal([]interface{}{&testUser{Name: "jane"}, 3})
+	wrongCount, _ := msgpackMarshal([]interface{}{&testUser{Name: "jane"}})
+
+	tests := []struct {
+		name    string
+		key     string
+		body    []byte
+		want    []interface{}
+		wantErr bool
+	}{
+		{"single parameter", http.MethodPost + "user", single, []interface{}{&testUser{Name: "john", Email: "john@example.com"}}, false},
+		{"nil parameter", http.MethodPost + "user", nilValue, []interface{}{(*testUser)(nil)}, false},
+		{"parameter list", http.MethodPost + "greeting", multiple, []interface{}{&testUser{Name: "jane"}, 3}, false},
+		{"wrong number of parameters", http.MethodPost + "greeting", wrongCount, nil, true},
+		{"invalid data", http.MethodPost + "user", []byte{0xc1}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testHandler(t, tt.key)
+			ctx, _ := testContext(http.MethodPost, "/test/v1/"+h.Name)
+			ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(tt.body))
+			got, err := msgpackInputDecoder(ctx, &h)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !jsonEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// multipartBody returns a multipart form with the given fields and a file field
+func multipartBody(t *testing.T, fields map[string]string, file string) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		_ = w.WriteField(name, value)
+	}
+	if file != "" {
+		fw, err := w.CreateFormFile("file", "file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = fw.Write([]byte(file))
+	}
+	_ = w.Close()
+	return &buf, w.FormDataContentType()
+}
+
+func TestFormParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		body    func(t *testing.T) (io.Reader, string)
+		want    []interface{}
+		wantErr bool
+	}{
+		{
+			name: "urlencoded simple values by name",
+			key:  http.MethodPost + "greeting",
+			body: func(t *testing.T) (io.Reader, string) {
+				form := url.Values{"count": {"2"}, "name": {"jane"}}
+				return strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"
+			},
+			want: []interface{}{&testUser{Name: "jane"}, 2},
+		},
+		{
+			name: "urlencoded structure without names",
+			key:  http.MethodPost + "user",
+			body: func(t *testing.T) (io.Reader, string) {
+				form := url.Values{"name": {"john"}, "email": {"john@example.com"}}
+				return strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"
+			},
+			want: []interface{}{&testUser{Name: "john", Email: "john@example.com"}},
+		},
+		{
+			name: "unnamed simple value",
+			key:  http.MethodPut + "score",
+			body: func(t *testing.T) (io.Reader, string) {
+				return strings.NewReader("0=3"), "application/x-www-form-urlencoded"
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid simple value",
+			key:  http.MethodPost + "greeting",
+			body: func(t *testing.T) (io.Reader, string) {
+				return strings.NewReader("count=two"), "application/x-www-form-urlencoded"
+			},
+			wantErr: true,
+		},
+		{
+			name: "multipart missing file",
+			key:  http.MethodPost + "upload",
+			body: func(t *testing.T) (io.Reader, string) {
+				return multipartBody(t, map[string]string{"title": "report", "count": "1"}, "")
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testHandler(t, tt.key)
+			ctx, _ := testContext(http.MethodPost, "/test/v1/"+h.Name)
+			ctx.Server.Config.PostMaxSize = 1 << 20
+			body, contentType := tt.body(t)
+			ctx.Request = httptest.NewRequest(http.MethodPost, "/test/v1/"+h.Name, body)
+			ctx.Request.Header.Set("Content-Type", contentType)
+
+			mediaType, _, _ := mime.ParseMediaType(contentType)
+			got, err := inputDecoders[mediaType](ctx, &h)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !jsonEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMultipartUploadClosesFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		title  string
+		status int
+		body   string
+	}{
+		{"returned", "report", http.StatusOK, `"report 3 content"`},
+		{"panicked", "panic", http.StatusInternalServerError, `{"error":"boom","requestId":""}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testHandler(t, http.MethodPost+"upload")
+			ctx, rec := testContext(http.MethodPost, "/test/v1/"+h.Name)
+			// the files are stored on disk instead of memory
+			ctx.Server.Config.PostMaxSize = 1
+			body, contentType := multipartBody(t, map[string]string{"title": tt.title, "count": "3"}, "content")
+			ctx.Request = httptest.NewRequest(http.MethodPost, "/test/v1/"+h.Name, body)
+			ctx.Request.Header.Set("Content-Type", contentType)
+			ctx.Request.Header.Set("Accept", "application/json")
+			defer func() { _ = ctx.Request.MultipartForm.RemoveAll() }()
+
+			_ = recoverMiddleware(h.Handler)(ctx)
+			if rec.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rec.Code)
+			}
+			if strings.TrimSpace(rec.Body.String()) != tt.body {
+				t.Errorf("expected %s, got %s", tt.body, rec.Body.String())
+			}
+			if _, err := uploaded.Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+				t.Errorf("expected the file to be closed after the handler, got %v", err)
+			}
+		})
+	}
+}
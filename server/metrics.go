@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics collects the server telemetry. A custom implementation can be set using SetMetrics
+type Metrics interface {
+	// IncActive increments the number of requests being processed
+	IncActive()
+	// DecActive decrements the number of requests being processed
+	DecActive()
+	// ObserveStatus counts a response status code
+	ObserveStatus(code int)
+	// ObserveLatency records the time taken to handle a request
+	ObserveLatency(d time.Duration)
+	// ObserveSize records the size of a response
+	ObserveSize(n int64)
+}
+
+var metrics Metrics = NewMetrics()
+
+// SetMetrics replaces the metrics collector used by the server. If the collector implements
+// http.Handler it will be used to serve the metrics endpoint
+func SetMetrics(m Metrics) {
+	metrics = m
+}
+
+// GetMetrics returns the metrics collector used by the server
+func GetMetrics() Metrics {
+	return metrics
+}
+
+// metricsHandler serves the metrics endpoint if the metrics collector implements http.Handler
+func metricsHandler(ctx *Context) error {
+	h, ok := metrics.(http.Handler)
+	if !ok {
+		http.NotFound(ctx.Response, ctx.Request)
+		return nil
+	}
+	h.ServeHTTP(ctx.Response, ctx.Request)
+	return nil
+}
+
+var (
+	latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	sizeBuckets    = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+// defaultMetrics keeps the metrics in memory and exposes them in the Prometheus text format
+type defaultMetrics struct {
+	active   int64
+	lock     sync.Mutex
+	statuses map[int]uint64
+	latency  *histogram
+	size     *histogram
+}
+
+// NewMetrics returns a metrics collector that can be scraped by Prometheus
+func NewMetrics() Metrics {
+	return &defaultMetrics{
+		statuses: make(map[int]uint64),
+		latency:  newHistogram(latencyBuckets),
+		size:     newHistogram(sizeBuckets),
+	}
+}
+
+func (m *defaultMetrics) IncActive() { atomic.AddInt64(&m.active, 1) }
+func (m *defaultMetrics) DecActive() { atomic.AddInt64(&m.active, -1) }
+
+func (m *defaultMetrics) ObserveStatus(code int) {
+	m.lock.Lock()
+	m.statuses[code]++
+	m.lock.Unlock()
+}
+
+func (m *defaultMetrics) ObserveLatency(d time.Duration) {
+	m.lock.Lock()
+	m.latency.observe(d.Seconds())
+	m.lock.Unlock()
+}
+
+func (m *defaultMetrics) ObserveSize(n int64) {
+	m.lock.Lock()
+	m.size.observe(float64(n))
+	m.lock.Unlock()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *defaultMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	m.write(w)
+}
+
+// write writes the metrics in the Prometheus text exposition format
+func (m *defaultMetrics) write(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, _ = fmt.Fprintf(w, "# HELP http_requests_active Number of requests being processed\n")
+	_, _ = fmt.Fprintf(w, "# TYPE http_requests_active gauge\n")
+	_, _ = fmt.Fprintf(w, "http_requests_active %d\n", atomic.LoadInt64(&m.active))
+
+	codes := make([]int, 0, len(m.statuses))
+	for code := range m.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	_, _ = fmt.Fprintf(w, "# HELP http_responses_total Number of responses by status code\n")
+	_, _ = fmt.Fprintf(w, "# TYPE http_responses_total counter\n")
+	for _, code := range codes {
+		_, _ = fmt.Fprintf(w, "http_responses_total{code=\"%d\"} %d\n", code, m.statuses[code])
+	}
+
+	m.latency.write(w, "http_request_duration_seconds", "Time taken to handle the requests")
+	m.size.write(w, "http_response_size_bytes", "Size of the responses")
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	_, _ = fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for i, b := range h.buckets {
+		_, _ = fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, b, h.counts[i])
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	_, _ = fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	_, _ = fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
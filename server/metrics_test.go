@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// countingMetrics counts the observed statuses
+type countingMetrics struct {
+	statuses []int
+}
+
+func (m *countingMetrics) IncActive()                     {}
+func (m *countingMetrics) DecActive()                     {}
+func (m *countingMetrics) ObserveStatus(code int)         { m.statuses = append(m.statuses, code) }
+func (m *countingMetrics) ObserveLatency(d time.Duration) {}
+func (m *countingMetrics) ObserveSize(n int64)            {}
+
+func TestMonitoringMiddleware(t *testing.T) {
+	previous := GetMetrics()
+	defer SetMetrics(previous)
+
+	tests := []struct {
+		name    string
+		enabled bool
+		want    int
+	}{
+		{"metrics disabled", false, 0},
+		{"metrics enabled", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &countingMetrics{}
+			SetMetrics(m)
+			ctx, _ := testContext(http.MethodGet, "/")
+			ctx.Server.Config.Metrics = &MetricsConfig{Enabled: tt.enabled, Path: "/metrics"}
+			err := monitoringMiddleware(func(ctx *Context) error {
+				ctx.Response.WriteHeader(http.StatusCreated)
+				return nil
+			})(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.statuses) != tt.want {
+				t.Errorf("expected %d observed statuses, got %v", tt.want, m.statuses)
+			}
+		})
+	}
+}
+
+func TestMetricsEndpointUsesMiddlewares(t *testing.T) {
+	s := testServer(t)
+	s.Config.Metrics = &MetricsConfig{Enabled: true, Path: "/metrics"}
+	var seen []string
+	defer func() { middlewares = middlewares[:0] }()
+	UseMiddleware(func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) error {
+			seen = append(seen, ctx.Request.URL.Path)
+			return next(ctx)
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("expected the metrics, got %d %q", rec.Code, rec.Body.String())
+	}
+	if len(seen) != 1 || seen[0] != "/metrics" {
+		t.Errorf("expected the metrics request to go through the middlewares, got %v", seen)
+	}
+}
@@ -4,18 +4,24 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/najibulloShapoatov/server-core/monitoring/log"
 	"github.com/najibulloShapoatov/server-core/server/security"
-	"github.com/najibulloShapoatov/server-core/server/session"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/andybalholm/brotli"
+	"github.com/gorilla/websocket"
 )
 
 const (
@@ -37,7 +43,14 @@ const (
 	headerXCSRF                 = "X-Csrf-Token"
 	headerDNT                   = "DNT"
 	headerXTrace                = "X-Trace-Id"
+	headerTraceParent           = "Traceparent"
 	headerTK                    = "Tk"
+	headerRetryAfter            = "Retry-After"
+	headerRateLimitLimit        = "X-RateLimit-Limit"
+	headerRateLimitRemaining    = "X-RateLimit-Remaining"
+	headerRateLimitReset        = "X-RateLimit-Reset"
+
+	csrfCookieName = "csrf_token"
 )
 
 var middlewares = make([]Middleware, 0)
@@ -53,42 +66,49 @@ type HandlerFunc func(*Context) error
 // Middleware function signature
 type Middleware func(HandlerFunc) HandlerFunc
 
+// recoverMiddleware recovers from panics, responds with a 500 error encoded in the format
+// accepted by the client and returns the recovered error
 func recoverMiddleware(next HandlerFunc) HandlerFunc {
-	return func(ctx *Context) error {
+	return func(ctx *Context) (err error) {
 		defer func() {
 			r := recover()
-			if r != nil {
-				err, ok := r.(error)
-				if !ok {
-					err = fmt.Errorf("%v", err)
-				}
-				if err != nil {
-					log.Debugf("[RECOVERED] %s", err)
-				}
+			if r == nil {
+				return
+			}
+			e, ok := r.(error)
+			if !ok {
+				e = fmt.Errorf("%v", r)
 			}
+			log.Errorf("[RECOVERED] %s\n%s", e, debug.Stack())
+
+			if ctx.Response.Committed {
+				err = e
+				return
+			}
+			// the unwind skipped the close of the compressor, so the error is sent uncompressed
+			ctx.Response.Compressor(nil)
+			ctx.Response.Header().Del(headerContentEncoding)
+			encoder, contentType := negotiate(ctx.Request.Header.Get("Accept"))
+			data, _ := encoder(ctx, newErrorResponse(ctx, e))
+			ctx.Response.Header().Set("Content-Type", contentType)
+			ctx.Response.WriteHeader(http.StatusInternalServerError)
+			_, _ = ctx.Response.Write(data)
+			err = sentError{e}
 		}()
 		return next(ctx)
 	}
 }
+
 func authMiddleware(next HandlerFunc) HandlerFunc {
 	return func(ctx *Context) error {
 		// add session to the context
-		if ctx.Session == nil {
-			var sessionID session.Token
-			// search for a session id on the session cookie
-			cookie, _ := ctx.Request.Cookie(ctx.Server.Config.Session.CookieName)
-			// if session cookie is not present try on the session header
-			if cookie == nil {
-				sessionID = session.Token(ctx.Request.Header.Get("X-Session-Id"))
-			} else {
-				sessionID = session.Token(cookie.Value)
-			}
-			// if a valid session id is found restore the session
-			if sessionID.Valid() {
-				ctx.Session = session.Restore(sessionID)
-			}
+		ctx.restoreSession()
+		err := next(ctx)
+		// write the changes of sessions with deferred writes
+		if ctx.Session != nil && ctx.Session.Modified() {
+			_ = ctx.Session.Save()
 		}
-		return next(ctx)
+		return err
 	}
 }
 
@@ -103,8 +123,6 @@ func preSecurityMiddleware(next HandlerFunc) HandlerFunc {
 		req := ctx.Request
 		res := ctx.Response
 
-		m := req.Method
-
 		// check if IP is whitelisted/blacklisted
 		wh := cfg.Whitelist
 		if len(wh) != 0 && !security.CheckIP(addr, strings.Split(wh, ",")) {
@@ -122,14 +140,10 @@ func preSecurityMiddleware(next HandlerFunc) HandlerFunc {
 		}
 
 		// Check and enforce CSRF token usage
-		if cfg.CSRFTokenRequired &&
-			ctx.Session != nil && (m == http.MethodPost || m == http.MethodPut || m == http.MethodPatch) {
-			if token := req.Header.Get(headerXCSRF); token != "" && token != ctx.Session.CSRFToken {
+		if cfg.CSRFTokenRequired {
+			if err := checkCSRF(ctx); err != nil {
 				res.WriteHeader(http.StatusNotAcceptable)
-				return errors.New("invalid CSRF token")
-			} else if token == "" {
-				res.WriteHeader(http.StatusNotAcceptable)
-				return errors.New("missing CSRF token")
+				return err
 			}
 		}
 
@@ -167,6 +181,37 @@ func preSecurityMiddleware(next HandlerFunc) HandlerFunc {
 	}
 }
 
+// checkCSRF validates the CSRF token header of state changing requests. Authenticated requests must
+// send the token stored in the session, while requests without a session must send the same token
+// as the one in the CSRF cookie (double submit cookie). The cookie is issued on safe requests
+func checkCSRF(ctx *Context) error {
+	switch ctx.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		if ctx.Session == nil {
+			if c, _ := ctx.Cookie(csrfCookieName); c == nil || c.Value == "" {
+				ctx.SetCookie(&http.Cookie{Name: csrfCookieName, Value: randomHex(32)})
+			}
+		}
+		return nil
+	}
+
+	token := ctx.Request.Header.Get(headerXCSRF)
+	if token == "" {
+		return errors.New("missing CSRF token")
+	}
+	var expected string
+	if ctx.Session != nil {
+		expected = ctx.Session.CSRFToken
+	} else if c, _ := ctx.Cookie(csrfCookieName); c != nil {
+		expected = c.Value
+	}
+	if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return errors.New("invalid CSRF token")
+	}
+	return nil
+}
+
 // Add required security headers
 func postSecurityMiddleware(next HandlerFunc) HandlerFunc {
 	return func(ctx *Context) error {
@@ -174,14 +219,16 @@ func postSecurityMiddleware(next HandlerFunc) HandlerFunc {
 		res := ctx.Response
 
 		if cfg.CORSOrigin != "" {
-			res.Header().Set(headerCORSAllowCreadentials, "true")
 			if cfg.CORSOrigin == "*" {
+				res.Header().Set(headerCORSAllowCreadentials, "true")
 				res.Header().Set(headerCORSOrigin, cfg.CORSOrigin)
-			} else if strings.Contains(cfg.CORSOrigin, ",") && strings.Contains(cfg.CORSOrigin, ctx.Request.Header.Get("Origin")) {
-				res.Header().Set(headerCORSOrigin, ctx.Request.Header.Get("Origin"))
-				res.Header().Set(headerVary, "Origin")
 			} else {
-				res.Header().Set(headerCORSOrigin, ctx.Request.Header.Get("Origin"))
+				// the response depends on the origin so caches must not share it
+				res.Header().Add(headerVary, "Origin")
+				if origin := ctx.Request.Header.Get("Origin"); origin != "" && parseOrigins(cfg.CORSOrigin).match(origin) {
+					res.Header().Set(headerCORSAllowCreadentials, "true")
+					res.Header().Set(headerCORSOrigin, origin)
+				}
 			}
 		}
 		if ctx.Request.Method == http.MethodOptions && cfg.CORSExposeHeaders != "" {
@@ -201,34 +248,141 @@ func postSecurityMiddleware(next HandlerFunc) HandlerFunc {
 	}
 }
 
+// allowedOrigin checks the Origin header of the request against the CORS origins from the configuration.
+// Requests without an Origin header or coming from the same host are always allowed
+func allowedOrigin(cfg *SecurityConfig, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if cfg == nil {
+		return false
+	}
+	return parseOrigins(cfg.CORSOrigin).match(origin)
+}
+
+// originSet holds the allowed CORS origins. Origins are matched exactly, except for
+// patterns like https://*.example.com which match any subdomain of example.com
+type originSet struct {
+	raw      string
+	any      bool
+	exact    map[string]bool
+	wildcard []string
+}
+
+var lastOrigins atomic.Value
+
+// parseOrigins parses the comma separated list of origins, reusing the last parsed set if the list
+// didn't change
+func parseOrigins(list string) *originSet {
+	if set, ok := lastOrigins.Load().(*originSet); ok && set.raw == list {
+		return set
+	}
+	set := &originSet{raw: list, exact: make(map[string]bool)}
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.ToLower(strings.TrimRight(strings.TrimSpace(origin), "/"))
+		switch {
+		case origin == "":
+		case origin == "*":
+			set.any = true
+		case strings.Contains(origin, "://*."):
+			// keep the scheme and the leading dot: https://*.example.com => https://.example.com
+			set.wildcard = append(set.wildcard, strings.Replace(origin, "://*.", "://.", 1))
+		default:
+			set.exact[origin] = true
+		}
+	}
+	lastOrigins.Store(set)
+	return set
+}
+
+func (s *originSet) match(origin string) bool {
+	if s.any {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if s.exact[origin] {
+		return true
+	}
+	for _, w := range s.wildcard {
+		i := strings.Index(w, "://") + 3
+		scheme, suffix := w[:i], w[i:]
+		// there must be a non empty subdomain label in front of the suffix
+		if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, suffix) && len(origin) > len(scheme)+len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
 func cacheMiddleware(next HandlerFunc) HandlerFunc {
 	return func(ctx *Context) error {
 		return next(ctx)
 	}
 }
 
-// log http call in apache access log format
+// log http call in apache access log format (common log format followed by the request
+// duration and the trace id)
 func accessLogMiddleware(next HandlerFunc) HandlerFunc {
 	return func(ctx *Context) error {
 		req := ctx.Request
-		h := ctx.RemoteAddr() // the IP address of the client (remote host)
+		start := time.Now() // the time that the request was received
+
+		err := next(ctx)
+
+		duration := time.Since(start)
 		// u - the userID that requested the information
 		u := "-"
-		if ctx.Session != nil {
-			if ctx.Session.AccountID != nil {
-				u = fmt.Sprintf("%s", *ctx.Session.AccountID)
-			}
+		if ctx.Session != nil && ctx.Session.AccountID != nil {
+			u = *ctx.Session.AccountID
+		}
+		// b - the size of the object returned to the client
+		b := "-"
+		if ctx.Response.Size != 0 {
+			b = strconv.FormatInt(ctx.Response.Size, 10)
+		}
+		ti := ctx.Request.Header.Get(ctx.Server.Config.traceHeader()) // ti - the request trace id
+
+		if strings.EqualFold(ctx.Server.Config.AccessLogFormat, "json") {
+			log.WithFields(map[string]interface{}{
+				"time":     start.Format(time.RFC3339Nano),
+				"method":   req.Method,
+				"path":     req.URL.Path,
+				"query":    req.URL.RawQuery,
+				"proto":    req.Proto,
+				"status":   ctx.Response.Status,
+				"bytes":    ctx.Response.Size,
+				"duration": duration.Microseconds(),
+				"ip":       ctx.RemoteAddr(),
+				"user":     u,
+				"trace":    ti,
+			}).Info("request")
+			return err
 		}
-		t := time.Now().String()                               // the time that the request was received
-		r := req.Method + " " + req.URL.Path + " " + req.Proto // the client request line, ex: "GET /image.png HTTP/1.0"
-
-		err := next(ctx)
 
-		s := ctx.Response.Status                                    // the response status code
-		b := ctx.Response.Size                                      // the size of the object returned to the client
-		ti := ctx.Request.Header.Get(ctx.Server.Config.TraceHeader) // ti - the request trace id
+		if ti == "" {
+			ti = "-"
+		}
 
-		log.Infof("%s %s %s %s %d %d %s", h, u, t, r, s, b, ti)
+		var line strings.Builder
+		line.WriteString(ctx.RemoteAddr()) // the IP address of the client (remote host)
+		line.WriteString(" - ")
+		line.WriteString(u)
+		line.WriteString(start.Format(" [02/Jan/2006:15:04:05 -0700] \""))
+		line.WriteString(req.Method + " " + req.URL.RequestURI() + " " + req.Proto) // the client request line, ex: "GET /image.png HTTP/1.0"
+		line.WriteString("\" ")
+		line.WriteString(strconv.Itoa(ctx.Response.Status)) // the response status code
+		line.WriteString(" ")
+		line.WriteString(b)
+		line.WriteString(" ")
+		line.WriteString(strconv.FormatInt(duration.Microseconds(), 10)) // the time taken to serve the request in microseconds
+		line.WriteString(" ")
+		line.WriteString(ti)
+
+		log.Info(line.String())
 		return err
 	}
 }
@@ -243,7 +397,8 @@ func compressMiddleware(next HandlerFunc) HandlerFunc {
 			res = ctx.Response
 		)
 		disableCompression := req.Header.Get("X-No-Compression")
-		if ctx.Server.Config.UseCompression && disableCompression == "" {
+		// upgraded connections are not normal responses and can't be compressed
+		if ctx.Server.Config.UseCompression && disableCompression == "" && !websocket.IsWebSocketUpgrade(req) {
 			switch {
 			case strings.Contains(req.Header.Get(headerAcceptEncoding), "br"):
 				wr = brotli.NewWriter(res.Writer)
@@ -265,7 +420,8 @@ func compressMiddleware(next HandlerFunc) HandlerFunc {
 		// else use the default writer to send data uncompressed
 		err := next(ctx)
 
-		if wr != nil {
+		// the handler can disable the compression (ex: event streams)
+		if wr != nil && res.wr == wr {
 			if e := wr.Close(); e != nil {
 				log.Errorf("Error closing compressed stream: %s", err)
 			}
@@ -280,9 +436,9 @@ func compressMiddleware(next HandlerFunc) HandlerFunc {
 func traceMiddleware(next HandlerFunc) HandlerFunc {
 	return func(ctx *Context) error {
 		if ctx.Server.Config.EnableTracing {
-			headerName := ctx.Server.Config.TraceHeader
-			if headerName == "" {
-				headerName = headerXTrace
+			headerName := ctx.Server.Config.traceHeader()
+			if ctx.Server.Config.W3CTrace {
+				return w3cTrace(ctx, headerName, next)
 			}
 			traceID := ctx.Request.Header.Get(headerName) // search for a trace id on the trace header
 
@@ -296,9 +452,7 @@ func traceMiddleware(next HandlerFunc) HandlerFunc {
 			// if trace header is not required but it doesn't exit
 			// create one and append it to the request and response
 			if traceID == "" {
-				b := make([]byte, 12)
-				_, _ = rand.Read(b)
-				traceID = hex.EncodeToString(b)
+				traceID = randomHex(12)
 			}
 			ctx.Request.Header.Set(headerName, traceID)
 			ctx.Response.Header().Set(headerName, traceID)
@@ -307,16 +461,80 @@ func traceMiddleware(next HandlerFunc) HandlerFunc {
 	}
 }
 
+// w3cTrace propagates the trace context using the W3C traceparent header
+// https://www.w3.org/TR/trace-context/#traceparent-header
+// The trace id is also set on the trace header of the request so it's available to the handlers and logs
+func w3cTrace(ctx *Context, headerName string, next HandlerFunc) error {
+	traceID, flags, ok := parseTraceParent(ctx.Request.Header.Get(headerTraceParent))
+	if !ok {
+		if ctx.Server.Config.TraceRequired {
+			ctx.Response.WriteHeader(http.StatusBadRequest)
+			return errors.New("trace token required")
+		}
+		// the traces started by the server are sampled
+		traceID, flags = randomHex(16), "01"
+	}
+	// each server handling the request is a new span of the trace, keeping the flags of the caller
+	traceParent := "00-" + traceID + "-" + randomHex(8) + "-" + flags
+
+	ctx.Request.Header.Set(headerTraceParent, traceParent)
+	ctx.Request.Header.Set(headerName, traceID)
+	ctx.Response.Header().Set(headerTraceParent, traceParent)
+	ctx.Response.Header().Set(headerName, traceID)
+	return next(ctx)
+}
+
+// parseTraceParent validates a traceparent header value and returns its trace id and trace flags
+func parseTraceParent(value string) (traceID, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || parts[0] == "ff" {
+		return "", "", false
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || len(traceID) != 32 || len(parentID) != 16 || len(flags) != 2 {
+		return "", "", false
+	}
+	// version 00 doesn't allow additional fields
+	if version == "00" && len(parts) != 4 {
+		return "", "", false
+	}
+	for _, part := range []string{version, traceID, parentID, flags} {
+		if _, err := hex.DecodeString(part); err != nil || strings.ToLower(part) != part {
+			return "", "", false
+		}
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", false
+	}
+	return traceID, flags, true
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 func bruteForceMiddleware(next HandlerFunc) HandlerFunc {
 	return func(ctx *Context) error {
 		collector := security.GetCollector()
-		var res int64
-		if ctx.Session == nil {
-			res = collector.Add(ctx.RemoteAddr(), 1)
-		} else {
-			res = collector.Add(string(ctx.Session.ID), 1)
+		key := ctx.RemoteAddr()
+		// the limiter runs before authMiddleware so the session is looked up here
+		ctx.restoreSession()
+		if ctx.Session != nil {
+			key = string(ctx.Session.ID)
 		}
-		if res == 0 {
+		added := collector.Add(key, 1)
+		remaining, reset := collector.Peek(key)
+		setRateLimitHeaders(ctx, collector.Capacity(), remaining, reset)
+		if added == 0 {
+			// round up so clients never retry before the bucket has room
+			wait := int64(math.Ceil(collector.RetryAfter(key).Seconds()))
+			if wait < 1 {
+				wait = 1
+			}
+			ctx.Response.Header().Set(headerRetryAfter, strconv.FormatInt(wait, 10))
 			ctx.Response.WriteHeader(http.StatusTooManyRequests)
 			return errors.New("to many requests")
 		}
@@ -324,16 +542,42 @@ func bruteForceMiddleware(next HandlerFunc) HandlerFunc {
 	}
 }
 
+// setRateLimitHeaders tells the client the capacity of its bucket, the requests it can still make and
+// the unix time when the bucket will be empty
+func setRateLimitHeaders(ctx *Context, limit, remaining int64, reset time.Time) {
+	h := ctx.Response.Header()
+	h.Set(headerRateLimitLimit, strconv.FormatInt(limit, 10))
+	h.Set(headerRateLimitRemaining, strconv.FormatInt(remaining, 10))
+	h.Set(headerRateLimitReset, strconv.FormatInt(int64(math.Ceil(float64(reset.UnixNano())/float64(time.Second))), 10))
+}
+
+// monitoringMiddleware reports the request telemetry to the metrics collector when the metrics are enabled
 // gauge: active requests beeing processed
-// counter: error counts
 // counter: status responses counts (400, 404, 500, 200, etc)
 // histogram: response time
 // histogram: response size
 func monitoringMiddleware(next HandlerFunc) HandlerFunc {
 	return func(ctx *Context) error {
-		// increment request count
-		res := next(ctx)
-		// decrement request count
-		return res
+		m := metrics
+		if cfg := ctx.Server.Config.Metrics; m == nil || cfg == nil || !cfg.Enabled {
+			return next(ctx)
+		}
+		start := time.Now()
+		m.IncActive()
+		defer m.DecActive()
+
+		err := next(ctx)
+
+		status := ctx.Response.Status
+		if err != nil && !ctx.Response.Committed {
+			// the error will be sent by the server as a 500 response
+			status = http.StatusInternalServerError
+		} else if status == 0 {
+			status = http.StatusOK
+		}
+		m.ObserveStatus(status)
+		m.ObserveLatency(time.Since(start))
+		m.ObserveSize(ctx.Response.Size)
+		return err
 	}
 }
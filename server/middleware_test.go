@@ -0,0 +1,363 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/najibulloShapoatov/server-core/monitoring/log"
+	"github.com/najibulloShapoatov/server-core/server/security"
+	"github.com/najibulloShapoatov/server-core/server/session"
+)
+
+// sessionContext initializes the memory session store and returns a request context using it
+func sessionContext(t *testing.T, method, target string) (*Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	cfg := &session.Config{
+		Store:      "mem",
+		Enabled:    true,
+		UseCookie:  true,
+		CookieName: "_session",
+		HeaderName: "X-Session-Id",
+		TTL:        time.Hour,
+	}
+	if err := session.Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	ctx, rec := testContext(method, target)
+	ctx.Server.Config.Session = cfg
+	return ctx, rec
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		idle       time.Duration
+		persistent bool
+		header     bool
+		restored   bool
+	}{
+		{"active session from cookie", time.Minute, false, false, true},
+		{"active session from header", time.Minute, false, true, true},
+		{"expired session", time.Hour * 2, false, false, false},
+		{"expired persistent session", time.Hour * 2, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := sessionContext(t, http.MethodGet, "/")
+			s := session.New(ctx.Request)
+			s.Persistent = tt.persistent
+			s.LastActivity = time.Now().Add(-tt.idle)
+			if err := s.Save(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.header {
+				ctx.Request.Header.Set("X-Session-Id", string(s.ID))
+			} else {
+				ctx.Request.AddCookie(&http.Cookie{Name: "_session", Value: string(s.ID)})
+			}
+
+			var got *session.Session
+			err := authMiddleware(func(ctx *Context) error {
+				got = ctx.Session
+				return nil
+			})(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got != nil) != tt.restored {
+				t.Fatalf("expected restored %v, got %v", tt.restored, got != nil)
+			}
+			if got != nil && time.Since(got.LastActivity) > time.Second {
+				t.Error("the activity of the restored session was not updated")
+			}
+			if !tt.restored && session.Restore(s.ID) != nil {
+				t.Error("the expired session was not removed")
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareSavesDeferredChanges(t *testing.T) {
+	ctx, _ := sessionContext(t, http.MethodGet, "/")
+	s := session.New(ctx.Request)
+	ctx.Request.AddCookie(&http.Cookie{Name: "_session", Value: string(s.ID)})
+
+	err := authMiddleware(func(ctx *Context) error {
+		ctx.Session.Defer()
+		ctx.Session.SetData("key", "value")
+		if session.Restore(ctx.Session.ID).GetData("key") != nil {
+			t.Error("deferred change written before the end of the request")
+		}
+		return nil
+	})(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := session.Restore(s.ID).GetData("key"); got != "value" {
+		t.Errorf("expected the deferred change to be saved, got %v", got)
+	}
+}
+
+func TestW3CTrace(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name        string
+		traceParent string
+		traceID     string
+		flags       string
+	}{
+		{"sampled", "00-" + traceID + "-00f067aa0ba902b7-01", traceID, "01"},
+		{"not sampled", "00-" + traceID + "-00f067aa0ba902b7-00", traceID, "00"},
+		{"future version", "01-" + traceID + "-00f067aa0ba902b7-03-extra", traceID, "03"},
+		{"invalid", "00-" + traceID + "-0000000000000000-01", "", "01"},
+		{"missing", "", "", "01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, rec := testContext(http.MethodGet, "/")
+			ctx.Server.Config.EnableTracing = true
+			ctx.Server.Config.W3CTrace = true
+			if tt.traceParent != "" {
+				ctx.Request.Header.Set(headerTraceParent, tt.traceParent)
+			}
+			if err := traceMiddleware(func(ctx *Context) error { return nil })(ctx); err != nil {
+				t.Fatal(err)
+			}
+			parts := strings.Split(rec.Header().Get(headerTraceParent), "-")
+			if len(parts) != 4 || parts[0] != "00" {
+				t.Fatalf("invalid traceparent %q", rec.Header().Get(headerTraceParent))
+			}
+			if tt.traceID != "" && parts[1] != tt.traceID {
+				t.Errorf("expected trace id %s, got %s", tt.traceID, parts[1])
+			}
+			if parts[3] != tt.flags {
+				t.Errorf("expected flags %s, got %s", tt.flags, parts[3])
+			}
+			if rec.Header().Get("X-Trace-Id") != parts[1] {
+				t.Errorf("expected the trace id on the trace header, got %q", rec.Header().Get("X-Trace-Id"))
+			}
+		})
+	}
+}
+
+func TestTraceHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		header     string
+	}{
+		{"configured", "X-Request-Id", "X-Request-Id"},
+		{"default", "", headerXTrace},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, rec := testContext(http.MethodGet, "/")
+			ctx.Server.Config.TraceHeader = tt.configured
+			ctx.Server.Config.EnableTracing = true
+			var logger *log.Logger
+			var res errorResponse
+			err := traceMiddleware(func(ctx *Context) error {
+				logger = ctx.Log()
+				res = newErrorResponse(ctx, http.ErrAbortHandler)
+				return nil
+			})(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			traceID := rec.Header().Get(tt.header)
+			if traceID == "" {
+				t.Fatalf("expected a trace id on %s", tt.header)
+			}
+			if want := log.NewLogger(map[string]interface{}{"trace": traceID}); !reflect.DeepEqual(logger, want) {
+				t.Errorf("expected the request logger to carry the trace id %s", traceID)
+			}
+			if res.RequestID != traceID {
+				t.Errorf("expected the request id %s, got %s", traceID, res.RequestID)
+			}
+		})
+	}
+}
+
+func TestBruteForceRetryAfter(t *testing.T) {
+	security.NewCollector(0.5, 2)
+	defer security.NewCollector(100, 10000)
+
+	tests := []struct {
+		status     int
+		retryAfter string
+	}{
+		{http.StatusOK, ""},
+		{http.StatusOK, ""},
+		// one token drains every 2 seconds
+		{http.StatusTooManyRequests, "2"},
+	}
+	for i, tt := range tests {
+		ctx, rec := testContext(http.MethodGet, "/")
+		ctx.Request.RemoteAddr = "10.0.0.1:1000"
+		_ = bruteForceMiddleware(func(ctx *Context) error {
+			ctx.Response.WriteHeader(http.StatusOK)
+			return nil
+		})(ctx)
+		if rec.Code != tt.status {
+			t.Errorf("request %d: expected status %d, got %d", i, tt.status, rec.Code)
+		}
+		if got := rec.Header().Get(headerRetryAfter); got != tt.retryAfter {
+			t.Errorf("request %d: expected Retry-After %q, got %q", i, tt.retryAfter, got)
+		}
+	}
+}
+
+func TestBruteForceSessionKey(t *testing.T) {
+	security.NewCollector(0.001, 1)
+	defer security.NewCollector(100, 10000)
+
+	base, _ := sessionContext(t, http.MethodGet, "/")
+	first, second := session.New(base.Request), session.New(base.Request)
+	for _, s := range []*session.Session{first, second} {
+		if err := s.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		session *session.Session
+		status  int
+	}{
+		{"first session", first, http.StatusOK},
+		// users behind the same address do not share a bucket
+		{"second session", second, http.StatusOK},
+		{"first session again", first, http.StatusTooManyRequests},
+		{"no session", nil, http.StatusOK},
+		{"no session again", nil, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, rec := testContext(http.MethodGet, "/")
+			ctx.Server.Config.Session = base.Server.Config.Session
+			ctx.Request.RemoteAddr = "10.0.0.1:1000"
+			if tt.session != nil {
+				ctx.Request.AddCookie(&http.Cookie{Name: "_session", Value: string(tt.session.ID)})
+			}
+			_ = bruteForceMiddleware(func(ctx *Context) error {
+				ctx.Response.WriteHeader(http.StatusOK)
+				return nil
+			})(ctx)
+			if rec.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestCheckCSRF(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		session   string
+		cookie    string
+		header    string
+		wantErr   bool
+		setCookie bool
+	}{
+		{"safe anonymous request issues the cookie", http.MethodGet, "", "", "", false, true},
+		{"safe anonymous request keeps the cookie", http.MethodGet, "", "token", "", false, false},
+		{"safe authenticated request", http.MethodGet, "token", "", "", false, false},
+		{"authenticated valid token", http.MethodPost, "token", "", "token", false, false},
+		{"authenticated invalid token", http.MethodPut, "token", "", "other", true, false},
+		{"authenticated missing token", http.MethodDelete, "token", "", "", true, false},
+		{"authenticated cookie token is not enough", http.MethodPost, "token", "other", "other", true, false},
+		{"anonymous valid token", http.MethodPost, "", "token", "token", false, false},
+		{"anonymous invalid token", http.MethodPatch, "", "token", "other", true, false},
+		{"anonymous without cookie", http.MethodPost, "", "", "token", true, false},
+		{"anonymous missing token", http.MethodPost, "", "token", "", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, rec := testContext(tt.method, "/")
+			if tt.session != "" {
+				ctx.Session = &session.Session{CSRFToken: tt.session}
+			}
+			if tt.cookie != "" {
+				ctx.Request.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				ctx.Request.Header.Set(headerXCSRF, tt.header)
+			}
+			if err := checkCSRF(ctx); (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			setCookie := strings.Contains(rec.Header().Get("Set-Cookie"), csrfCookieName+"=")
+			if setCookie != tt.setCookie {
+				t.Errorf("expected the CSRF cookie to be issued %v", tt.setCookie)
+			}
+		})
+	}
+}
+
+func TestCORSOrigin(t *testing.T) {
+	const allowed = "https://example.com, https://*.example.org, http://localhost:8080/"
+	tests := []struct {
+		name    string
+		allowed string
+		origin  string
+		want    string
+	}{
+		{"exact origin", allowed, "https://example.com", "https://example.com"},
+		{"origin case", allowed, "https://EXAMPLE.com", "https://EXAMPLE.com"},
+		{"trailing slash in the configuration", allowed, "http://localhost:8080", "http://localhost:8080"},
+		{"origin as prefix", allowed, "https://example.com.attacker.net", ""},
+		{"origin as substring", allowed, "https://example.co", ""},
+		{"other scheme", allowed, "http://example.com", ""},
+		{"other port", allowed, "http://localhost:9090", ""},
+		{"wildcard subdomain", allowed, "https://api.example.org", "https://api.example.org"},
+		{"wildcard nested subdomain", allowed, "https://a.b.example.org", "https://a.b.example.org"},
+		{"wildcard without subdomain", allowed, "https://example.org", ""},
+		{"wildcard suffix attack", allowed, "https://attackerexample.org", ""},
+		{"no origin", allowed, "", ""},
+		{"any origin", "*", "https://attacker.net", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, rec := testContext(http.MethodGet, "/")
+			ctx.Server.Config.Security = &SecurityConfig{CORSOrigin: tt.allowed}
+			if tt.origin != "" {
+				ctx.Request.Header.Set("Origin", tt.origin)
+			}
+			_ = postSecurityMiddleware(func(ctx *Context) error { return nil })(ctx)
+			if got := rec.Header().Get(headerCORSOrigin); got != tt.want {
+				t.Errorf("expected allowed origin %q, got %q", tt.want, got)
+			}
+			if tt.allowed != "*" && rec.Header().Get(headerVary) != "Origin" {
+				t.Error("expected the response to vary by origin")
+			}
+		})
+	}
+}
+
+func TestRecoverCompressedPanic(t *testing.T) {
+	for _, encoding := range []string{"br", "gzip", "deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			ctx, rec := testContext(http.MethodGet, "/")
+			ctx.Server.Config.UseCompression = true
+			ctx.Request.Header.Set(headerAcceptEncoding, encoding)
+			ctx.Request.Header.Set("Accept", "application/json")
+			err := recoverMiddleware(compressMiddleware(func(ctx *Context) error { panic("boom") }))(ctx)
+			if _, ok := err.(sentError); !ok {
+				t.Errorf("expected a sentError, got %v", err)
+			}
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+			}
+			if got := rec.Header().Get(headerContentEncoding); got != "" {
+				t.Errorf("expected an uncompressed error, got Content-Encoding %q", got)
+			}
+			if !strings.Contains(rec.Body.String(), `"error":"boom"`) {
+				t.Errorf("expected the error in the body, got %q", rec.Body.String())
+			}
+		})
+	}
+}
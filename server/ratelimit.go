@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/najibulloShapoatov/server-core/server/security"
+)
+
+// RateLimit is a leaky bucket limit applied to the requests of a route
+type RateLimit struct {
+	// Rate at which the bucket leaks, in requests per second
+	Rate float64
+	// Capacity of the bucket, the number of requests allowed in a burst
+	Capacity int64
+	// ByAccount keys the limit by the account of authenticated users instead of the client IP
+	ByAccount bool
+}
+
+var (
+	rateLimits     = make(map[string]RateLimit)
+	rateLimitPaths []string
+	rateLimitLock  sync.RWMutex
+)
+
+// SetRateLimit limits the requests made to the given path and the routes below it. Every route gets
+// its own buckets keyed by client IP or account, so 10 login attempts per minute can be configured as
+//
+//	server.SetRateLimit("/api/auth/login", server.RateLimit{Rate: 10.0 / 60, Capacity: 10})
+//
+// When several paths match a request the longest one is used
+func SetRateLimit(path string, limit RateLimit) {
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+	if _, ok := rateLimits[path]; !ok {
+		rateLimitPaths = append(rateLimitPaths, path)
+		// keep the longest paths first so they are matched before their prefixes
+		sort.Slice(rateLimitPaths, func(i, j int) bool {
+			return len(rateLimitPaths[i]) > len(rateLimitPaths[j])
+		})
+	}
+	rateLimits[path] = limit
+	security.GetCollector().AddGroup(path, limit.Rate, limit.Capacity)
+}
+
+// registerRateLimits adds the rate limit groups to the current collector
+func registerRateLimits() {
+	rateLimitLock.RLock()
+	defer rateLimitLock.RUnlock()
+	collector := security.GetCollector()
+	for path, limit := range rateLimits {
+		collector.AddGroup(path, limit.Rate, limit.Capacity)
+	}
+}
+
+func matchRateLimit(path string) (string, RateLimit, bool) {
+	rateLimitLock.RLock()
+	defer rateLimitLock.RUnlock()
+	for _, p := range rateLimitPaths {
+		// only whole path segments match, /api/auth/login does not limit /api/auth/loginx
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return p, rateLimits[p], true
+		}
+	}
+	return "", RateLimit{}, false
+}
+
+// rateLimitMiddleware applies the rate limit of the route to the request
+func rateLimitMiddleware(next HandlerFunc) HandlerFunc {
+	return func(ctx *Context) error {
+		route, limit, ok := matchRateLimit(ctx.Request.URL.Path)
+		if !ok {
+			return next(ctx)
+		}
+
+		key := "ip:" + ctx.RemoteAddr()
+		if limit.ByAccount {
+			// the limiter runs before authMiddleware so the session is looked up here
+			ctx.restoreSession()
+			if ctx.Session != nil && ctx.Session.AccountID != nil && *ctx.Session.AccountID != "" {
+				key = "account:" + *ctx.Session.AccountID
+			}
+		}
+
+		collector := security.GetCollector()
+		added := collector.AddInGroup(route, key, 1)
+		remaining, reset := collector.PeekInGroup(route, key)
+		setRateLimitHeaders(ctx, collector.GroupCapacity(route), remaining, reset)
+		if added == 0 {
+			wait := int64(math.Ceil(collector.RetryAfterInGroup(route, key).Seconds()))
+			if wait < 1 {
+				wait = 1
+			}
+			ctx.Response.Header().Set(headerRetryAfter, strconv.FormatInt(wait, 10))
+			ctx.Response.WriteHeader(http.StatusTooManyRequests)
+			return errors.New("to many requests")
+		}
+		return next(ctx)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/najibulloShapoatov/server-core/server/session"
+)
+
+func TestRateLimitByAccount(t *testing.T) {
+	SetRateLimit("/limited", RateLimit{Rate: 0.001, Capacity: 1, ByAccount: true})
+
+	newSession := func(t *testing.T, ctx *Context, accountID string) *session.Session {
+		s := session.New(ctx.Request)
+		s.AccountID = &accountID
+		if err := s.Save(); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	base, _ := sessionContext(t, http.MethodGet, "/")
+	request := func(t *testing.T, remoteAddr string, s *session.Session) int {
+		ctx, rec := testContext(http.MethodGet, "/limited/resource")
+		ctx.Server.Config.Session = base.Server.Config.Session
+		ctx.Request.RemoteAddr = remoteAddr
+		if s != nil {
+			ctx.Request.AddCookie(&http.Cookie{Name: "_session", Value: string(s.ID)})
+		}
+		_ = rateLimitMiddleware(authMiddleware(func(ctx *Context) error {
+			ctx.Response.WriteHeader(http.StatusOK)
+			return nil
+		}))(ctx)
+		return rec.Code
+	}
+
+	first := newSession(t, base, "account-1")
+	second := newSession(t, base, "account-2")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		session    *session.Session
+		status     int
+	}{
+		{"first request of the account", "10.0.0.1:1000", first, http.StatusOK},
+		{"same account from another address", "10.0.0.2:1000", first, http.StatusTooManyRequests},
+		{"another account from the same address", "10.0.0.1:1000", second, http.StatusOK},
+		{"anonymous request keyed by address", "10.0.0.3:1000", nil, http.StatusOK},
+		{"anonymous request from the same address", "10.0.0.3:1000", nil, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if status := request(t, tt.remoteAddr, tt.session); status != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, status)
+		}
+	}
+}
+
+func TestMatchRateLimit(t *testing.T) {
+	SetRateLimit("/api/auth/login", RateLimit{Rate: 1, Capacity: 1})
+	SetRateLimit("/api/auth", RateLimit{Rate: 1, Capacity: 1})
+	SetRateLimit("/files/", RateLimit{Rate: 1, Capacity: 1})
+
+	tests := []struct {
+		path  string
+		route string
+	}{
+		{"/api/auth/login", "/api/auth/login"},
+		{"/api/auth/login/", "/api/auth/login"},
+		{"/api/auth/login/otp", "/api/auth/login"},
+		{"/api/auth/loginx", "/api/auth"},
+		{"/api/auth/logout", "/api/auth"},
+		{"/api/authx", ""},
+		{"/files", ""},
+		{"/files/report.pdf", "/files/"},
+		{"/filesx", ""},
+	}
+	for _, tt := range tests {
+		route, _, ok := matchRateLimit(tt.path)
+		if ok != (tt.route != "") || route != tt.route {
+			t.Errorf("%s: expected route %q, got %q", tt.path, tt.route, route)
+		}
+	}
+}
@@ -2,13 +2,14 @@ package server
 
 import (
 	"encoding/xml"
-	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/najibulloShapoatov/server-core/monitoring/log"
 	"github.com/najibulloShapoatov/server-core/platform"
 	"github.com/najibulloShapoatov/server-core/utils"
 	"github.com/najibulloShapoatov/server-core/utils/reflection"
@@ -16,12 +17,76 @@ import (
 
 var routes = map[string]map[string]handler{}
 
-// Register all services handlers
+// routes registered with an explicit method and path. They take precedence over the
+// convention based routes registered through RegisterRoute
+var explicitRoutes []explicitRoute
+
+type explicitRoute struct {
+	// GET, POST, PUT, PATCH, DELETE
+	Method string
+	// Path of the route where parameters are defined as {name}
+	Path string
+	// Handler called when the route matches
+	Handler HandlerFunc
+	// path split by /
+	parts []string
+}
+
+// RegisterHandler registers a handler for the given HTTP method and path. Path parameters can be defined
+// as {name} and retrieved in the handler using Context.Param, ex: /v2/users/{id}/activate.
+// Explicit routes are matched in the order they were registered and before any route registered
+// using RegisterRoute. Registering the same method and path again replaces the previous handler
+func RegisterHandler(method, path string, fn HandlerFunc) {
+	route := explicitRoute{
+		Method:  strings.ToUpper(method),
+		Path:    path,
+		Handler: fn,
+		parts:   strings.Split(strings.Trim(path, "/"), "/"),
+	}
+	for i, r := range explicitRoutes {
+		if r.Method == route.Method && r.Path == route.Path {
+			explicitRoutes[i] = route
+			return
+		}
+	}
+	explicitRoutes = append(explicitRoutes, route)
+}
+
+// match checks if the path matches the route and returns the path parameters
+func (r *explicitRoute) match(path string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != len(r.parts) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, part := range r.parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			params[part[1:len(part)-1]] = parts[i]
+		} else if part != parts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// Register all services handlers. Methods that don't have the handler signature are skipped and
+// logged at debug level
 func RegisterRoute(module platform.Module) error {
+	return registerRoute(module, false)
+}
+
+// RegisterRouteStrict registers the service handlers like RegisterRoute but fails if a method looks
+// like a handler, because it takes a *Context or returns (..., int, error), without having the
+// handler signature
+func RegisterRouteStrict(module platform.Module) error {
+	return registerRoute(module, true)
+}
+
+func registerRoute(module platform.Module, strict bool) error {
 	if _, ok := module.(platform.Service); ok {
 		// Register the service name
 		serviceName := strings.ToLower(module.ID()) + "-" + strings.ToLower(module.Version())
-		handlers, err := analyze(module)
+		handlers, err := analyze(module, strict)
 		if err != nil {
 			return err
 		}
@@ -32,6 +97,19 @@ func RegisterRoute(module platform.Module) error {
 	return nil
 }
 
+// ParamNamer is implemented by the modules that name the parameters of their handlers. Go doesn't keep the
+// parameter names so they are needed to bind form fields and files to the parameters that aren't structures.
+// The names are keyed by method name and given for all the parameters after the context
+//
+//	func (s *Service) ParamNames() map[string][]string {
+//		return map[string][]string{
+//			"AddAvatar": {"userId", "avatar"},
+//		}
+//	}
+type ParamNamer interface {
+	ParamNames() map[string][]string
+}
+
 type handler struct {
 	// trimmed method name with lowercase
 	Name string
@@ -43,30 +121,35 @@ type handler struct {
 	RestEndpoint string
 	// reference to function and reflection
 	FuncRef *reflection.Method
+	// FromQuery is set for GET and DELETE handlers whose structure parameters are decoded from the query string
+	FromQuery bool
+	// ParamNames are the names of the parameters after the context given by the module ParamNamer
+	ParamNames []string
+	// method requested by the handler name before being changed to POST because of its parameters
+	queryMethod string
 }
 
-func analyze(module platform.Module) (map[string]handler, error) {
+var (
+	contextType = reflect.TypeOf((*Context)(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+func analyze(module platform.Module, strict bool) (map[string]handler, error) {
 
 	res := map[string]handler{}
 	m := reflection.New(module)
-	errInterf := reflect.TypeOf((*error)(nil)).Elem()
+	var paramNames map[string][]string
+	if namer, ok := module.(ParamNamer); ok {
+		paramNames = namer.ParamNames()
+	}
 
-	var ctx *Context
 	// Iterate all methods and look for the ones of the form xxx(*Context.....
 	for _, method := range m.Methods() {
-		numOut := method.NumOut()
-
-		// Check input params to be at least 1 and the first to be of type *server.Context
-		if method.NumIn() < 2 || method.In(0).AssignableTo(reflect.TypeOf(ctx)) {
-			continue
-		}
-
-		// Check output params to be at least 2 and of type (int, error)
-		if numOut < 2 {
-			continue
-		}
-		// Check return types. Last return type to be of type error or the penultimate to be of type int
-		if !method.Out(numOut-1).Implements(errInterf) || method.Out(numOut-2).Kind() != reflect.Int {
+		if reason := signatureMismatch(method); reason != "" {
+			log.Debugf("%s: method %s is not a handler: %s", module.ID(), method.Name, reason)
+			if strict && looksLikeHandler(method) {
+				return nil, fmt.Errorf("method %s is not a valid handler: %s", method.Name, reason)
+			}
 			continue
 		}
 
@@ -74,6 +157,13 @@ func analyze(module platform.Module) (map[string]handler, error) {
 			Module:  module,
 			FuncRef: method,
 		}
+		if names, ok := paramNames[method.Name]; ok {
+			if len(names) != method.NumIn()-2 {
+				return nil, fmt.Errorf("method %s takes %d parameters but %d names are given",
+					method.Name, method.NumIn()-2, len(names))
+			}
+			h.ParamNames = names
+		}
 
 		switch {
 		case strings.HasPrefix(method.Name, "Get"):
@@ -109,14 +199,57 @@ func analyze(module platform.Module) (map[string]handler, error) {
 
 		// Register as method name and lowercase
 		res[key] = h
+
+		// GET and DELETE handlers that take only structures are served as POST but can also be
+		// called with the structure fields in the query string
+		if h.queryMethod != "" {
+			q := h
+			q.HTTPMethod = h.queryMethod
+			q.FromQuery = true
+			if _, exists := res[q.HTTPMethod+q.Name]; !exists {
+				res[q.HTTPMethod+q.Name] = q
+			}
+		}
 	}
 
 	return res, nil
 }
 
+// signatureMismatch returns why the method doesn't have the handler signature
+// xxx(*Context, ...) (..., int, error), or an empty string if it does
+func signatureMismatch(method *reflection.Method) string {
+	// the receiver is the first input parameter
+	if method.NumIn() < 2 || method.In(1) != contextType {
+		return "the first parameter must be *server.Context"
+	}
+	numOut := method.NumOut()
+	if numOut < 2 {
+		return "it must return at least (int, error)"
+	}
+	// Check return types. Last return type to be of type error and the penultimate to be of type int
+	if !method.Out(numOut-1).Implements(errorType) || method.Out(numOut-2).Kind() != reflect.Int {
+		return "the last return values must be (int, error)"
+	}
+	return ""
+}
+
+// looksLikeHandler returns true if the method has a part of the handler signature
+func looksLikeHandler(method *reflection.Method) bool {
+	for i := 1; i < method.NumIn(); i++ {
+		if method.In(i) == contextType {
+			return true
+		}
+	}
+	numOut := method.NumOut()
+	return numOut >= 2 && method.Out(numOut-1).Implements(errorType) && method.Out(numOut-2).Kind() == reflect.Int
+}
+
 func (h *handler) do(httpMethod string, prefixes []string) {
 	// If method name Prefix is `Do` and total inbound parameters > 1 it's a POST request
 	h.HTTPMethod = httpMethod
+	if (httpMethod == http.MethodGet || httpMethod == http.MethodDelete) && onlyStructParams(h.FuncRef) {
+		h.queryMethod = httpMethod
+	}
 	args := make([]string, 0)
 	if h.FuncRef.NumIn() > 2 && (h.HTTPMethod == http.MethodGet || h.HTTPMethod == http.MethodDelete) {
 		for i := 2; i <= h.FuncRef.NumIn()-1; i++ {
@@ -139,14 +272,26 @@ func (h *handler) do(httpMethod string, prefixes []string) {
 	}
 }
 
-func (h *handler) Handler(ctx *Context) (err error) {
-	defer func() {
-		e := recover()
-		if e != nil {
-			err = errors.New("bad request")
-			return
+// onlyStructParams returns true if the method has parameters after the context and all of them are structures
+func onlyStructParams(m *reflection.Method) bool {
+	if m.NumIn() <= 2 {
+		return false
+	}
+	for i := 2; i < m.NumIn(); i++ {
+		typ := m.In(i)
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			return false
 		}
-	}()
+	}
+	return true
+}
+
+// Handler decodes the request, calls the module method and encodes its results. Panics are not
+// recovered here so recoverMiddleware can log them and send a 500 response
+func (h *handler) Handler(ctx *Context) (err error) {
 	var inParams = make([]reflect.Value, 0)
 
 	inParams = append(inParams, reflect.ValueOf(h.Module))
@@ -190,7 +335,17 @@ func (h *handler) Handler(ctx *Context) (err error) {
 
 	// determine whatever in params we can
 	// and call IN decoders
-	if ctx.Request.ContentLength != 0 {
+	var args []interface{}
+	if h.FromQuery && ctx.Request.ContentLength == 0 {
+		args, err = queryInputDecoder(ctx, h)
+		if err != nil {
+			ctx.BadRequest(fmt.Errorf("failed to parse input: %s", err))
+			return nil
+		}
+		for _, x := range args {
+			inParams = append(inParams, reflect.ValueOf(x))
+		}
+	} else if ctx.Request.ContentLength != 0 {
 		contentType := ctx.Request.Header.Get("Content-Type")
 		if strings.Contains(contentType, ";") {
 			contentType = strings.TrimSpace(strings.Split(contentType, ";")[0])
@@ -200,7 +355,7 @@ func (h *handler) Handler(ctx *Context) (err error) {
 			ctx.BadRequest(fmt.Errorf("invalid input format"))
 			return nil
 		}
-		args, err := parser(ctx, h)
+		args, err = parser(ctx, h)
 		if err != nil {
 			ctx.BadRequest(fmt.Errorf("failed to parse input: %s", err))
 			return nil
@@ -211,46 +366,16 @@ func (h *handler) Handler(ctx *Context) (err error) {
 		}
 	}
 
-	outParams := h.FuncRef.Call(inParams...)
+	// the uploaded files are closed even when the handler panics
+	defer closeFiles(args)
+	outParams := h.FuncRef.Invoke(inParams...)
 
-	var outEncoder OutputFunc
-	acceptEncoding := ctx.Request.Header.Get("Accept")
-	acceptedEncodings := make([]string, 0)
 	outContentType := ctx.Response.Header().Get("Content-Type")
 	contentTypeSent := outContentType != ""
 
-	if strings.Contains(acceptEncoding, ";") {
-		acceptedEncodings = strings.Split(acceptEncoding, ";")
-	} else {
-		acceptedEncodings = append(acceptedEncodings, acceptEncoding)
-	}
-
-	for _, encoding := range acceptedEncodings {
-		encoding = strings.TrimSpace(encoding)
-		if strings.Contains(encoding, ";") {
-			encoding = strings.TrimSpace(strings.Split(encoding, ";")[0])
-		}
-		if encoding == "*/*" {
-			outEncoder = outputEncoder["application/json"]
-			if outContentType == "" {
-				outContentType = "application/json"
-			}
-			break
-		} else {
-			var ok bool
-			if outEncoder, ok = outputEncoder[encoding]; ok {
-				if outContentType == "" {
-					outContentType = encoding
-				}
-				break
-			}
-		}
-	}
-	if outEncoder == nil {
-		outEncoder = outputEncoder["application/json"]
-		if outContentType == "" {
-			outContentType = "application/json"
-		}
+	outEncoder, encoding := negotiate(ctx.Request.Header.Get("Accept"))
+	if outContentType == "" {
+		outContentType = encoding
 	}
 
 	if !contentTypeSent {
@@ -263,21 +388,20 @@ func (h *handler) Handler(ctx *Context) (err error) {
 
 	// Handler returned an error
 	if err, ok := outParams[len(outParams)-1].(error); ok && err != nil {
-		data, _ := outEncoder(ctx, struct {
-			XMLName   xml.Name `xml:"error" json:"-" struct:"-"`
-			Error     string   `json:"error" xml:"message,attr" struct:"[64]byte"`
-			RequestID string   `json:"requestId" xml:"request-id,attr" struct:"[128]byte"`
-		}{
-			Error:     err.Error(),
-			RequestID: ctx.Request.Header.Get(ctx.Server.Config.TraceHeader),
-		},
-		)
+		data, _ := outEncoder(ctx, newErrorResponse(ctx, err))
 
 		_, err = ctx.Response.Write(data)
 		return err
 	}
 
 	// call OUT encoder
+	if stream, ok := streamEncoder[encoding]; ok && len(outParams) > 2 {
+		// the status is already sent so the errors can only be logged
+		if err := stream(ctx, ctx.Response, outParams[:len(outParams)-2]...); err != nil {
+			ctx.Log().Errorf("streaming the response failed: %s", err)
+		}
+		return nil
+	}
 	if len(outParams) > 2 {
 		data, err := outEncoder(ctx, outParams[:len(outParams)-2]...)
 		if err != nil {
@@ -290,6 +414,140 @@ func (h *handler) Handler(ctx *Context) (err error) {
 	return err
 }
 
+// errorResponse is the body sent to the client when a handler returns an error
+type errorResponse struct {
+	XMLName   xml.Name `xml:"error" json:"-" struct:"-" csv:"-"`
+	Error     string   `json:"error" xml:"message,attr" struct:"[64]byte"`
+	RequestID string   `json:"requestId" xml:"request-id,attr" struct:"[128]byte"`
+}
+
+func newErrorResponse(ctx *Context, err error) errorResponse {
+	return errorResponse{
+		Error:     err.Error(),
+		RequestID: ctx.Request.Header.Get(ctx.Server.Config.traceHeader()),
+	}
+}
+
+type mediaRange struct {
+	mime string
+	q    float64
+}
+
+// negotiate picks the output encoder for the media ranges listed in the Accept header, ordered
+// by their quality values. If no registered encoder matches, JSON is used
+func negotiate(accept string) (OutputFunc, string) {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		r := mediaRange{mime: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if r.mime == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+		if r.q > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	for _, r := range ranges {
+		switch {
+		case r.mime == "*/*", r.mime == "application/*":
+			return outputEncoder["application/json"], "application/json"
+		case strings.HasSuffix(r.mime, "/*"):
+			if mime := matchEncoder(strings.TrimSuffix(r.mime, "*")); mime != "" {
+				enc, _ := encoderFor(mime)
+				return enc, mime
+			}
+		default:
+			if enc, ok := encoderFor(r.mime); ok {
+				return enc, r.mime
+			}
+		}
+	}
+	return outputEncoder["application/json"], "application/json"
+}
+
+// matchEncoder returns the JSON MIME type with the given prefix if registered, otherwise the first
+// registered MIME type with the prefix in alphabetical order
+func matchEncoder(prefix string) string {
+	if _, ok := encoderFor(prefix + "json"); ok {
+		return prefix + "json"
+	}
+	var res string
+	for mime := range outputEncoder {
+		if strings.HasPrefix(mime, prefix) && (res == "" || mime < res) {
+			res = mime
+		}
+	}
+	for mime := range streamEncoder {
+		if strings.HasPrefix(mime, prefix) && (res == "" || mime < res) {
+			res = mime
+		}
+	}
+	return res
+}
+
+// RouteInfo describes a registered route
+type RouteInfo struct {
+	// GET, POST, PUT, PATCH, DELETE
+	Method string
+	// Endpoint is the path of the route
+	Endpoint string
+	// Module is the id of the module handling the route. Empty for routes registered with RegisterHandler
+	Module string
+	// Version of the module handling the route
+	Version string
+	// Handler is the name of the method handling the route
+	Handler string
+	// Params contains the types of the handler parameters, excluding the context
+	Params []string
+	// Results contains the types returned by the handler, excluding the status code and error
+	Results []string
+}
+
+// Routes returns all the registered routes sorted by endpoint and method
+func Routes() []RouteInfo {
+	var res []RouteInfo
+	for _, service := range routes {
+		for _, h := range service {
+			info := RouteInfo{
+				Method:   h.HTTPMethod,
+				Endpoint: h.RestEndpoint,
+				Module:   h.Module.ID(),
+				Version:  h.Module.Version(),
+				Handler:  h.FuncRef.Name,
+			}
+			for i := 2; i < h.FuncRef.NumIn(); i++ {
+				info.Params = append(info.Params, h.FuncRef.In(i).String())
+			}
+			for i := 0; i < h.FuncRef.NumOut()-2; i++ {
+				info.Results = append(info.Results, h.FuncRef.Out(i).String())
+			}
+			res = append(res, info)
+		}
+	}
+	for _, r := range explicitRoutes {
+		res = append(res, RouteInfo{Method: r.Method, Endpoint: r.Path})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Endpoint == res[j].Endpoint {
+			return res[i].Method < res[j].Method
+		}
+		return res[i].Endpoint < res[j].Endpoint
+	})
+	return res
+}
+
 // Remove service handler
 func UnregisterRoute(name string) {
 	delete(routes, strings.ToLower(name))
@@ -300,4 +558,5 @@ func UnregisterRoutes() {
 	for route := range routes {
 		UnregisterRoute(route)
 	}
+	explicitRoutes = nil
 }
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testService struct{}
+
+func (testService) ID() string      { return "test" }
+func (testService) Version() string { return "v1" }
+func (testService) Start() error    { return nil }
+func (testService) Stop() error     { return nil }
+func (testService) Setup() error    { return nil }
+
+func (testService) GetPanic(ctx *Context) (int, error) {
+	panic(errors.New("boom"))
+}
+
+func (testService) GetHello(ctx *Context) (string, int, error) {
+	return "hello", http.StatusOK, nil
+}
+
+func testContext(method, target string) (*Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	ctx := newContext(rec, httptest.NewRequest(method, target, nil))
+	ctx.Server = &Server{Config: &Config{TraceHeader: "X-Trace-Id"}}
+	return ctx, rec
+}
+
+func TestHandlerPanicReachesRecoverMiddleware(t *testing.T) {
+	handlers, err := analyze(testService{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		key    string
+		status int
+		body   string
+	}{
+		{"panic", http.MethodGet + "panic", http.StatusInternalServerError, `{"error":"boom","requestId":""}`},
+		{"ok", http.MethodGet + "hello", http.StatusOK, `"hello"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, ok := handlers[tt.key]
+			if !ok {
+				t.Fatalf("handler %s not registered", tt.key)
+			}
+			ctx, rec := testContext(http.MethodGet, "/test/v1/"+h.Name)
+			err := recoverMiddleware(h.Handler)(ctx)
+			if tt.status == http.StatusInternalServerError {
+				if _, ok := err.(sentError); !ok {
+					t.Errorf("expected a sentError, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rec.Code)
+			}
+			var got, want interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &got)
+			_ = json.Unmarshal([]byte(tt.body), &want)
+			if !jsonEqual(got, want) {
+				t.Errorf("expected body %s, got %s", tt.body, rec.Body.String())
+			}
+		})
+	}
+}
+
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
@@ -9,7 +9,10 @@ import (
 type bucketMap map[string]*LeakyBucket
 type priorityQueue []*LeakyBucket
 
-var collector *Collector
+var (
+	collector     *Collector
+	collectorLock sync.Mutex
+)
 
 // A collector can keep track of multiple leaky buckets
 type Collector struct {
@@ -17,17 +20,31 @@ type Collector struct {
 	heap     priorityQueue
 	rate     float64
 	capacity int64
+	groups   map[string]limit
 	lock     sync.Mutex
 	quit     chan bool
+	stopOnce sync.Once
+}
+
+// limit of a named group of buckets
+type limit struct {
+	rate     float64
+	capacity int64
 }
 
-// Creates a new collector and check for empty buckets
+// Creates a new collector and check for empty buckets. The previous collector is stopped
 func NewCollector(rate float64, capacity int64) *Collector {
+	collectorLock.Lock()
+	defer collectorLock.Unlock()
+	if collector != nil {
+		collector.Stop()
+	}
 	collector = &Collector{
 		buckets:  make(bucketMap),
 		heap:     make(priorityQueue, 0, 4096),
 		rate:     rate,
 		capacity: capacity,
+		groups:   make(map[string]limit),
 		quit:     make(chan bool),
 	}
 	collector.periodicRemoveEmptyBuckets(time.Second)
@@ -37,10 +54,20 @@ func NewCollector(rate float64, capacity int64) *Collector {
 
 // Return the collector
 func GetCollector() *Collector {
-	if collector == nil {
-		collector = NewCollector(100, 10000)
+	collectorLock.Lock()
+	c := collector
+	collectorLock.Unlock()
+	if c == nil {
+		c = NewCollector(100, 10000)
 	}
-	return collector
+	return c
+}
+
+// Stop ends the periodic removal of the empty buckets. Stopping a stopped collector does nothing
+func (c *Collector) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.quit)
+	})
 }
 
 // Remove internal bucket associated with key
@@ -53,21 +80,53 @@ func (c *Collector) Remove(key string) {
 	c.lock.Unlock()
 }
 
+// Add the amount to the bucket associated with key and return the amount actually added, 0 if the
+// bucket is full
 func (c *Collector) Add(key string, amount int64) int64 {
+	return c.add(key, c.rate, c.capacity, amount)
+}
+
+// AddGroup registers a named group of buckets with its own rate and capacity, independent of the
+// collector ones
+func (c *Collector) AddGroup(name string, rate float64, capacity int64) {
+	c.lock.Lock()
+	c.groups[name] = limit{rate: rate, capacity: capacity}
+	c.lock.Unlock()
+}
+
+// AddInGroup works like Add for the bucket associated with key in the named group. Groups that
+// were not registered use the collector rate and capacity
+func (c *Collector) AddInGroup(group, key string, amount int64) int64 {
+	c.lock.Lock()
+	l, ok := c.groups[group]
+	c.lock.Unlock()
+	if !ok {
+		l = limit{rate: c.rate, capacity: c.capacity}
+	}
+	return c.add(groupKey(group, key), l.rate, l.capacity, amount)
+}
+
+// RetryAfterInGroup works like RetryAfter for the bucket associated with key in the named group
+func (c *Collector) RetryAfterInGroup(group, key string) time.Duration {
+	return c.RetryAfter(groupKey(group, key))
+}
+
+func (c *Collector) add(key string, rate float64, capacity int64, amount int64) int64 {
+	// the bucket and its place in the heap are read under the same lock by Peek and RetryAfter
 	c.lock.Lock()
+	defer c.lock.Unlock()
 	b, ok := c.buckets[key]
 	if !ok {
 		// Create a new bucket.
 		b = &LeakyBucket{
 			key:      key,
-			capacity: c.capacity,
-			rate:     c.rate,
+			capacity: capacity,
+			rate:     rate,
 			p:        time.Now(),
 		}
 		c.heap.Push(b)
 		c.buckets[key] = b
 	}
-	c.lock.Unlock()
 
 	n := b.Add(amount)
 	if n > 0 {
@@ -76,6 +135,59 @@ func (c *Collector) Add(key string, amount int64) int64 {
 	return n
 }
 
+func groupKey(group, key string) string {
+	return group + "\x00" + key
+}
+
+// RetryAfter returns the time left until the bucket associated with key can accept a new token
+func (c *Collector) RetryAfter(key string) time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if b, ok := c.buckets[key]; ok {
+		return b.RetryAfter()
+	}
+	return 0
+}
+
+// Peek returns the number of tokens the bucket associated with key can still accept and the time it
+// will be empty, without adding to the bucket
+func (c *Collector) Peek(key string) (remaining int64, resetAt time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if b, ok := c.buckets[key]; ok {
+		return b.Peek()
+	}
+	return c.capacity, time.Now()
+}
+
+// PeekInGroup works like Peek for the bucket associated with key in the named group
+func (c *Collector) PeekInGroup(group, key string) (remaining int64, resetAt time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if b, ok := c.buckets[groupKey(group, key)]; ok {
+		return b.Peek()
+	}
+	if l, ok := c.groups[group]; ok {
+		return l.capacity, time.Now()
+	}
+	return c.capacity, time.Now()
+}
+
+// Capacity returns the capacity of the buckets of the collector
+func (c *Collector) Capacity() int64 {
+	return c.capacity
+}
+
+// GroupCapacity returns the capacity of the buckets of the named group
+func (c *Collector) GroupCapacity(group string) int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if l, ok := c.groups[group]; ok {
+		return l.capacity
+	}
+	return c.capacity
+}
+
 // Remove all empty buckets in the collector.
 func (c *Collector) removeEmptyBuckets() {
 	c.lock.Lock()
@@ -0,0 +1,127 @@
+package security
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stopped returns true if the periodic removal of the collector was stopped
+func stopped(c *Collector) bool {
+	select {
+	case <-c.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestNewCollectorStopsPrevious(t *testing.T) {
+	first := NewCollector(1, 10)
+	second := NewCollector(1, 10)
+	defer NewCollector(100, 10000)
+
+	if !stopped(first) {
+		t.Error("expected the previous collector to be stopped")
+	}
+	if stopped(second) {
+		t.Error("expected the new collector to be running")
+	}
+	if GetCollector() != second {
+		t.Error("expected the new collector to be the global one")
+	}
+	second.Stop()
+	// stopping a stopped collector does nothing
+	second.Stop()
+	if !stopped(second) {
+		t.Error("expected the collector to be stopped")
+	}
+}
+
+func TestNewCollectorDoesNotLeak(t *testing.T) {
+	NewCollector(1, 10)
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		NewCollector(1, 10)
+	}
+	defer NewCollector(100, 10000)
+
+	deadline := time.Now().Add(time.Second * 5)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d goroutines, got %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestCollectorConcurrentAdd(t *testing.T) {
+	const capacity = 1000
+	c := NewCollector(0.001, capacity)
+	defer NewCollector(100, 10000)
+
+	var (
+		added int64
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				atomic.AddInt64(&added, c.Add("key", 1))
+				c.Peek("key")
+				c.RetryAfter("key")
+			}
+		}()
+	}
+	wg.Wait()
+	if added != capacity {
+		t.Errorf("expected the bucket to accept %d tokens, got %d", capacity, added)
+	}
+	if remaining, _ := c.Peek("key"); remaining != 0 {
+		t.Errorf("expected a full bucket, got %d remaining", remaining)
+	}
+}
+
+func TestCollectorConcurrentAddInGroup(t *testing.T) {
+	c := NewCollector(0.001, 1000)
+	defer NewCollector(100, 10000)
+	c.AddGroup("login", 0.001, 10)
+	c.AddGroup("account", 0.001, 50)
+
+	tests := []struct {
+		group string
+		key   string
+		want  int64
+	}{
+		{"login", "10.0.0.1", 10},
+		{"login", "10.0.0.2", 10},
+		{"account", "user", 50},
+		// groups that were not registered use the collector limits
+		{"other", "user", 1000},
+	}
+	added := make([]int64, len(tests))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				for k, tt := range tests {
+					atomic.AddInt64(&added[k], c.AddInGroup(tt.group, tt.key, 1))
+					c.PeekInGroup(tt.group, tt.key)
+					c.RetryAfterInGroup(tt.group, tt.key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for k, tt := range tests {
+		if added[k] != tt.want {
+			t.Errorf("%s/%s: expected the bucket to accept %d tokens, got %d", tt.group, tt.key, tt.want, added[k])
+		}
+	}
+}
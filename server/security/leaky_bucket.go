@@ -59,3 +59,31 @@ func (b *LeakyBucket) count() int64 {
 
 	return count
 }
+
+// RetryAfter returns the time left until the bucket drains enough to accept one more token
+func (b *LeakyBucket) RetryAfter() time.Duration {
+	now := time.Now()
+	if !now.Before(b.p) {
+		return 0
+	}
+	nsPerDrip := float64(time.Second) / b.rate
+	wait := time.Duration(float64(b.p.Sub(now)) - float64(b.capacity-1)*nsPerDrip)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Peek returns the number of tokens the bucket can still accept and the time it will be empty, without
+// adding to the bucket
+func (b *LeakyBucket) Peek() (remaining int64, resetAt time.Time) {
+	now := time.Now()
+	if !now.Before(b.p) {
+		return b.capacity, now
+	}
+	remaining = b.capacity - b.count()
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, b.p
+}
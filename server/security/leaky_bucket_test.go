@@ -0,0 +1,38 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeakyBucketRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		capacity int64
+		added    int64
+		want     time.Duration
+	}{
+		{"empty", 1, 3, 0, 0},
+		{"not full", 1, 3, 2, 0},
+		{"full", 1, 3, 3, time.Second},
+		{"full with a slow rate", 0.5, 2, 2, time.Second * 2},
+		{"full with a fast rate", 10, 5, 5, time.Millisecond * 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewLeakyBucket(tt.rate, tt.capacity)
+			if tt.added > 0 {
+				b.Add(tt.added)
+			}
+			got := b.RetryAfter()
+			// the bucket keeps draining while the test runs
+			if got > tt.want || got < tt.want-time.Millisecond*50 {
+				t.Errorf("expected about %s, got %s", tt.want, got)
+			}
+			if tt.want > 0 && b.Add(1) != 0 {
+				t.Error("expected the full bucket to reject the token")
+			}
+		})
+	}
+}
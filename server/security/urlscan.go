@@ -3,14 +3,21 @@ package security
 import (
 	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// defaultBanDuration is used when no ban duration is given
+const defaultBanDuration = time.Minute * 5
+
 var (
 	mu sync.RWMutex
 
-	// bannedIPs are IP's banned for scanning web exploits
+	// bannedIPs are IP's banned for scanning web exploits with the end of their ban
 	bannedIPs = make(map[string]time.Time)
+	// how often the expired bans are removed
+	sweepInterval = int64(time.Minute)
+	sweepOnce     sync.Once
 
 	// webExploits is a list of vulnerable url patterns
 	webExploits = []string{
@@ -40,57 +47,169 @@ var (
 		"Attach",
 		"Autonomy",
 	}
+
+	// compiled patterns, guarded by patternsLock
+	patternsLock      sync.RWMutex
+	exploitPatterns   = mustCompile(webExploits)
+	badUserAgents     = mustCompile(userAgents)
+	allowedUserAgents []*regexp.Regexp
 )
 
+// AddExploitPattern adds a regular expression matching the urls of web exploits. Clients requesting
+// a matching url are banned
+func AddExploitPattern(pattern string) error {
+	return addPattern(&exploitPatterns, pattern)
+}
+
+// AddBadUserAgent adds a regular expression matching the user agents of bad bots and scanners. Clients
+// with a matching user agent are banned
+func AddBadUserAgent(pattern string) error {
+	return addPattern(&badUserAgents, pattern)
+}
+
+// AllowUserAgent adds a regular expression matching the user agents of known good bots, which are never
+// banned for their user agent even if it matches a bad user agent pattern
+func AllowUserAgent(pattern string) error {
+	return addPattern(&allowedUserAgents, pattern)
+}
+
+func addPattern(list *[]*regexp.Regexp, pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	patternsLock.Lock()
+	*list = append(*list, re)
+	patternsLock.Unlock()
+	return nil
+}
+
+func mustCompile(patterns []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		res[i] = regexp.MustCompile(p)
+	}
+	return res
+}
+
 // IsCrawler detects crawlers/spiders/bots by user agent, ip and url
 func IsCrawler(url string, ip string, useragent string, banDuration time.Duration) bool {
 	if banDuration == 0 {
-		banDuration = time.Minute * 5
+		banDuration = defaultBanDuration
 	}
 	// check if ip is in ban time
-	if status, banTime := getBannedIP(ip); status && banTime.Add(banDuration).After(time.Now()) {
+	if banned, _ := getBannedIP(ip); banned {
 		return true
 	}
 
-	// check if UA is in list of penetration tools
-	if match := getMatch(useragent, userAgents); match {
-		SetBannedIP(ip)
+	patternsLock.RLock()
+	defer patternsLock.RUnlock()
+
+	// check if UA is in list of penetration tools, unless it is allowed
+	if getMatch(useragent, badUserAgents) && !getMatch(useragent, allowedUserAgents) {
+		BanIP(ip, banDuration)
 		return true
 	}
 
 	// check if requested url is in list of web exploits
-	if match := getMatch(url, webExploits); match {
-		SetBannedIP(ip)
+	if match := getMatch(url, exploitPatterns); match {
+		BanIP(ip, banDuration)
 		return true
 	}
 	return false
 }
 
-// geMatch is getter of UserAgent matched result
-func getMatch(str string, list []string) bool {
-	for _, check := range list {
-		if match, _ := regexp.MatchString(check, str); match {
+// geMatch returns true if str matches any of the patterns
+func getMatch(str string, list []*regexp.Regexp) bool {
+	for _, re := range list {
+		if re.MatchString(str) {
 			return true
 		}
 	}
 	return false
 }
 
-// getBannedIP checks if IP registered
+// getBannedIP returns true and the end of the ban if the IP is banned
 func getBannedIP(ip string) (bool, time.Time) {
 	mu.RLock()
-	defer mu.RUnlock()
+	until, ok := bannedIPs[ip]
+	mu.RUnlock()
 
-	if banTime, ok := bannedIPs[ip]; ok {
-		return true, banTime
+	if !ok {
+		return false, time.Time{}
 	}
-	return false, time.Time{}
+	if !until.After(time.Now()) {
+		// the ban expired before the sweeper removed it
+		UnbanIP(ip)
+		return false, time.Time{}
+	}
+	return true, until
 }
 
-// SetBannedIP registers IP to banned list
+// SetBannedIP bans the IP for the default ban duration
 func SetBannedIP(ip string) {
+	BanIP(ip, defaultBanDuration)
+}
+
+// BanIP bans the IP for the given duration, or the default ban duration if it is 0. Expired bans are
+// removed in the background
+func BanIP(ip string, duration time.Duration) {
+	if duration <= 0 {
+		duration = defaultBanDuration
+	}
+	sweepOnce.Do(func() {
+		go sweepBannedIPs()
+	})
+
 	mu.Lock()
 	defer mu.Unlock()
 
-	bannedIPs[ip] = time.Now()
+	bannedIPs[ip] = time.Now().Add(duration)
+}
+
+// UnbanIP removes the ban of the IP
+func UnbanIP(ip string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(bannedIPs, ip)
+}
+
+// ListBannedIPs returns the banned IPs with the time each ban ends
+func ListBannedIPs() map[string]time.Time {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	now := time.Now()
+	res := make(map[string]time.Time, len(bannedIPs))
+	for ip, until := range bannedIPs {
+		if until.After(now) {
+			res[ip] = until
+		}
+	}
+	return res
+}
+
+// SetBanSweepInterval sets how often the expired bans are removed
+func SetBanSweepInterval(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	atomic.StoreInt64(&sweepInterval, int64(interval))
+}
+
+// sweepBannedIPs removes the expired bans every sweep interval
+func sweepBannedIPs() {
+	for {
+		time.Sleep(time.Duration(atomic.LoadInt64(&sweepInterval)))
+
+		now := time.Now()
+		mu.Lock()
+		for ip, until := range bannedIPs {
+			if !until.After(now) {
+				delete(bannedIPs, ip)
+			}
+		}
+		mu.Unlock()
+	}
 }
@@ -0,0 +1,57 @@
+package security
+
+import (
+	"regexp"
+	"testing"
+)
+
+var crawlerRequests = []struct {
+	url       string
+	userAgent string
+}{
+	{"/api/users/42", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36"},
+	{"/static/js/app.js", "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_1) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15"},
+	{"/wp-admin/install.php", "Mozilla/5.0 (Windows NT 10.0; Win64; x64)"},
+	{"/index.html", "sqlmap/1.7.2#stable (https://sqlmap.org)"},
+}
+
+// matchString is the uncompiled matching IsCrawler used before the patterns were compiled once
+func matchString(str string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := regexp.MatchString(p, str); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGetMatchCompiledPatterns(t *testing.T) {
+	for _, r := range crawlerRequests {
+		if got, want := getMatch(r.url, exploitPatterns), matchString(r.url, webExploits); got != want {
+			t.Errorf("url %q: expected match %v, got %v", r.url, want, got)
+		}
+		if got, want := getMatch(r.userAgent, badUserAgents), matchString(r.userAgent, userAgents); got != want {
+			t.Errorf("user agent %q: expected match %v, got %v", r.userAgent, want, got)
+		}
+	}
+}
+
+// BenchmarkIsCrawler matches the requests against the compiled patterns, compare it with
+// BenchmarkIsCrawlerUncompiled to see the cost of compiling the patterns on every request
+func BenchmarkIsCrawler(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		r := crawlerRequests[i%len(crawlerRequests)]
+		if IsCrawler(r.url, "192.0.2.1", r.userAgent, 0) {
+			// lift the ban so every request goes through the patterns
+			UnbanIP("192.0.2.1")
+		}
+	}
+}
+
+func BenchmarkIsCrawlerUncompiled(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		r := crawlerRequests[i%len(crawlerRequests)]
+		_ = matchString(r.userAgent, userAgents)
+		_ = matchString(r.url, webExploits)
+	}
+}
@@ -8,9 +8,12 @@ import (
 	"fmt"
 	"github.com/najibulloShapoatov/server-core/monitoring/log"
 	"github.com/najibulloShapoatov/server-core/server/security"
+	"github.com/najibulloShapoatov/server-core/server/session"
 	"github.com/najibulloShapoatov/server-core/settings"
+	netutils "github.com/najibulloShapoatov/server-core/utils/net"
 	"io"
 	"mime"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -32,6 +35,11 @@ type Server struct {
 	certManager Manager
 	// Server
 	httpServer *http.Server
+	// ready channel is closed once the listener is accepting connections
+	ready     chan struct{}
+	readyOnce sync.Once
+	// address the listener is bound to
+	addr string
 	//
 	staticFiles map[string]struct{}
 }
@@ -57,6 +65,7 @@ func New(config *Config) (*Server, error) {
 	svc := &Server{
 		Config: config,
 		stop:   make(chan bool),
+		ready:  make(chan struct{}),
 	}
 	http.DefaultServeMux = http.NewServeMux()
 	http.HandleFunc("/", svc.handler)
@@ -72,12 +81,20 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 	s.active.Add(1)
 	defer s.active.Done()
 
+	// handlers past the write timeout can't answer anymore, so their work is canceled as well
+	if s.Config.WriteTimeout > 0 {
+		c, cancel := context.WithTimeout(r.Context(), s.Config.WriteTimeout)
+		defer cancel()
+		r = r.WithContext(c)
+	}
+
 	ctx := newContext(w, r)
 	ctx.Server = s
+	defer ctx.release()
 	var h HandlerFunc
 
 	if r.URL.Path == honeyPotPath {
-		security.SetBannedIP(ctx.RemoteAddr())
+		security.BanIP(ctx.RemoteAddr(), s.Config.Security.BanDuration)
 		ctx.Response.WriteHeader(http.StatusNoContent)
 		return
 	}
@@ -92,7 +109,12 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, ok := s.staticFiles[r.URL.Path]; ok {
+	// the metrics go through the middlewares so they get the same protection as the other routes
+	if cfg := s.Config.Metrics; cfg != nil && cfg.Enabled && r.URL.Path == cfg.Path {
+		h = metricsHandler
+	}
+
+	if _, ok := s.staticFiles[r.URL.Path]; h == nil && ok {
 		h = s.staticFileHandler
 	}
 
@@ -108,6 +130,9 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err := h(ctx)
+	if _, ok := err.(sentError); ok {
+		return
+	}
 	if err != nil {
 		if !ctx.Response.Committed {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -117,10 +142,19 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// sentError wraps errors that were already sent to the client as the response body
+type sentError struct {
+	error
+}
+
 func (s *Server) matchRoute(ctx *Context) HandlerFunc {
 	r := ctx.Request
 	w := ctx.Response
 
+	if h, ok := s.matchExplicitRoute(ctx); ok {
+		return h
+	}
+
 	parts := strings.Split(ctx.Request.URL.Path, "/")
 	if len(parts) < 4 {
 		// @TODO: check some other static paths
@@ -153,6 +187,32 @@ func (s *Server) matchRoute(ctx *Context) HandlerFunc {
 	return handler.Handler
 }
 
+// matchExplicitRoute looks for a route registered with RegisterHandler. It returns true if a route
+// matched, with a nil handler if the request was already handled (CORS preflight)
+func (s *Server) matchExplicitRoute(ctx *Context) (HandlerFunc, bool) {
+	r := ctx.Request
+	method := r.Method
+	if method == http.MethodOptions {
+		method = r.Header.Get(headerCORSReqMethod)
+	}
+	for _, route := range explicitRoutes {
+		if route.Method != method {
+			continue
+		}
+		params, ok := route.match(r.URL.Path)
+		if !ok {
+			continue
+		}
+		if r.Method == http.MethodOptions {
+			_ = postSecurityMiddleware(func(context2 *Context) error { return nil })(ctx)
+			return nil, true
+		}
+		ctx.params = params
+		return route.Handler, true
+	}
+	return nil, false
+}
+
 func (s *Server) optionsHandler(ctx *Context, service map[string]handler, name string) {
 	if _, ok := service[ctx.Request.Header.Get("Access-Control-Request-Method")+strings.ToLower(name)]; ok {
 		_ = postSecurityMiddleware(func(context2 *Context) error { return nil })(ctx)
@@ -187,9 +247,9 @@ func (s *Server) Start() error {
 	var tlsConfig *tls.Config
 	var addr string
 
+	// the middlewares registered last wrap the ones registered before them
 	UseMiddleware(
 		accessLogMiddleware,
-		recoverMiddleware,
 		monitoringMiddleware,
 		traceMiddleware,
 		preSecurityMiddleware,
@@ -200,10 +260,24 @@ func (s *Server) Start() error {
 
 	s.readStaticFiles()
 
+	// sessions are restored after the brute force and rate limit checks
+	if cfg := s.Config.Session; cfg != nil && cfg.Enabled {
+		if err := session.Init(cfg); err != nil {
+			return err
+		}
+		UseMiddleware(authMiddleware)
+	}
+
 	if s.Config.Security.BruteForce.Enabled {
 		_ = security.NewCollector(s.Config.Security.BruteForce.Rate, s.Config.Security.BruteForce.Capacity)
 		UseMiddleware(bruteForceMiddleware)
 	}
+	registerRateLimits()
+	UseMiddleware(rateLimitMiddleware)
+	// outermost layer so the panics of every middleware and handler are recovered
+	UseMiddleware(recoverMiddleware)
+	security.SetBanSweepInterval(s.Config.Security.BanSweepInterval)
+	netutils.SetTrustedProxies(strings.Split(s.Config.Security.TrustedProxies, ","))
 
 	if s.Config.HTTPS.Enabled {
 		addr = fmt.Sprintf("%s:%d", s.Config.Address, s.Config.HTTPS.Port)
@@ -220,16 +294,19 @@ func (s *Server) Start() error {
 			return err
 		}
 
+		opts, err := s.Config.HTTPS.options()
+		if err != nil {
+			return err
+		}
+
 		// pick the certificate manager
 		switch t := s.Config.HTTPS.CertType; {
 		case ok:
-			s.certManager = newExternalCertificate(s.Config.HTTPS.Cert, s.Config.HTTPS.Key)
+			s.certManager = newExternalCertificate(s.Config.HTTPS.Cert, s.Config.HTTPS.Key, s.Config.HTTPS.CertReloadInterval, opts)
 		case t == "self-signed":
-			s.certManager = newSelfSignManager()
-			tlsConfig = s.certManager.TLSConfig()
+			s.certManager = newSelfSignManager(opts)
 		case t == "lets-encrypt" || t == "auto":
-			s.certManager = newLetsEncryptManager([]string{s.Config.Host})
-			tlsConfig = s.certManager.TLSConfig()
+			s.certManager = newLetsEncryptManager([]string{s.Config.Host}, opts)
 		default:
 			return fmt.Errorf("invalid certificate provider: %s", s.Config.HTTPS.CertType)
 		}
@@ -237,33 +314,43 @@ func (s *Server) Start() error {
 		if s.certManager == nil {
 			return fmt.Errorf("no valid certificate provider")
 		}
-
-		if tlsConfig == nil {
-			tlsConfig = &tls.Config{
-				GetCertificate: s.certManager.GetCertificate,
-			}
-		}
+		tlsConfig = s.certManager.TLSConfig()
 	} else {
 		addr = fmt.Sprintf("%s:%d", s.Config.Address, s.Config.Port)
 	}
 
 	s.httpServer = &http.Server{
-		Addr:         addr,
-		Handler:      s,
-		TLSConfig:    tlsConfig,
-		ReadTimeout:  s.Config.ReadTimeout,
-		WriteTimeout: s.Config.WriteTimeout,
-		IdleTimeout:  s.Config.IdleTimeout,
+		Addr:              addr,
+		Handler:           s,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       s.Config.ReadTimeout,
+		ReadHeaderTimeout: s.Config.ReadHeaderTimeout,
+		WriteTimeout:      s.Config.WriteTimeout,
+		IdleTimeout:       s.Config.IdleTimeout,
+	}
+
+	// bind the listener before returning so the caller knows if the address is not available
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
 	}
+	s.addr = ln.Addr().String()
+	s.started = true
+	// the bound listener already accepts connections, they are served once Serve is called
+	s.readyOnce.Do(func() { close(s.ready) })
 
 	go func() {
-		s.started = true
 		var err error
 		if s.Config.HTTPS.Enabled {
 			crt, key := s.certManager.GetCertificateFiles()
-			err = s.httpServer.ListenAndServeTLS(crt, key)
+			// certificates loaded from files would take precedence over the manager
+			// and disable the certificate reload
+			if tlsConfig.GetCertificate != nil {
+				crt, key = "", ""
+			}
+			err = s.httpServer.ServeTLS(ln, crt, key)
 		} else {
-			err = s.httpServer.ListenAndServe()
+			err = s.httpServer.Serve(ln)
 		}
 		if err != nil {
 			if err != http.ErrServerClosed {
@@ -271,12 +358,33 @@ func (s *Server) Start() error {
 				os.Exit(1)
 			}
 		}
-		s.started = false
 	}()
 
 	return nil
 }
 
+// Addr returns the address the server listens on, which differs from the configured one when
+// the port is 0. Before the server is started the configured address is returned
+func (s *Server) Addr() string {
+	if s.addr != "" {
+		return s.addr
+	}
+	if s.Config.HTTPS.Enabled {
+		return fmt.Sprintf("%s:%d", s.Config.Address, s.Config.HTTPS.Port)
+	}
+	return fmt.Sprintf("%s:%d", s.Config.Address, s.Config.Port)
+}
+
+// WaitReady blocks until the server is accepting connections or the timeout expires
+func (s *Server) WaitReady(timeout time.Duration) error {
+	select {
+	case <-s.ready:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("server not ready after %s", timeout)
+	}
+}
+
 func (s *Server) Stop() error {
 	err := s.httpServer.Shutdown(context.Background())
 	if err != nil {
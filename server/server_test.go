@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/najibulloShapoatov/server-core/settings"
+)
+
+// testServer returns a server with the default configuration listening on a random local port
+func testServer(t *testing.T) *Server {
+	t.Helper()
+	var cfg *Config
+	if err := settings.GetSettings().Unmarshal(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Address = "127.0.0.1"
+	cfg.Port = 0
+	cfg.StaticPath = t.TempDir()
+	cfg.Session.Store = "mem"
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every Start adds the built in middlewares again
+	middlewares = middlewares[:0]
+	return s
+}
+
+func TestWaitReady(t *testing.T) {
+	s := testServer(t)
+
+	ready := make(chan error, 1)
+	go func() {
+		ready <- s.WaitReady(time.Second * 5)
+	}()
+	select {
+	case err := <-ready:
+		t.Fatalf("WaitReady returned before Start: %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Stop() }()
+	if err := <-ready; err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatalf("server is ready but doesn't accept connections: %s", err)
+	}
+	_ = conn.Close()
+
+	// a ready server stays ready
+	if err := s.WaitReady(time.Millisecond); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWaitReadyBindFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	s := testServer(t)
+	s.Config.Port, _ = strconv.Atoi(port)
+	if err := s.Start(); err == nil {
+		_ = s.Stop()
+		t.Fatal("expected Start to fail on a port already in use")
+	}
+	if err := s.WaitReady(time.Millisecond * 50); err == nil {
+		t.Error("expected WaitReady to time out when the bind failed")
+	}
+}
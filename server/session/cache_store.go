@@ -15,32 +15,27 @@ func (c *cacheStore) Set(session *Session) error {
 	if session.Persistent {
 		ttl = 0
 	}
-	return c.store.Set(string(session.ID), session, ttl)
+	return c.store.Set(sessionKey(session.ID), session, ttl)
 }
 
 func (c *cacheStore) Get(token Token) (session *Session) {
-	_ = c.store.Get(string(token), &session)
+	_ = c.store.Get(sessionKey(token), &session)
 	return
 }
 
 func (c *cacheStore) Del(token Token) error {
-	return c.store.Del(string(token))
+	return c.store.Del(sessionKey(token))
 }
 
-func (c *cacheStore) List(accountID *string) (res []*Session) {
+func (c *cacheStore) List(account *string, offset, limit int) []*Session {
+	var res []*Session
 	keys := c.store.Keys(sessionPrefix + "*")
 	for _, k := range keys {
-		if tmp := c.Get(Token(strings.TrimPrefix(k, sessionPrefix))); tmp != nil {
-			if len(strings.TrimSpace(*accountID)) > 0 {
-				if tmp.AccountID == accountID {
-					res = append(res, tmp)
-				}
-			} else {
-				res = append(res, tmp)
-			}
+		if tmp := c.Get(Token(strings.TrimPrefix(k, sessionPrefix))); tmp != nil && matchAccount(tmp, account) {
+			res = append(res, tmp)
 		}
 	}
-	return
+	return paginate(res, offset, limit)
 }
 
 func (r *cacheStore) GC() {
@@ -49,3 +44,8 @@ func (r *cacheStore) GC() {
 func (c *cacheStore) Close() {
 	c.store = nil
 }
+
+// sessionKey returns the cache key of a session
+func sessionKey(token Token) string {
+	return sessionPrefix + string(token)
+}
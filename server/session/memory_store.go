@@ -1,26 +1,39 @@
 package session
 
 import (
-	"errors"
-	"github.com/najibulloShapoatov/server-core/cache"
+	"encoding/json"
+	"sync"
+	"time"
 )
 
+// memoryStore keeps the sessions in memory, useful for tests and single node deployments
 type memoryStore struct {
-	cacheStore
+	lock     sync.RWMutex
+	sessions map[Token]memorySession
+	quit     chan struct{}
+}
+
+// memorySession is an encoded session with the fields needed to list and expire it
+type memorySession struct {
+	data         []byte
+	accountID    *string
+	lastActivity time.Time
+	persistent   bool
 }
 
 func (m *memoryStore) New() error {
-	if m != nil {
-		return nil
-	}
-	mem := cache.GetCache(cache.BigCache)
-	if mem == nil {
-		return errors.New("session store error - local cache is not initialized")
-	}
-	store := &memoryStore{
-		cacheStore{store: mem},
+	m.Close()
+
+	m.lock.Lock()
+	m.sessions = make(map[Token]memorySession)
+	m.quit = make(chan struct{})
+	m.lock.Unlock()
+
+	interval := time.Minute
+	if config != nil && config.TTL > 0 && config.TTL < interval {
+		interval = config.TTL
 	}
-	*m = *store
+	go m.gcLoop(interval, m.quit)
 	return nil
 }
 
@@ -28,7 +41,110 @@ func (m *memoryStore) Type() string {
 	return "mem"
 }
 
+func (m *memoryStore) Set(session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.sessions == nil {
+		m.sessions = make(map[Token]memorySession)
+	}
+	m.sessions[session.ID] = memorySession{
+		data:         data,
+		accountID:    session.AccountID,
+		lastActivity: session.LastActivity,
+		persistent:   session.Persistent,
+	}
+	return nil
+}
+
+func (m *memoryStore) Get(token Token) *Session {
+	m.lock.RLock()
+	s, ok := m.sessions[token]
+	m.lock.RUnlock()
+	if !ok || s.expired() {
+		return nil
+	}
+	return s.decode()
+}
+
+func (m *memoryStore) Del(token Token) error {
+	m.lock.Lock()
+	delete(m.sessions, token)
+	m.lock.Unlock()
+	return nil
+}
+
+func (m *memoryStore) List(account *string, offset, limit int) []*Session {
+	m.lock.RLock()
+	var res []*Session
+	for _, s := range m.sessions {
+		if s.expired() {
+			continue
+		}
+		if account != nil && *account != "" && (s.accountID == nil || *s.accountID != *account) {
+			continue
+		}
+		if session := s.decode(); session != nil {
+			res = append(res, session)
+		}
+	}
+	m.lock.RUnlock()
+	return paginate(res, offset, limit)
+}
+
+// GC removes the sessions inactive for longer than the configured TTL
+func (m *memoryStore) GC() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for token, s := range m.sessions {
+		if s.expired() {
+			delete(m.sessions, token)
+		}
+	}
+}
+
+// Close stops the garbage collection. The sessions are kept until the store is initialized again
+func (m *memoryStore) Close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.quit != nil {
+		close(m.quit)
+		m.quit = nil
+	}
+}
+
+func (m *memoryStore) gcLoop(interval time.Duration, quit chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.GC()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// expired mirrors Session.Expired without decoding the session
+func (s memorySession) expired() bool {
+	if s.persistent || config == nil || config.TTL <= 0 {
+		return false
+	}
+	return time.Since(s.lastActivity) > config.TTL
+}
+
+func (s memorySession) decode() *Session {
+	var session Session
+	if err := json.Unmarshal(s.data, &session); err != nil {
+		return nil
+	}
+	return &session
+}
+
 func init() {
-	var store *memoryStore
-	stores["mem"] = store
+	RegisterStore(&memoryStore{})
 }
@@ -0,0 +1,79 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+// memStore initializes the memory store with the given TTL
+func memStore(t *testing.T, ttl time.Duration) *memoryStore {
+	t.Helper()
+	if err := Init(&Config{Store: "mem", TTL: ttl}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	return store.(*memoryStore)
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	const ttl = time.Hour
+	account := "account"
+	tests := []struct {
+		name    string
+		session *Session
+		kept    bool
+	}{
+		{"active", &Session{ID: "active", LastActivity: time.Now(), AccountID: &account}, true},
+		{"idle past the TTL", &Session{ID: "idle", LastActivity: time.Now().Add(-ttl * 2), AccountID: &account}, false},
+		{"persistent", &Session{ID: "persistent", LastActivity: time.Now().Add(-ttl * 2), Persistent: true, AccountID: &account}, true},
+	}
+	m := memStore(t, ttl)
+	for _, tt := range tests {
+		if err := m.Set(tt.session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listed := map[Token]bool{}
+	for _, s := range m.List(&account, 0, 0) {
+		listed[s.ID] = true
+	}
+	for _, tt := range tests {
+		if got := m.Get(tt.session.ID) != nil; got != tt.kept {
+			t.Errorf("%s: expected Get to find the session %v", tt.name, tt.kept)
+		}
+		if listed[tt.session.ID] != tt.kept {
+			t.Errorf("%s: expected List to return the session %v", tt.name, tt.kept)
+		}
+	}
+
+	m.GC()
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, tt := range tests {
+		if _, ok := m.sessions[tt.session.ID]; ok != tt.kept {
+			t.Errorf("%s: expected the session to be kept by the GC %v", tt.name, tt.kept)
+		}
+	}
+}
+
+func TestMemoryStoreGCLoop(t *testing.T) {
+	const ttl = time.Millisecond * 20
+	m := memStore(t, ttl)
+	if err := m.Set(&Session{ID: "idle", LastActivity: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		m.lock.RLock()
+		count := len(m.sessions)
+		m.lock.RUnlock()
+		if count == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the idle session was not removed by the periodic GC")
+		}
+		time.Sleep(ttl)
+	}
+}
@@ -2,26 +2,26 @@ package session
 
 import (
 	"errors"
+
 	"github.com/najibulloShapoatov/server-core/cache"
+	"github.com/najibulloShapoatov/server-core/cache/redis"
 )
 
+// accountIndexPrefix is the prefix of the sets holding the session ids of each account
+const accountIndexPrefix = "sessions:account:"
+
 type redisStore struct {
 	cacheStore
+	redis *redis.Cache
 }
 
 func (r *redisStore) New() error {
-	if r != nil {
-		return nil
-	}
-	redis := cache.GetCache(cache.Redis)
-	if redis == nil {
+	c, ok := cache.GetCache(cache.Redis).(*redis.Cache)
+	if !ok || c == nil {
 		return errors.New("session store error - redis cache is not initialized")
 	}
-
-	store := &redisStore{
-		cacheStore{store: redis},
-	}
-	*r = *store
+	r.cacheStore = cacheStore{store: c}
+	r.redis = c
 	return nil
 }
 
@@ -29,8 +29,48 @@ func (r *redisStore) Type() string {
 	return "redis"
 }
 
+// Set stores the session and adds it to the index of its account
+func (r *redisStore) Set(session *Session) error {
+	if err := r.cacheStore.Set(session); err != nil {
+		return err
+	}
+	if session.AccountID != nil && *session.AccountID != "" {
+		return r.redis.SAdd(accountIndexPrefix+*session.AccountID, string(session.ID))
+	}
+	return nil
+}
+
+// Del removes the session and its entry in the index of its account
+func (r *redisStore) Del(token Token) error {
+	if s := r.cacheStore.Get(token); s != nil && s.AccountID != nil && *s.AccountID != "" {
+		_ = r.redis.SRem(accountIndexPrefix+*s.AccountID, string(token))
+	}
+	return r.cacheStore.Del(token)
+}
+
+// List reads the sessions of an account from the account index, removing the ids of the sessions that
+// expired or moved to another account. Without an account all the session keys are scanned
+func (r *redisStore) List(account *string, offset, limit int) []*Session {
+	if account == nil || *account == "" {
+		return r.cacheStore.List(account, offset, limit)
+	}
+	key := accountIndexPrefix + *account
+	ids, err := r.redis.SMembers(key)
+	if err != nil {
+		return nil
+	}
+	var res []*Session
+	for _, id := range ids {
+		s := r.cacheStore.Get(Token(id))
+		if s == nil || !matchAccount(s, account) {
+			_ = r.redis.SRem(key, id)
+			continue
+		}
+		res = append(res, s)
+	}
+	return paginate(res, offset, limit)
+}
 
 func init() {
-	var store *redisStore
-	stores["redis"] = store
+	RegisterStore(&redisStore{})
 }
@@ -39,6 +39,11 @@ type Session struct {
 	Locked bool `json:"locked" bson:"locked"`
 	// List of user permissions
 	Permissions *platform.Permissions `json:"permissions" bson:"permissions"`
+
+	// deferred sessions are written to the store only by Save
+	deferred bool
+	// modified since the last write
+	modified bool
 }
 
 // Creates a new session based on the user request
@@ -57,22 +62,121 @@ func New(r *http.Request) *Session {
 }
 
 func (s *Session) Set() {
-	_ = store.Set(s)
+	_ = s.Save()
+}
+
+// Save writes the session to the store
+func (s *Session) Save() error {
+	s.modified = false
+	return store.Set(s)
+}
+
+// Defer stops SetData from writing the session to the store on every call. The changes are written
+// by Save. The server calls it at the end of the request for the session of the request context when
+// sessions are enabled, any other session has to be saved by the caller
+func (s *Session) Defer() {
+	s.deferred = true
+}
+
+// Modified returns true if the session has changes not written to the store yet
+func (s *Session) Modified() bool {
+	return s.modified
+}
+
+// Update applies all the changes made by fn and writes the session to the store once
+func (s *Session) Update(fn func(*Session)) error {
+	fn(s)
+	return s.Save()
 }
 
+// Restore returns the session with the given token, or nil if it doesn't exist or the store is not initialized
 func Restore(token Token) *Session {
+	if store == nil {
+		return nil
+	}
 	return store.Get(token)
 }
 
+// Expired returns true if the session was inactive for longer than the configured TTL.
+// Persistent sessions never expire
+func (s *Session) Expired() bool {
+	if s.Persistent || config == nil || config.TTL <= 0 {
+		return false
+	}
+	return time.Since(s.LastActivity) > config.TTL
+}
+
+// Touch marks the session as active. To avoid writing to the store on every request, the store
+// is updated only when the last recorded activity is older than a tenth of the TTL (max 1 minute)
+func (s *Session) Touch() {
+	interval := time.Minute
+	if config != nil && config.TTL > 0 && config.TTL/10 < interval {
+		interval = config.TTL / 10
+	}
+	if time.Since(s.LastActivity) < interval {
+		return
+	}
+	s.LastActivity = time.Now()
+	_ = s.Save()
+}
+
+// SetData stores a value on the session and writes the session to the store, unless writes are
+// deferred with Defer
 func (s *Session) SetData(key string, val interface{}) {
+	if s.Data == nil {
+		s.Data = make(map[string]interface{})
+	}
 	s.Data[key] = val
-	_ = store.Set(s)
+	s.changed()
+}
+
+// Impersonate makes the session act as the given account, the real account of the session is kept
+func (s *Session) Impersonate(accountID int) {
+	s.ImpersonateAccountID = &accountID
+	s.changed()
+}
+
+// StopImpersonating makes the session act as its real account again
+func (s *Session) StopImpersonating() {
+	if s.ImpersonateAccountID == nil {
+		return
+	}
+	s.ImpersonateAccountID = nil
+	s.changed()
+}
+
+// Impersonating returns true if the session acts as another account
+func (s *Session) Impersonating() bool {
+	return s.ImpersonateAccountID != nil
+}
+
+// changed writes the session to the store, or marks it as modified if writes are deferred
+func (s *Session) changed() {
+	if s.deferred {
+		s.modified = true
+		return
+	}
+	_ = s.Save()
 }
 
 func (s *Session) GetData(key string) interface{} {
 	return s.Data[key]
 }
 
+// Regenerate moves the session to a new id and CSRF token, keeping its data and permissions, to prevent
+// session fixation. Call it after authenticating a user or changing its privileges, then send the new id
+// to the client since the old one is no longer valid
+func (s *Session) Regenerate() error {
+	old, csrf := s.ID, s.CSRFToken
+	s.ID = newToken()
+	s.CSRFToken = string(newToken())
+	if err := s.Save(); err != nil {
+		s.ID, s.CSRFToken = old, csrf
+		return err
+	}
+	return store.Del(old)
+}
+
 func (s *Session) Destroy() {
 	_ = store.Del(s.ID)
 }
@@ -2,6 +2,7 @@ package session
 
 import (
 	"errors"
+	"sort"
 	"time"
 )
 
@@ -17,9 +18,10 @@ type Store interface {
 	Get(Token) *Session
 	// Removes a session from the store
 	Del(Token) error
-	// List all available sessions.
-	// If argument is provided, it will return only sessions that match the account
-	List(*string) []*Session
+	// List the available sessions, most recently active first, skipping offset sessions and returning
+	// at most limit sessions (all of them if limit is 0).
+	// If an account is provided, it will return only sessions that match the account
+	List(account *string, offset, limit int) []*Session
 	// Removes all expired sessions
 	GC()
 	// Closes the store
@@ -70,3 +72,29 @@ func Init(cfg *Config) error {
 func RegisterStore(store Store) {
 	stores[store.Type()] = store
 }
+
+// paginate sorts the sessions by last activity, most recent first, and returns the requested page
+func paginate(sessions []*Session, offset, limit int) []*Session {
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastActivity.After(sessions[j].LastActivity)
+	})
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(sessions) {
+		return nil
+	}
+	sessions = sessions[offset:]
+	if limit > 0 && limit < len(sessions) {
+		sessions = sessions[:limit]
+	}
+	return sessions
+}
+
+// matchAccount returns true if no account is given or the session belongs to the account
+func matchAccount(session *Session, account *string) bool {
+	if account == nil || *account == "" {
+		return true
+	}
+	return session.AccountID != nil && *session.AccountID == *account
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// EventStream sends Server-Sent Events to the client
+// https://developer.mozilla.org/en-US/docs/Web/API/Server-sent_events/Using_server-sent_events
+type EventStream struct {
+	res  *Response
+	done <-chan struct{}
+}
+
+// EventStream prepares the response to send Server-Sent Events. Compression is disabled
+// for the response so the events reach the client as soon as they are flushed
+func (c *Context) EventStream() (*EventStream, error) {
+	if c.Response.Committed {
+		return nil, errors.New("response already sent")
+	}
+	if _, ok := c.Response.Writer.(http.Flusher); !ok {
+		return nil, errors.New("streaming not supported")
+	}
+	c.Response.Compressor(nil)
+
+	h := c.Response.Header()
+	h.Del(headerContentEncoding)
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	c.Response.WriteHeader(http.StatusOK)
+	c.Response.Flush()
+
+	return &EventStream{
+		res:  c.Response,
+		done: c.Request.Context().Done(),
+	}, nil
+}
+
+// Send writes an event to the client and flushes it. If event is empty the client
+// will receive it as a "message" event
+func (e *EventStream) Send(event, data string) error {
+	select {
+	case <-e.done:
+		return errors.New("client disconnected")
+	default:
+	}
+
+	var b strings.Builder
+	if event != "" {
+		_, _ = fmt.Fprintf(&b, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		_, _ = fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	if _, err := e.res.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	e.Flush()
+	return nil
+}
+
+// Flush sends any buffered data to the client
+func (e *EventStream) Flush() {
+	e.res.Flush()
+}
+
+// Done returns a channel that is closed when the client disconnects
+func (e *EventStream) Done() <-chan struct{} {
+	return e.done
+}
@@ -1,7 +1,6 @@
 package settings
 
 import (
-	"fmt"
 	"os"
 	"strings"
 	"time"
@@ -10,9 +9,10 @@ import (
 // envLoader loads and processes
 type envLoader struct {
 	watchForChanges bool
-	stop            chan bool
 	watchInterval   time.Duration
 	prefix          string
+	normalize       bool
+	poller
 }
 
 // NewEnvLoader returns a env vars parser
@@ -20,16 +20,21 @@ func NewEnvLoader(watchForChanges bool, prefix string) Loader {
 	loader := &envLoader{
 		watchForChanges: watchForChanges,
 		prefix:          prefix,
-		stop:            make(chan bool),
 		watchInterval:   time.Second * 3,
 	}
-	if watchForChanges {
-		go loader.watch()
-	}
 	return loader
 }
 
-func (f envLoader) Parse() (map[string]string, error) {
+// NewNormalizedEnvLoader returns a env vars parser that loads only the variables starting with prefix and
+// maps their names to dotted config keys by removing the prefix, lowering the case and replacing
+// underscores with dots, so APP_PLATFORM_SERVER_PORT is loaded as platform.server.port
+func NewNormalizedEnvLoader(watchForChanges bool, prefix string) Loader {
+	loader := NewEnvLoader(watchForChanges, prefix).(*envLoader)
+	loader.normalize = true
+	return loader
+}
+
+func (f *envLoader) Parse() (map[string]string, error) {
 	// Collect the environment variable
 	// If prefix is provided returning only variables with prefix
 	var res = map[string]string{}
@@ -42,6 +47,12 @@ func (f envLoader) Parse() (map[string]string, error) {
 		for _, v := range os.Environ() {
 			if strings.HasPrefix(strings.ToLower(v), strings.ToLower(f.prefix)) {
 				kv := strings.SplitN(v, "=", 2)
+				if f.normalize {
+					kv[0] = normalizeEnv(kv[0][len(f.prefix):])
+					if kv[0] == "" {
+						continue
+					}
+				}
 				res[kv[0]] = kv[1]
 			}
 		}
@@ -50,16 +61,15 @@ func (f envLoader) Parse() (map[string]string, error) {
 	return res, nil
 }
 
-func (f *envLoader) watch() {
-	timer := time.NewTimer(f.watchInterval)
-	for {
-		select {
-		case <-timer.C:
-			// parse again , notify is diffs (use diff)
-			fmt.Println("checking")
-		case <-f.stop:
-			timer.Stop()
-			return
-		}
+func (f *envLoader) watch(values map[string]string, notify func(old, new map[string]string)) {
+	if !f.watchForChanges {
+		return
 	}
+	f.start(f.Parse, values, f.watchInterval, notify)
+}
+
+// normalizeEnv maps the env var name without prefix to a config key: _PLATFORM_SERVER_PORT => platform.server.port
+func normalizeEnv(name string) string {
+	name = strings.Trim(name, "_")
+	return strings.ToLower(strings.ReplaceAll(name, "_", "."))
 }
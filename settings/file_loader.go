@@ -1,7 +1,6 @@
 package settings
 
 import (
-	"fmt"
 	"time"
 )
 
@@ -9,8 +8,9 @@ import (
 type fileLoader struct {
 	watchForChanges bool
 	filename        string
-	stop            chan bool
 	watchInterval   time.Duration
+	strict          bool
+	poller
 }
 
 // NewFileLoader returns a .conf file parser
@@ -18,12 +18,8 @@ func NewFileLoader(filename string, watchForChanges bool) Loader {
 	loader := &fileLoader{
 		watchForChanges: watchForChanges,
 		filename:        filename,
-		stop:            make(chan bool),
 		watchInterval:   time.Second * 3,
 	}
-	if watchForChanges {
-		go loader.watch()
-	}
 	return loader
 }
 
@@ -33,20 +29,13 @@ func (f *fileLoader) Parse() (map[string]string, error) {
 }
 
 func (f *fileLoader) parseFile(filename string) (map[string]string, error) {
-	strLoader := &stringLoader{filename: filename}
+	strLoader := &stringLoader{filename: filename, strict: f.strict}
 	return strLoader.parseData()
 }
 
-func (f *fileLoader) watch() {
-	timer := time.NewTimer(f.watchInterval)
-	for {
-		select {
-		case <-timer.C:
-			// not implemented
-			fmt.Println("checking")
-		case <-f.stop:
-			timer.Stop()
-			return
-		}
+func (f *fileLoader) watch(values map[string]string, notify func(old, new map[string]string)) {
+	if !f.watchForChanges {
+		return
 	}
+	f.start(f.Parse, values, f.watchInterval, notify)
 }
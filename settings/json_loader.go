@@ -0,0 +1,72 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// jsonLoader loads and processes .json files
+type jsonLoader struct {
+	filename string
+}
+
+// NewJSONLoader returns a .json file parser. Nested objects are flattened into dotted keys so
+// {"app":{"port":80}} is loaded as app.port=80 and array items are indexed like app.hosts.0
+func NewJSONLoader(filename string) Loader {
+	return &jsonLoader{filename: filename}
+}
+
+// Parse the file and returns a map of key=value settings
+func (f *jsonLoader) Parse() (map[string]string, error) {
+	data, err := ioutil.ReadFile(f.filename)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// keep numbers as they were written in the file
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%s: %s", f.filename, err)
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("%s: expecting an object", f.filename)
+	}
+
+	res := make(map[string]string)
+	flatten("", v, res)
+	return res, nil
+}
+
+// flatten stores the leaf values of nested maps and slices in res using dotted keys
+func flatten(prefix string, v interface{}, res map[string]string) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, item := range x {
+			flatten(join(k), item, res)
+		}
+	case map[interface{}]interface{}:
+		for k, item := range x {
+			flatten(join(fmt.Sprint(k)), item, res)
+		}
+	case []interface{}:
+		for i, item := range x {
+			flatten(join(strconv.Itoa(i)), item, res)
+		}
+	case nil:
+		// null values are treated as missing keys
+	default:
+		res[prefix] = fmt.Sprint(x)
+	}
+}
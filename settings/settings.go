@@ -2,10 +2,10 @@ package settings
 
 import (
 	"errors"
+	"fmt"
 	"github.com/najibulloShapoatov/server-core/utils/reflection"
 	"os"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,8 +13,17 @@ import (
 )
 
 type Settings struct {
-	lock sync.RWMutex
-	data map[string]string
+	lock      sync.RWMutex
+	data      map[string]string
+	callbacks map[string][]func(old, new string)
+	// values of the loaders in the order they were applied, later layers win
+	layers []*layer
+}
+
+// layer holds the last values parsed by a loader
+type layer struct {
+	loader Loader
+	values map[string]string
 }
 
 // Loader is used to load and parse configuration values from various formats and location
@@ -23,6 +32,9 @@ type Loader interface {
 	Parse() (map[string]string, error)
 }
 
+// key of the default value in the instance used to parse the default tags
+const defKey = "\x00\x01"
+
 // single instance of the Settings structure
 var instance = &Settings{data: make(map[string]string)}
 
@@ -33,18 +45,29 @@ func GetSettings() *Settings {
 
 // Has returns true if the given key exists
 func (s *Settings) Has(key string) bool {
-	s.lock.Lock()
-	_, ok := s.data[key]
-	s.lock.Unlock()
+	_, ok := s.get(key)
 	return ok
 }
 
+// get returns the raw value of the key. Keys that are not found are looked up again in lower case
+// to match the keys of the normalized environment variables
+func (s *Settings) get(key string) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	val, ok := s.data[key]
+	if !ok {
+		val, ok = s.data[strings.ToLower(key)]
+	}
+	return val, ok
+}
+
 // Load runs the given loaders in order to load and parse the configuration values. The first loader
-// that returns an error stops the load process
+// that returns an error stops the load process. The loaders watched by a previous load are stopped
 func (s *Settings) Load(loaders ...Loader) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
+	s.stopWatching()
 	s.data = make(map[string]string)
 
 	// load content and parse and store in data
@@ -56,25 +79,142 @@ func (s *Settings) Load(loaders ...Loader) error {
 		for k, v := range values {
 			s.data[k] = v
 		}
+		s.addLayer(loader, values)
+	}
+	return nil
+}
+
+// StopWatching stops watching the loaders for changes
+func (s *Settings) StopWatching() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stopWatching()
+}
+
+func (s *Settings) stopWatching() {
+	for _, l := range s.layers {
+		if w, ok := l.loader.(watcher); ok {
+			w.stopWatching()
+		}
+	}
+	s.layers = nil
+}
+
+// addLayer adds the values of the loader on top of the others and watches the loader for changes
+func (s *Settings) addLayer(loader Loader, values map[string]string) {
+	l := &layer{loader: loader, values: values}
+	s.layers = append(s.layers, l)
+	if w, ok := loader.(watcher); ok {
+		w.watch(values, func(_, new map[string]string) {
+			s.update(l, new)
+		})
+	}
+}
+
+// Merge runs the given loaders in order and overlays the values they return on top of the already
+// loaded configuration, unlike Load which replaces it. Nothing is changed if any of the loaders fails
+func (s *Settings) Merge(loaders ...Loader) error {
+	values := make(map[string]string)
+	parsed := make([]map[string]string, len(loaders))
+	for i, loader := range loaders {
+		v, err := loader.Parse()
+		if err != nil {
+			return err
+		}
+		for k, val := range v {
+			values[k] = val
+		}
+		parsed[i] = v
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for k, v := range values {
+		s.data[k] = v
+	}
+	for i, loader := range loaders {
+		s.addLayer(loader, parsed[i])
 	}
 	return nil
 }
 
+// OnChange registers a callback called when the value of the given key is changed by a watched loader.
+// An empty key registers the callback for all the keys. Removed keys are reported with an empty new value
+func (s *Settings) OnChange(key string, fn func(old, new string)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.callbacks == nil {
+		s.callbacks = make(map[string][]func(old, new string))
+	}
+	s.callbacks[key] = append(s.callbacks[key], fn)
+}
+
+// update applies the new values of a watched loader and calls the registered callbacks for the keys that
+// changed. A key keeps the value of the last loader defining it, so a change hidden by a loader applied
+// later is not visible until that loader removes the key
+func (s *Settings) update(l *layer, new map[string]string) {
+	type change struct {
+		key, old, new string
+		callbacks     []func(old, new string)
+	}
+	var changes []change
+
+	s.lock.Lock()
+	found := false
+	for _, item := range s.layers {
+		found = found || item == l
+	}
+	// the settings were loaded again since the loader was watched
+	if !found {
+		s.lock.Unlock()
+		return
+	}
+	old := l.values
+	l.values = new
+	for k := range diff(old, new) {
+		prev := s.data[k]
+		if v, ok := s.layerValue(k); ok {
+			s.data[k] = v
+		} else {
+			delete(s.data, k)
+		}
+		if prev == s.data[k] {
+			continue
+		}
+		callbacks := append(append([]func(old, new string){}, s.callbacks[k]...), s.callbacks[""]...)
+		changes = append(changes, change{key: k, old: prev, new: s.data[k], callbacks: callbacks})
+	}
+	s.lock.Unlock()
+
+	// callbacks are called without the lock so they can read the settings
+	for _, c := range changes {
+		for _, fn := range c.callbacks {
+			fn(c.old, c.new)
+		}
+	}
+}
+
+// layerValue returns the value of the key from the last layer defining it
+func (s *Settings) layerValue(key string) (string, bool) {
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		if v, ok := s.layers[i].values[key]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
 // GetString returns the value at the given key as a string and true if the key exists
 // or empty string and false if the key doesn't exist
 func (s *Settings) GetString(key string) (string, bool) {
-	s.lock.RLock()
-	val, ok := s.data[key]
-	s.lock.RUnlock()
+	val, ok := s.get(key)
 	return s.resolveVar(val), ok
 }
 
 // GetFloat returns the value at the given key parsed as a float and true if the key exists
 // or 0.0 and false if the key doesn't exist or failed to parse as a float64
 func (s *Settings) GetFloat(key string) (float64, bool) {
-	s.lock.RLock()
-	val, ok := s.data[key]
-	s.lock.RUnlock()
+	val, ok := s.get(key)
 
 	if !ok {
 		return 0, false
@@ -93,12 +233,46 @@ func (s *Settings) GetInt(key string) (int, bool) {
 	return int(v), ok
 }
 
+// GetStringSlice returns the comma separated value at the given key as a slice of trimmed strings and
+// true if the key exists. Lists loaded as indexed keys (key.0, key.1, etc) are returned as well
+func (s *Settings) GetStringSlice(key string) ([]string, bool) {
+	if val, ok := s.GetString(key); ok {
+		return splitList(val), true
+	}
+	val, ok := s.GetString(key + ".0")
+	if !ok {
+		return nil, false
+	}
+	var res []string
+	for i := 1; ok; i++ {
+		res = append(res, val)
+		val, ok = s.GetString(key + "." + strconv.Itoa(i))
+	}
+	return res, true
+}
+
+// GetIntSlice returns the comma separated value at the given key as a slice of ints and true if the
+// key exists or nil and false if the key doesn't exist or any of the values failed to parse as a int
+func (s *Settings) GetIntSlice(key string) ([]int, bool) {
+	items, ok := s.GetStringSlice(key)
+	if !ok {
+		return nil, false
+	}
+	res := make([]int, len(items))
+	for i, item := range items {
+		v, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, false
+		}
+		res[i] = v
+	}
+	return res, true
+}
+
 // GetDuration returns the value at the given key parsed as Duration and true if the key exists
 // or Duration(0) and false if the key doesn't exist or failed to parse as Duration
 func (s *Settings) GetDuration(key string) (time.Duration, bool) {
-	s.lock.RLock()
-	val, ok := s.data[key]
-	s.lock.RUnlock()
+	val, ok := s.get(key)
 	if !ok {
 		return 0, false
 	}
@@ -109,19 +283,80 @@ func (s *Settings) GetDuration(key string) (time.Duration, bool) {
 	return duration, true
 }
 
+// resolveVar expands the ${VAR} environment variables found in the value. ${VAR:-default} expands to
+// default when the variable is unset or empty and the default can contain variables too
 func (s *Settings) resolveVar(val string) string {
-	reg := regexp.MustCompile(`(\${.[^}]*})`)
-	matches := reg.FindAllStringSubmatch(val, -1)
-	for _, match := range matches {
-		env := strings.TrimSuffix(strings.TrimPrefix(match[0], "${"), "}")
-		val = strings.ReplaceAll(val, match[0], os.Getenv(env))
+	var res strings.Builder
+	for {
+		start := strings.Index(val, "${")
+		if start < 0 {
+			res.WriteString(val)
+			return res.String()
+		}
+		res.WriteString(val[:start])
+
+		// find the matching brace, skipping the variables nested in the default value
+		end, depth := -1, 0
+		for i := start; i < len(val) && end < 0; i++ {
+			switch {
+			case strings.HasPrefix(val[i:], "${"):
+				depth++
+				i++
+			case val[i] == '}':
+				if depth--; depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			// unterminated variable, keep it as it is
+			res.WriteString(val[start:])
+			return res.String()
+		}
+
+		name, def, hasDef := val[start+2:end], "", false
+		if i := strings.Index(name, ":-"); i >= 0 {
+			name, def, hasDef = name[:i], name[i+2:], true
+		}
+		if env := os.Getenv(name); env == "" && hasDef {
+			res.WriteString(s.resolveVar(def))
+		} else {
+			res.WriteString(env)
+		}
+		val = val[end+1:]
 	}
+}
+
+// Snapshot returns a copy of all the loaded configuration values
+func (s *Settings) Snapshot() map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 
-	if strings.HasPrefix(val, "${") && strings.HasSuffix(val, "}") {
-		val = strings.TrimSuffix(strings.TrimPrefix(val, "${"), "}")
-		return os.Getenv(val)
+	res := make(map[string]string, len(s.data))
+	for k, v := range s.data {
+		res[k] = v
 	}
-	return val
+	return res
+}
+
+// RestoreSnapshot replaces all the configuration values with the ones from the given snapshot
+func (s *Settings) RestoreSnapshot(snapshot map[string]string) {
+	data := make(map[string]string, len(snapshot))
+	for k, v := range snapshot {
+		data[k] = v
+	}
+
+	s.lock.Lock()
+	s.data = data
+	s.lock.Unlock()
+}
+
+// WithSettings runs fn and restores the configuration values to the state they were before
+// fn was called, regardless of what fn loaded in the meantime
+func WithSettings(fn func(s *Settings)) {
+	snapshot := instance.Snapshot()
+	defer instance.RestoreSnapshot(snapshot)
+	fn(instance)
 }
 
 func (s *Settings) GetKeys() (res []string) {
@@ -154,9 +389,7 @@ var truthTable = map[string]bool{
 // GetBool returns the value at the given key parsed as bool and true if the key exists
 // or false and false if the key doesn't exist or failed to parse as a truthy value
 func (s *Settings) GetBool(key string) (bool, bool) {
-	s.lock.RLock()
-	val, ok := s.data[key]
-	s.lock.RUnlock()
+	val, ok := s.get(key)
 	if !ok {
 		return false, false
 	}
@@ -177,10 +410,6 @@ func (s *Settings) Unmarshal(destinationPtr interface{}) error {
 	var v = pv
 	t := v.Type()
 
-	var defKey = "\x00\x01"
-
-	defer delete(s.data, defKey)
-
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
 		defValue := field.Tag.Get("default")
@@ -189,27 +418,35 @@ func (s *Settings) Unmarshal(destinationPtr interface{}) error {
 		if defValue == "" && cfgKey == "" {
 			continue
 		}
-		s.data[defKey] = defValue
-
 		fv := reflection.Indirect(pv.Field(i), false)
 
 		if fv.CanSet() {
 			var v reflect.Value
 			if fv.Type().AssignableTo(reflect.TypeOf(time.Duration(0))) {
-				fv.Set(decode(reflect.ValueOf(s.GetDuration), cfgKey, defValue))
+				fv.Set(s.decode("GetDuration", cfgKey, defValue))
 			} else {
 				switch fv.Kind() {
 				case reflect.Bool:
-					v = decode(reflect.ValueOf(s.GetBool), cfgKey, defValue)
+					v = s.decode("GetBool", cfgKey, defValue)
 				case reflect.String:
-					v = decode(reflect.ValueOf(s.GetString), cfgKey, defValue)
+					v = s.decode("GetString", cfgKey, defValue)
 				case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
 					reflect.Uint, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
-					v = decode(reflect.ValueOf(s.GetInt), cfgKey, defValue).Convert(fv.Type())
+					v = s.decode("GetInt", cfgKey, defValue).Convert(fv.Type())
 				case reflect.Float32, reflect.Float64:
-					v = decode(reflect.ValueOf(s.GetFloat), cfgKey, defValue).Convert(fv.Type())
+					v = s.decode("GetFloat", cfgKey, defValue).Convert(fv.Type())
 				case reflect.Struct:
 					_ = s.Unmarshal(fv.Addr().Interface())
+				case reflect.Slice:
+					var err error
+					if v, err = s.decodeSlice(cfgKey, defValue, fv.Type()); err != nil {
+						return err
+					}
+				case reflect.Map:
+					var err error
+					if v, err = s.decodeMap(cfgKey, fv.Type()); err != nil {
+						return err
+					}
 				}
 
 				if v.IsValid() {
@@ -221,16 +458,120 @@ func (s *Settings) Unmarshal(destinationPtr interface{}) error {
 	return nil
 }
 
-func decode(fn reflect.Value, cfgKey, defValue string) reflect.Value {
-	if fn.Kind() == reflect.Func {
-		out := fn.Call([]reflect.Value{reflect.ValueOf(cfgKey)})
-		if out[1].Bool() {
-			return out[0]
-		} else if defValue != "" {
-			out := fn.Call([]reflect.Value{reflect.ValueOf("\x00\x01")})
-			return out[0]
+// decodeSlice decodes a comma separated value or the indexed keys (key.0, key.1, etc) into a slice
+func (s *Settings) decodeSlice(cfgKey, defValue string, t reflect.Type) (reflect.Value, error) {
+	items, ok := s.GetStringSlice(cfgKey)
+	if !ok {
+		if defValue == "" {
+			return reflect.Value{}, nil
+		}
+		items = splitList(s.resolveVar(defValue))
+	}
+
+	res := reflect.MakeSlice(t, 0, len(items))
+	for _, item := range items {
+		v, err := convert(item, t.Elem())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%s: %s", cfgKey, err)
+		}
+		res = reflect.Append(res, v)
+	}
+	return res, nil
+}
+
+// decodeMap decodes all the keys starting with cfgKey into a map, so app.limits.login=10 is stored
+// under the login key of the app.limits map
+func (s *Settings) decodeMap(cfgKey string, t reflect.Type) (reflect.Value, error) {
+	if t.Key().Kind() != reflect.String {
+		return reflect.Value{}, fmt.Errorf("%s: map keys must be strings", cfgKey)
+	}
+	prefix := cfgKey + "."
+
+	s.lock.RLock()
+	values := make(map[string]string)
+	for k, v := range s.data {
+		if strings.HasPrefix(k, prefix) && len(k) > len(prefix) {
+			values[k[len(prefix):]] = v
+		}
+	}
+	s.lock.RUnlock()
+	if len(values) == 0 {
+		return reflect.Value{}, nil
+	}
+
+	res := reflect.MakeMapWithSize(t, len(values))
+	for k, val := range values {
+		v, err := convert(s.resolveVar(val), t.Elem())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%s: %s", prefix+k, err)
+		}
+		res.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), v)
+	}
+	return res, nil
+}
+
+// convert parses the string value as the given type
+func convert(val string, t reflect.Type) (reflect.Value, error) {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		d, err := ParseDuration(val)
+		return reflect.ValueOf(d), err
+	}
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(val)
+	case reflect.Bool:
+		b, ok := truthTable[strings.ToLower(val)]
+		if !ok {
+			return v, fmt.Errorf("invalid boolean %q", val)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(val, 0, t.Bits())
+		if err != nil {
+			return v, fmt.Errorf("invalid integer %q", val)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(val, 0, t.Bits())
+		if err != nil {
+			return v, fmt.Errorf("invalid unsigned integer %q", val)
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(val, t.Bits())
+		if err != nil {
+			return v, fmt.Errorf("invalid number %q", val)
+		}
+		v.SetFloat(f)
+	default:
+		return v, fmt.Errorf("unsupported type %s", t)
+	}
+	return v, nil
+}
+
+// splitList splits a comma separated value, trimming the spaces and dropping the empty items
+func splitList(val string) []string {
+	var res []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
 		}
 	}
+	return res
+}
+
+// decode calls the named getter for cfgKey and falls back to the getter result for the default value.
+// The default is read from a separate instance so the loaded data is never modified
+func (s *Settings) decode(getter string, cfgKey, defValue string) reflect.Value {
+	out := reflect.ValueOf(s).MethodByName(getter).Call([]reflect.Value{reflect.ValueOf(cfgKey)})
+	if out[1].Bool() {
+		return out[0]
+	} else if defValue != "" {
+		defaults := &Settings{data: map[string]string{defKey: defValue}}
+		out := reflect.ValueOf(defaults).MethodByName(getter).Call([]reflect.Value{reflect.ValueOf(defKey)})
+		return out[0]
+	}
 	return reflect.Value{}
 }
 
@@ -245,8 +586,8 @@ var unitMap = map[string]int64{
 	"h":  int64(time.Hour),
 	"d":  int64(time.Hour * 24),
 	"w":  int64(time.Hour * 24 * 7),
-	"M":  int64(time.Hour * 24 * 7 * 30),
-	"y":  int64(time.Hour * 24 * 7 * 30 * 12),
+	"M":  int64(time.Hour * 24 * 30),  // a month is approximated to 30 days
+	"y":  int64(time.Hour * 24 * 365), // a year is approximated to 365 days
 }
 
 var errLeadingInt = errors.New("time: bad [0-9]*") // never printed
@@ -307,7 +648,8 @@ func leadingFraction(s string) (x int64, scale float64, rem string) {
 // A duration string is a possibly signed sequence of
 // decimal numbers, each with optional fraction and a unit suffix,
 // such as "300ms", "-1.5h" or "2h45m".
-// Valid time units are "ns", "us" (or "µs"), "ms", "s", "m", "h".
+// Valid time units are "ns", "us" (or "µs"), "ms", "s", "m", "h", "d" (day), "w" (week),
+// "M" (month of 30 days) and "y" (year of 365 days).
 func ParseDuration(s string) (time.Duration, error) {
 	// [-+]?([0-9]*(\.[0-9]*)?[a-z]+)+
 	orig := s
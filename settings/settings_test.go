@@ -0,0 +1,143 @@
+package settings
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRestoreSnapshot(t *testing.T) {
+	initial := map[string]string{"host": "localhost", "port": "80"}
+	tests := []struct {
+		name   string
+		change func(s *Settings) error
+	}{
+		{"load", func(s *Settings) error { return s.Load(NewStringLoader("host = example.com")) }},
+		{"merge", func(s *Settings) error { return s.Merge(NewStringLoader("port = 8080\ndebug = yes")) }},
+		{"modify the snapshot", func(s *Settings) error {
+			s.Snapshot()["host"] = "example.com"
+			return nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Settings{data: make(map[string]string)}
+			s.RestoreSnapshot(initial)
+			snapshot := s.Snapshot()
+			if err := tt.change(s); err != nil {
+				t.Fatal(err)
+			}
+			s.RestoreSnapshot(snapshot)
+			if got := s.Snapshot(); !reflect.DeepEqual(got, initial) {
+				t.Errorf("expected %v after the restore, got %v", initial, got)
+			}
+			if v, _ := s.GetString("host"); v != "localhost" {
+				t.Errorf("expected the restored host, got %q", v)
+			}
+		})
+	}
+}
+
+func TestWithSettings(t *testing.T) {
+	before := GetSettings().Snapshot()
+	WithSettings(func(s *Settings) {
+		if err := s.Merge(NewStringLoader("test.withSettings = yes")); err != nil {
+			t.Fatal(err)
+		}
+		if !s.Has("test.withSettings") {
+			t.Error("expected the loaded value inside the scope")
+		}
+	})
+	if got := GetSettings().Snapshot(); !reflect.DeepEqual(got, before) {
+		t.Errorf("expected the global settings to be restored to %v, got %v", before, got)
+	}
+}
+
+func TestUnmarshalCollections(t *testing.T) {
+	type config struct {
+		Origins  []string          `config:"app.origins"`
+		Ports    []int             `config:"app.ports" default:"80,443"`
+		Timeouts []time.Duration   `config:"app.timeouts"`
+		Labels   map[string]string `config:"app.labels"`
+		Limits   map[string]int    `config:"app.limits"`
+	}
+	tests := []struct {
+		name    string
+		data    map[string]string
+		want    config
+		wantErr bool
+	}{
+		{
+			name: "comma separated values",
+			data: map[string]string{"app.origins": "https://a.com, https://b.com", "app.ports": "8080", "app.timeouts": "1s,2m"},
+			want: config{Origins: []string{"https://a.com", "https://b.com"}, Ports: []int{8080}, Timeouts: []time.Duration{time.Second, time.Minute * 2}},
+		},
+		{
+			name: "indexed keys",
+			data: map[string]string{"app.origins.0": "https://a.com", "app.origins.1": "https://b.com"},
+			want: config{Origins: []string{"https://a.com", "https://b.com"}, Ports: []int{80, 443}},
+		},
+		{
+			name: "maps",
+			data: map[string]string{"app.labels.env": "prod", "app.labels.team": "core", "app.limits.login": "10"},
+			want: config{Ports: []int{80, 443}, Labels: map[string]string{"env": "prod", "team": "core"}, Limits: map[string]int{"login": 10}},
+		},
+		{
+			name:    "invalid slice item",
+			data:    map[string]string{"app.ports": "80,http"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid map value",
+			data:    map[string]string{"app.limits.login": "ten"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Settings{data: make(map[string]string)}
+			s.RestoreSnapshot(tt.data)
+			var got config
+			err := s.Unmarshal(&got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	const day = time.Hour * 24
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"1d", day, false},
+		{"1w", day * 7, false},
+		{"1M", day * 30, false},
+		{"1y", day * 365, false},
+		{"1y2M", day * 425, false},
+		{"1.5d", day + time.Hour*12, false},
+		{"-1w", -day * 7, false},
+		{"1h30m", time.Minute * 90, false},
+		{"300ms", time.Millisecond * 300, false},
+		{"0", 0, false},
+		{"1", 0, true},
+		{"1x", 0, true},
+		{"300y", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.in, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.in, tt.want, got)
+		}
+	}
+}
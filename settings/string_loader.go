@@ -2,6 +2,7 @@ package settings
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
 )
@@ -9,10 +10,26 @@ import (
 type stringLoader struct {
 	src      string
 	filename string
+	// strict fails the parsing when a key is defined more than once
+	strict bool
 }
 
 func NewStringLoader(src string) Loader {
-	return &stringLoader{src, ""}
+	return &stringLoader{src: src}
+}
+
+// Strict makes the .conf file and string loaders fail when a key is defined more than once, including
+// keys defined again in included files. By default the last definition of a key wins
+//
+//	s.Load(settings.Strict(settings.NewFileLoader("app.conf", false)))
+func Strict(loader Loader) Loader {
+	switch l := loader.(type) {
+	case *stringLoader:
+		l.strict = true
+	case *fileLoader:
+		l.strict = true
+	}
+	return loader
 }
 
 func (f *stringLoader) Parse() (map[string]string, error) {
@@ -49,6 +66,16 @@ func (f *stringLoader) parseData() (res map[string]string, err error) {
 		p.next()
 	}
 
+	// set stores the value and reports duplicated keys in strict mode
+	set := func(key, value string) bool {
+		if _, ok := res[key]; ok && f.strict {
+			p.error(fmt.Sprintf("duplicate key %s", key))
+			return false
+		}
+		res[key] = value
+		return true
+	}
+
 	var key string
 	for {
 		tok, lit := p.scan()
@@ -75,20 +102,24 @@ func (f *stringLoader) parseData() (res map[string]string, err error) {
 					fname = filepath.Clean(filepath.Join(dir, l))
 				}
 				// parse the included file and append values to current result
-				strLoader := &stringLoader{filename: fname}
+				strLoader := &stringLoader{filename: fname, strict: f.strict}
 				d, e := strLoader.parseData()
 				if e != nil {
 					return nil, e
 				}
 				for k, v := range d {
-					res[k] = v
+					if !set(k, v) {
+						return nil, p.err
+					}
 				}
 				continue
 			}
 			if key == "" {
 				key = lit
 			} else {
-				res[key] = lit
+				if !set(key, lit) {
+					return nil, p.err
+				}
 				key = ""
 			}
 		case equal:
@@ -101,7 +132,9 @@ func (f *stringLoader) parseData() (res map[string]string, err error) {
 				p.error("missing key")
 				return nil, p.err
 			}
-			res[key] = lit
+			if !set(key, lit) {
+				return nil, p.err
+			}
 			key = ""
 		}
 	}
@@ -0,0 +1,93 @@
+package settings
+
+import (
+	"sync"
+	"time"
+)
+
+// watcher is implemented by loaders that can watch their source for changes
+type watcher interface {
+	// watch starts watching the source, values being the last parsed values. notify is called with the
+	// previous and the new values every time they change. Watching again restarts the watch
+	watch(values map[string]string, notify func(old, new map[string]string))
+	// stopWatching stops the watch started by watch, if any
+	stopWatching()
+}
+
+// poller runs the pollChanges goroutine of a loader
+type poller struct {
+	lock sync.Mutex
+	stop chan struct{}
+}
+
+// start stops the running poller and polls the source with the given values
+func (p *poller) start(parse func() (map[string]string, error), values map[string]string, interval time.Duration,
+	notify func(old, new map[string]string)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.stop != nil {
+		close(p.stop)
+	}
+	p.stop = make(chan struct{})
+	go pollChanges(parse, values, interval, p.stop, notify)
+}
+
+func (p *poller) stopWatching() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+// watchLoader wraps a loader and parses it again periodically to detect changes
+type watchLoader struct {
+	Loader
+	poller
+	interval time.Duration
+}
+
+// Watch returns a loader that parses the given loader again on every interval once it was loaded, so
+// changes of the source are applied to the settings and reported to the OnChange callbacks. The watch
+// stops when the settings are loaded again or StopWatching is called
+//
+//	s.Load(settings.Watch(settings.NewJSONLoader("app.json"), time.Second*3))
+func Watch(loader Loader, interval time.Duration) Loader {
+	return &watchLoader{
+		Loader:   loader,
+		interval: interval,
+	}
+}
+
+func (w *watchLoader) watch(values map[string]string, notify func(old, new map[string]string)) {
+	w.start(w.Parse, values, w.interval, notify)
+}
+
+// pollChanges parses the source on every interval and notifies the changes since the previous parse.
+// Parse errors are ignored, the source being checked again on the next interval
+func pollChanges(parse func() (map[string]string, error), prev map[string]string, interval time.Duration,
+	stop chan struct{}, notify func(old, new map[string]string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			values, err := parse()
+			if err != nil {
+				continue
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if len(diff(prev, values)) != 0 {
+				notify(prev, values)
+				prev = values
+			}
+		case <-stop:
+			return
+		}
+	}
+}
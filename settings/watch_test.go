@@ -0,0 +1,98 @@
+package settings
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLoader returns values that can be changed while it is watched
+type testLoader struct {
+	lock   sync.Mutex
+	values map[string]string
+}
+
+func (l *testLoader) Parse() (map[string]string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	res := make(map[string]string, len(l.values))
+	for k, v := range l.values {
+		res[k] = v
+	}
+	return res, nil
+}
+
+func (l *testLoader) set(key, value string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.values[key] = value
+}
+
+const testWatchInterval = time.Millisecond * 10
+
+// eventually waits for the condition to be true
+func eventually(t *testing.T, cond func() bool) bool {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(testWatchInterval) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWatchKeepsLoaderPriority(t *testing.T) {
+	s := &Settings{data: make(map[string]string)}
+	defer s.StopWatching()
+	base := &testLoader{values: map[string]string{"port": "80", "host": "localhost"}}
+	override := &testLoader{values: map[string]string{"port": "8080"}}
+	if err := s.Load(Watch(base, testWatchInterval), override); err != nil {
+		t.Fatal(err)
+	}
+	changed := make(chan string, 10)
+	s.OnChange("", func(old, new string) { changed <- new })
+
+	base.set("port", "81")
+	base.set("host", "example.com")
+	if !eventually(t, func() bool { v, _ := s.GetString("host"); return v == "example.com" }) {
+		t.Fatal("the change of the watched loader was not applied")
+	}
+	if v, _ := s.GetString("port"); v != "8080" {
+		t.Errorf("expected the value of the later loader to win, got %s", v)
+	}
+	if v := <-changed; v != "example.com" {
+		t.Errorf("expected only the host change to be reported, got %s", v)
+	}
+	select {
+	case v := <-changed:
+		t.Errorf("unexpected change %s", v)
+	default:
+	}
+}
+
+func TestStopWatching(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(s *Settings) error
+	}{
+		{"StopWatching", func(s *Settings) error { s.StopWatching(); return nil }},
+		{"Load again", func(s *Settings) error { return s.Load(&testLoader{values: map[string]string{}}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Settings{data: make(map[string]string)}
+			defer s.StopWatching()
+			loader := &testLoader{values: map[string]string{"key": "1"}}
+			if err := s.Load(Watch(loader, testWatchInterval)); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.stop(s); err != nil {
+				t.Fatal(err)
+			}
+			loader.set("key", "2")
+			if eventually(t, func() bool { v, _ := s.GetString("key"); return v == "2" }) {
+				t.Error("the stopped loader was still watched")
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package settings
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlLoader loads and processes .yaml files
+type yamlLoader struct {
+	filename string
+}
+
+// NewYAMLLoader returns a .yaml file parser. Nested maps are flattened into dotted keys so
+//
+//	app:
+//	  port: 80
+//
+// is loaded as app.port=80 and sequence items are indexed like app.hosts.0. Scalars are kept as they
+// were written in the file and null values are treated as missing keys
+func NewYAMLLoader(filename string) Loader {
+	return &yamlLoader{filename: filename}
+}
+
+// Parse the file and returns a map of key=value settings
+func (f *yamlLoader) Parse() (map[string]string, error) {
+	data, err := ioutil.ReadFile(f.filename)
+	if err != nil {
+		return nil, err
+	}
+	res, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", f.filename, err)
+	}
+	return res, nil
+}
+
+func parseYAML(data []byte) (map[string]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	res := make(map[string]string)
+	// an empty document has no content
+	if len(doc.Content) == 0 {
+		return res, nil
+	}
+	root := doc.Content[0]
+	if root.Kind == yaml.AliasNode {
+		root = root.Alias
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expecting a map")
+	}
+	flattenYAML("", root, res)
+	return res, nil
+}
+
+// flattenYAML stores the scalars of nested maps and sequences in res using dotted keys
+func flattenYAML(prefix string, node *yaml.Node, res map[string]string) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	switch node.Kind {
+	case yaml.AliasNode:
+		flattenYAML(prefix, node.Alias, res)
+	case yaml.MappingNode:
+		// merge keys (<<: *base) add the keys of the referenced maps, the keys of the map itself win
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key, value := node.Content[i], node.Content[i+1]; key.ShortTag() == "!!merge" {
+				if value.Kind == yaml.SequenceNode {
+					for _, item := range value.Content {
+						flattenYAML(prefix, item, res)
+					}
+				} else {
+					flattenYAML(prefix, value, res)
+				}
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key, value := node.Content[i], node.Content[i+1]; key.ShortTag() != "!!merge" {
+				flattenYAML(join(key.Value), value, res)
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			flattenYAML(join(strconv.Itoa(i)), item, res)
+		}
+	case yaml.ScalarNode:
+		// null values are treated as missing keys
+		if node.ShortTag() != "!!null" {
+			res[prefix] = node.Value
+		}
+	}
+}
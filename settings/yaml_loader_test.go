@@ -0,0 +1,77 @@
+package settings
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "nested maps",
+			data: "app:\n  host: localhost\n  port: 80\n  timeout: 3d\n",
+			want: map[string]string{"app.host": "localhost", "app.port": "80", "app.timeout": "3d"},
+		},
+		{
+			name: "flow sequence with quoted commas",
+			data: `hosts: ["a, b", c]`,
+			want: map[string]string{"hosts.0": "a, b", "hosts.1": "c"},
+		},
+		{
+			name: "block sequence of maps",
+			data: "servers:\n  - host: a\n    port: 1\n  - host: b\n",
+			want: map[string]string{"servers.0.host": "a", "servers.0.port": "1", "servers.1.host": "b"},
+		},
+		{
+			name: "literal and folded strings",
+			data: "text: |\n  line 1\n  line 2\nfolded: >-\n  a\n  b\n",
+			want: map[string]string{"text": "line 1\nline 2\n", "folded": "a b"},
+		},
+		{
+			name: "comments and quoted values",
+			data: "# comment\nname: 'john # not a comment' # comment\nurl: \"http://host:80\"\n",
+			want: map[string]string{"name": "john # not a comment", "url": "http://host:80"},
+		},
+		{
+			name: "null values are missing",
+			data: "a: ~\nb: null\nc:\nd: 1.50\n",
+			want: map[string]string{"d": "1.50"},
+		},
+		{
+			name: "anchors and merge keys",
+			data: "base: &base\n  host: localhost\n  port: 80\napp:\n  <<: *base\n  port: 8080\n",
+			want: map[string]string{"base.host": "localhost", "base.port": "80", "app.host": "localhost", "app.port": "8080"},
+		},
+		{
+			name: "empty document",
+			data: "",
+			want: map[string]string{},
+		},
+		{
+			name:    "top level sequence",
+			data:    "- a\n- b\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid yaml",
+			data:    "a: [b\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
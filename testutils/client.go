@@ -0,0 +1,182 @@
+package testutils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/najibulloShapoatov/server-core/server"
+)
+
+// Client sends requests to a running server to test the handlers together with the middleware chain
+type Client struct {
+	// BaseURL is prepended to the path of every request
+	BaseURL string
+	// Header is added to every request
+	Header http.Header
+	// HTTP is the client used to send the requests
+	HTTP *http.Client
+}
+
+// Response of a request sent by the Client, with the body already read
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// NewClient returns a client for the server listening on addr, which can be a host:port pair or an URL
+func NewClient(addr string) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{
+		BaseURL: strings.TrimSuffix(addr, "/"),
+		Header:  make(http.Header),
+		HTTP:    &http.Client{Timeout: time.Second * 30},
+	}
+}
+
+// NewServerClient returns a client for the given server, which must be started. Certificates are not
+// verified when the server runs on HTTPS so self signed certificates can be used
+func NewServerClient(s *server.Server) *Client {
+	host, port, err := net.SplitHostPort(s.Addr())
+	if err != nil {
+		host, port = s.Addr(), ""
+	}
+	// the server listens on all interfaces
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	scheme := "http"
+	if s.Config.HTTPS.Enabled {
+		scheme = "https"
+	}
+	c := NewClient(scheme + "://" + net.JoinHostPort(host, port))
+	if s.Config.HTTPS.Enabled {
+		c.HTTP.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	return c
+}
+
+func (c *Client) GET(path string, body interface{}) (*Response, error) {
+	return c.Do(http.MethodGet, path, body)
+}
+
+func (c *Client) POST(path string, body interface{}) (*Response, error) {
+	return c.Do(http.MethodPost, path, body)
+}
+
+func (c *Client) PUT(path string, body interface{}) (*Response, error) {
+	return c.Do(http.MethodPut, path, body)
+}
+
+func (c *Client) DELETE(path string, body interface{}) (*Response, error) {
+	return c.Do(http.MethodDelete, path, body)
+}
+
+// Do sends a request and reads the response. The body can be nil, a string, a []byte or an io.Reader
+// sent as they are, url.Values sent as a form, or any other value sent as JSON
+func (c *Client) Do(method, path string, body interface{}) (*Response, error) {
+	reader, contentType, err := encodeBody(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.Header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	if contentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       data,
+	}, nil
+}
+
+func encodeBody(body interface{}) (io.Reader, string, error) {
+	switch b := body.(type) {
+	case nil:
+		return nil, "", nil
+	case string:
+		return strings.NewReader(b), "text/plain; charset=utf-8", nil
+	case []byte:
+		return bytes.NewReader(b), "application/octet-stream", nil
+	case io.Reader:
+		return b, "application/octet-stream", nil
+	case url.Values:
+		return strings.NewReader(b.Encode()), "application/x-www-form-urlencoded", nil
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return bytes.NewReader(data), "application/json", nil
+}
+
+// Decode unmarshals the JSON body of the response into v
+func (r *Response) Decode(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// AssertStatus returns an error if the response doesn't have the given status
+func (r *Response) AssertStatus(status int) error {
+	if r.StatusCode != status {
+		return fmt.Errorf("expected status %d but was %d: %s", status, r.StatusCode, r.Body)
+	}
+	return nil
+}
+
+// AssertBody returns an error if the body is not the given text, ignoring the surrounding whitespace
+func (r *Response) AssertBody(expected string) error {
+	if body := strings.TrimSpace(string(r.Body)); body != strings.TrimSpace(expected) {
+		return fmt.Errorf("expected body %q but was %q", expected, body)
+	}
+	return nil
+}
+
+// AssertJSON returns an error if the JSON body is not equal to the JSON encoding of expected
+func (r *Response) AssertJSON(expected interface{}) error {
+	var got, want interface{}
+	if err := json.Unmarshal(r.Body, &got); err != nil {
+		return fmt.Errorf("invalid JSON body %q: %s", r.Body, err)
+	}
+	data, err := json.Marshal(expected)
+	if err != nil {
+		return err
+	}
+	_ = json.Unmarshal(data, &want)
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("expected body %s but was %s", data, r.Body)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package testutils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/najibulloShapoatov/server-core/server"
+)
+
+func TestClient(t *testing.T) {
+	s, err := NewMockServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	// echo answers with the method, content type and body of the request
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"method":      r.Method,
+			"contentType": r.Header.Get("Content-Type"),
+			"token":       r.Header.Get("X-Token"),
+			"body":        string(body),
+		})
+	}
+
+	tests := []struct {
+		name        string
+		send        func(c *Client) (*Response, error)
+		method      string
+		contentType string
+		body        string
+	}{
+		{"GET", func(c *Client) (*Response, error) { return c.GET("/echo", nil) }, http.MethodGet, "", ""},
+		{"POST JSON", func(c *Client) (*Response, error) {
+			return c.POST("/echo", map[string]int{"id": 1})
+		}, http.MethodPost, "application/json", `{"id":1}`},
+		{"PUT form", func(c *Client) (*Response, error) {
+			return c.PUT("/echo", url.Values{"name": {"john"}})
+		}, http.MethodPut, "application/x-www-form-urlencoded", "name=john"},
+		{"DELETE text", func(c *Client) (*Response, error) {
+			return c.DELETE("/echo", "reason")
+		}, http.MethodDelete, "text/plain; charset=utf-8", "reason"},
+		{"raw bytes", func(c *Client) (*Response, error) {
+			return c.POST("/echo", []byte{1, 2})
+		}, http.MethodPost, "application/octet-stream", "\x01\x02"},
+	}
+	c := NewClient(s.Addr())
+	c.Header.Set("X-Token", "secret")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.MockCallback("/echo", echo)
+			res, err := tt.send(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := res.AssertStatus(http.StatusOK); err != nil {
+				t.Error(err)
+			}
+			want := map[string]string{"method": tt.method, "contentType": tt.contentType, "token": "secret", "body": tt.body}
+			if err := res.AssertJSON(want); err != nil {
+				t.Error(err)
+			}
+			var got map[string]string
+			if err := res.Decode(&got); err != nil || got["method"] != tt.method {
+				t.Errorf("expected the decoded method %s, got %v %v", tt.method, got, err)
+			}
+		})
+	}
+}
+
+func TestResponseAssertions(t *testing.T) {
+	res := &Response{StatusCode: http.StatusNotFound, Body: []byte(" {\"error\":\"not found\"}\n")}
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{"same status", res.AssertStatus(http.StatusNotFound), false},
+		{"other status", res.AssertStatus(http.StatusOK), true},
+		{"same body", res.AssertBody(`{"error":"not found"}`), false},
+		{"other body", res.AssertBody("not found"), true},
+		{"same JSON", res.AssertJSON(map[string]string{"error": "not found"}), false},
+		{"other JSON", res.AssertJSON(map[string]string{"error": "gone"}), true},
+	}
+	for _, tt := range tests {
+		if (tt.err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, tt.err)
+		}
+	}
+}
+
+func TestNewServerClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		https   bool
+		want    string
+	}{
+		{"all interfaces", "0.0.0.0", false, "http://127.0.0.1:8080"},
+		{"no address", "", false, "http://127.0.0.1:8080"},
+		{"host", "localhost", false, "http://localhost:8080"},
+		{"https", "127.0.0.1", true, "https://127.0.0.1:8443"},
+	}
+	for _, tt := range tests {
+		cfg := &server.Config{Address: tt.address, Port: 8080}
+		cfg.HTTPS.Enabled, cfg.HTTPS.Port = tt.https, 8443
+		c := NewServerClient(&server.Server{Config: cfg})
+		if c.BaseURL != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, c.BaseURL)
+		}
+		if tt.https != (c.HTTP.Transport != nil) {
+			t.Errorf("%s: expected the certificates to be ignored %v", tt.name, tt.https)
+		}
+	}
+}
@@ -25,6 +25,9 @@ type mockTime struct {
 	d time.Duration
 }
 
+// matcher is an argument predicate created with Mock.Matching
+type matcher func(interface{}) bool
+
 type Mock struct {
 	calls []*fnCall
 	stage []*staged
@@ -53,6 +56,12 @@ func (m *Mock) OfType() ofType {
 	return ofType(1)
 }
 
+// Matching returns an argument matcher for WasCalledWith and LastCalledWith
+// which accepts any argument for which fn returns true
+func (m *Mock) Matching(fn func(interface{}) bool) matcher {
+	return matcher(fn)
+}
+
 func (m *Mock) Trace(fn interface{}, args ...interface{}) {
 	m.calls = append(m.calls, &fnCall{fn, args})
 }
@@ -150,6 +159,12 @@ func (m *Mock) WasCalledWith(fn interface{}, args ...interface{}) error {
 					allMatched = false
 					break
 				}
+			} else if match, ok := expectedArg.(matcher); ok {
+				if !match(realArg) {
+					calledWithOtherArgs = fmt.Errorf("expected argument %d to match but was %v", idx, realArg)
+					allMatched = false
+					break
+				}
 			} else if _, ok := expectedArg.(anything); ok {
 				//
 			} else if !reflect.DeepEqual(expectedArg, realArg) {
@@ -177,7 +192,8 @@ func isNil(v interface{}) bool {
 }
 
 func (m *Mock) LastCalledWith(fn interface{}, args ...interface{}) error {
-	for idx := len(m.calls); idx > 0; idx-- {
+	var calledWithOtherArgs error
+	for idx := len(m.calls) - 1; idx >= 0; idx-- {
 		call := m.calls[idx]
 
 		if runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name() !=
@@ -185,16 +201,30 @@ func (m *Mock) LastCalledWith(fn interface{}, args ...interface{}) error {
 			continue
 		}
 
+		// a call with another number of arguments can't be the one we look for
 		if len(args) != len(call.args) {
-			return fmt.Errorf("exepected function to have been called with %d argumets but was called with %d arguments", len(args), len(call.args))
+			if calledWithOtherArgs == nil {
+				calledWithOtherArgs = fmt.Errorf("exepected function to have been called with %d argumets but was called with %d arguments", len(args), len(call.args))
+			}
+			continue
 		}
 		for idx, a := range args {
+			if match, ok := a.(matcher); ok {
+				if !match(call.args[idx]) {
+					return fmt.Errorf("expected argument %d to match but was %v", idx, call.args[idx])
+				}
+				continue
+			}
 			if !reflect.DeepEqual(a, call.args[idx]) {
 				return fmt.Errorf("expected argument %d to be %v but was %v", idx, a, call.args[idx])
 			}
 		}
 		return nil
 	}
+
+	if calledWithOtherArgs != nil {
+		return calledWithOtherArgs
+	}
 	return errors.New("function was never called")
 }
 
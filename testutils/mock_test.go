@@ -0,0 +1,51 @@
+package testutils
+
+import "testing"
+
+func tracedSave(name string, count int)  {}
+func tracedLoad(name string)             {}
+func tracedRemove(name string, all bool) {}
+
+func TestLastCalledWith(t *testing.T) {
+	tests := []struct {
+		name    string
+		calls   func(m *Mock)
+		fn      interface{}
+		args    []interface{}
+		wantErr bool
+	}{
+		{"never called", func(m *Mock) {}, tracedSave, []interface{}{"a", 1}, true},
+		{"single call", func(m *Mock) { m.Trace(tracedSave, "a", 1) }, tracedSave, []interface{}{"a", 1}, false},
+		{"last call", func(m *Mock) {
+			m.Trace(tracedSave, "a", 1)
+			m.Trace(tracedSave, "b", 2)
+		}, tracedSave, []interface{}{"b", 2}, false},
+		{"earlier call is not the last", func(m *Mock) {
+			m.Trace(tracedSave, "a", 1)
+			m.Trace(tracedSave, "b", 2)
+		}, tracedSave, []interface{}{"a", 1}, true},
+		{"other functions are skipped", func(m *Mock) {
+			m.Trace(tracedSave, "a", 1)
+			m.Trace(tracedLoad, "b")
+		}, tracedSave, []interface{}{"a", 1}, false},
+		{"calls with another number of arguments are skipped", func(m *Mock) {
+			m.Trace(tracedRemove, "a", true)
+			m.Trace(tracedRemove, "b")
+		}, tracedRemove, []interface{}{"a", true}, false},
+		{"only calls with another number of arguments", func(m *Mock) {
+			m.Trace(tracedRemove, "b")
+		}, tracedRemove, []interface{}{"a", true}, true},
+		{"matcher", func(m *Mock) { m.Trace(tracedSave, "a", 5) }, tracedSave, []interface{}{"a", (&Mock{}).Matching(func(v interface{}) bool {
+			return v.(int) > 3
+		})}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMock()
+			tt.calls(m)
+			if err := m.LastCalledWith(tt.fn, tt.args...); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
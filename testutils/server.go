@@ -3,20 +3,26 @@ package testutils
 import (
 	"context"
 	"io"
+	"net"
 	"net/http"
-	"time"
+	"sync"
 )
 
 type MockServer struct {
 	server   *http.Server
 	addr     string
+	lock     sync.Mutex
 	calls    []*httpCall
 	response []*mockedResponses
 }
 
+// RequestMatcher is an extra condition a request has to meet to get a mocked response
+type RequestMatcher func(r *http.Request) bool
+
 type mockedResponses struct {
 	method   string
 	path     string
+	matchers []RequestMatcher
 	callback http.HandlerFunc
 }
 
@@ -24,19 +30,38 @@ type httpCall struct {
 	request *http.Request
 }
 
-func NewMockServer(addr string) (s *MockServer, err error) {
+// NewMockServer starts a server listening on the given address. Binding errors are returned right away
+// and an address with port 0 listens on a random port, available through Addr
+func NewMockServer(addr string) (*MockServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
 	svr := &MockServer{
 		server: &http.Server{
 			Addr: addr,
 		},
-		addr: addr,
+		addr: l.Addr().String(),
 	}
 	svr.server.Handler = svr
 	go func() {
-		err = svr.server.ListenAndServe()
+		_ = svr.server.Serve(l)
 	}()
-	<-time.After(time.Millisecond * 500)
-	return svr, err
+	return svr, nil
+}
+
+// MatchHeader matches the requests with the given header value
+func MatchHeader(key, value string) RequestMatcher {
+	return func(r *http.Request) bool {
+		return r.Header.Get(key) == value
+	}
+}
+
+// MatchQuery matches the requests with the given query parameter value
+func MatchQuery(key, value string) RequestMatcher {
+	return func(r *http.Request) bool {
+		return r.URL.Query().Get(key) == value
+	}
 }
 
 func (s *MockServer) Addr() string {
@@ -50,21 +75,27 @@ func (s *MockServer) Stop() {
 }
 
 func (s *MockServer) ResetCalls() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.calls = make([]*httpCall, 0)
 	s.response = make([]*mockedResponses, 0)
 }
 
 func (s *MockServer) LastRequest() *http.Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	if len(s.calls) != 0 {
 		return s.calls[len(s.calls)-1].request
 	}
 	return nil
 }
 
-func (s *MockServer) MockResponse(method, path string, status int, body io.Reader, headers map[string]string) {
-	s.response = append(s.response, &mockedResponses{
-		path:   path,
-		method: method,
+// MockResponse answers once to a request with the given method and path, and which meets all the matchers
+func (s *MockServer) MockResponse(method, path string, status int, body io.Reader, headers map[string]string, matchers ...RequestMatcher) {
+	s.addResponse(&mockedResponses{
+		path:     path,
+		method:   method,
+		matchers: matchers,
 		callback: func(writer http.ResponseWriter, request *http.Request) {
 			for key, val := range headers {
 				writer.Header().Set(key, val)
@@ -77,25 +108,47 @@ func (s *MockServer) MockResponse(method, path string, status int, body io.Reade
 	})
 }
 
-func (s *MockServer) MockCallback(path string, res http.HandlerFunc) {
-	s.response = append(s.response, &mockedResponses{
+// MockCallback calls res once for a request with the given path, whatever its method, and which meets all the matchers
+func (s *MockServer) MockCallback(path string, res http.HandlerFunc, matchers ...RequestMatcher) {
+	s.addResponse(&mockedResponses{
 		path:     path,
+		matchers: matchers,
 		callback: res,
 	})
 }
 
+func (s *MockServer) addResponse(res *mockedResponses) {
+	s.lock.Lock()
+	s.response = append(s.response, res)
+	s.lock.Unlock()
+}
+
 func (s *MockServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	path := request.URL.Path
-	method := request.Method
+	s.lock.Lock()
 	for idx, mock := range s.response {
-		if mock.method != method || mock.path != path {
+		if !mock.matches(request) {
 			continue
 		}
 		s.calls = append(s.calls, &httpCall{
 			request: request,
 		})
-		mock.callback(writer, request)
 		s.response = append(s.response[:idx], s.response[idx+1:]...)
+		s.lock.Unlock()
+
+		mock.callback(writer, request)
 		return
 	}
+	s.lock.Unlock()
+}
+
+func (m *mockedResponses) matches(r *http.Request) bool {
+	if (m.method != "" && m.method != r.Method) || m.path != r.URL.Path {
+		return false
+	}
+	for _, match := range m.matchers {
+		if !match(r) {
+			return false
+		}
+	}
+	return true
 }
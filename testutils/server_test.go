@@ -0,0 +1,96 @@
+package testutils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMockServerMatchers(t *testing.T) {
+	s, err := NewMockServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	s.MockResponse(http.MethodGet, "/users", http.StatusOK, strings.NewReader("admin"), nil, MatchHeader("X-Role", "admin"))
+	s.MockResponse(http.MethodGet, "/users", http.StatusOK, strings.NewReader("page 2"), nil, MatchQuery("page", "2"))
+	s.MockResponse(http.MethodPost, "/users", http.StatusCreated, strings.NewReader("created"), map[string]string{"Location": "/users/1"})
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header map[string]string
+		status int
+		body   string
+	}{
+		{"query matcher", http.MethodGet, "/users?page=2", nil, http.StatusOK, "page 2"},
+		{"header matcher", http.MethodGet, "/users", map[string]string{"X-Role": "admin"}, http.StatusOK, "admin"},
+		{"mock answers once", http.MethodGet, "/users", map[string]string{"X-Role": "admin"}, http.StatusOK, ""},
+		{"method", http.MethodPost, "/users", nil, http.StatusCreated, "created"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "http://"+s.Addr()+tt.url, nil)
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if res.StatusCode != tt.status || string(body) != tt.body {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.name, tt.status, tt.body, res.StatusCode, body)
+		}
+	}
+	if r := s.LastRequest(); r == nil || r.Method != http.MethodPost {
+		t.Errorf("expected the last matched request to be the POST, got %v", r)
+	}
+}
+
+func TestMockServerConcurrentRequests(t *testing.T) {
+	s, err := NewMockServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	const count = 50
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.MockCallback("/ping", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			res, err := http.Get("http://" + s.Addr() + "/ping")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = res.Body.Close()
+			_ = s.LastRequest()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNewMockServerBindError(t *testing.T) {
+	s, err := NewMockServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if other, err := NewMockServer(s.Addr()); err == nil {
+		other.Stop()
+		t.Error("expected the address in use to be reported")
+	}
+}
@@ -8,7 +8,10 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	netutils "github.com/najibulloShapoatov/server-core/utils/net"
 )
 
 // Used by IsFilePath func
@@ -52,6 +55,73 @@ func IsPhoneNumberFormat(str string) bool {
 
 var netLookupMX = net.LookupMX
 
+// MXCacheTTL is how long the result of a MX lookup is reused for the same domain.
+// A value of 0 disables the cache
+var MXCacheTTL = time.Minute * 5
+
+// MXCacheSize is the maximum number of domains kept in the MX cache
+var MXCacheSize = 10000
+
+type mxCacheEntry struct {
+	records []*net.MX
+	expires time.Time
+}
+
+var (
+	mxCache     = make(map[string]mxCacheEntry)
+	mxCacheLock sync.Mutex
+)
+
+// lookupMX returns the MX records of the given domain from the cache or from the DNS if they
+// are not cached or the cached records expired
+func lookupMX(host string) ([]*net.MX, error) {
+	host = strings.ToLower(host)
+	if MXCacheTTL > 0 {
+		mxCacheLock.Lock()
+		entry, ok := mxCache[host]
+		mxCacheLock.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			return entry.records, nil
+		}
+	}
+
+	mx, err := netLookupMX(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if MXCacheTTL > 0 {
+		mxCacheLock.Lock()
+		if _, ok := mxCache[host]; !ok && len(mxCache) >= MXCacheSize {
+			evictMX(time.Now())
+		}
+		mxCache[host] = mxCacheEntry{records: mx, expires: time.Now().Add(MXCacheTTL)}
+		mxCacheLock.Unlock()
+	}
+	return mx, nil
+}
+
+// evictMX removes the expired entries of the MX cache, or the one expiring first if none expired.
+// It must be called with the cache lock held
+func evictMX(now time.Time) {
+	var (
+		first   string
+		expires time.Time
+	)
+	for host, entry := range mxCache {
+		if !now.Before(entry.expires) {
+			delete(mxCache, host)
+			continue
+		}
+		if first == "" || entry.expires.Before(expires) {
+			first, expires = host, entry.expires
+		}
+	}
+	if len(mxCache) >= MXCacheSize {
+		delete(mxCache, first)
+	}
+}
+
 type dialer interface {
 	Close() error
 	Hello(localName string) error
@@ -85,75 +155,39 @@ func IsValidEmailHost(email string) bool {
 	return false
 }
 
-var ipRangeRe = "^(([(\\d+)(x+)]){1,3})(\\-+([(\\d+)(x)]{1,3}))?\\.(([(\\d+)(x+)]){1,3})(\\-+([(\\d+)(x)]{1,3}))?\\.(([(\\d+)(x+)]){1,3})(\\-+([(\\d+)(x)]{1,3}))?\\.(([(\\d+)(x+)]){1,3})(\\-+([(\\d+)(x)]{1,3}))?$"
-
+// IsValidIP returns true if ip is an entry supported by IsIPInRange: an IPv4 or IPv6 address, a CIDR
+// range, an address range like 10.0.0.255-10.0.1.5 or 10.0.0.1-50, an IPv4 wildcard like 10.0.*.* or *
 func IsValidIP(ip string) bool {
-	if ip == "*" {
+	ip = strings.TrimSpace(ip)
+	switch {
+	case ip == "*":
 		return true
+	case strings.Contains(ip, "/"):
+		_, _, err := net.ParseCIDR(ip)
+		return err == nil
+	case strings.Contains(ip, "-"):
+		_, _, ok := netutils.ParseIPRange(ip)
+		return ok
+	case strings.Contains(ip, "*"):
+		return net.ParseIP(strings.ReplaceAll(ip, "*", "0")).To4() != nil
 	}
-	if x := net.ParseIP(ip); x != nil {
-		return true
-	}
-	if _, _, err := net.ParseCIDR(ip); err == nil {
-		return true
-	}
-	if match, _ := regexp.MatchString(ipRangeRe, ip); match {
-		ipRange := strings.Split(ip, "-")
-		left, right := 0, 0
-		for _, i := range strings.Split(ipRange[0], ".") {
-			if left, _ = strconv.Atoi(i); left > 255 {
-				return false
-			}
-		}
-		for _, i := range strings.Split(ipRange[1], ".") {
-			if right, _ = strconv.Atoi(i); right > 255 {
-				return false
-			}
-		}
-
-		if right <= left {
-			return false
-		}
-
-		return true
-	}
-
-	return false
+	return net.ParseIP(ip) != nil
 }
 
-// IsIPInRange returns true if checkIP is in any of the specified ips or cidr ranges from rangeIPs
+// IsIPInRange returns true if checkIP is in any of the specified ips or ranges from rangeIPs. See
+// net.MatchIP for the supported ranges
 func IsIPInRange(rangeIPs []string, checkIP string) bool {
-	ip := net.ParseIP(checkIP)
-
-	for _, against := range rangeIPs {
-		against = strings.TrimSpace(against)
-		if against == "*" || strings.HasPrefix(checkIP, "127.") || strings.HasPrefix(checkIP, "192.") || strings.HasPrefix(checkIP, "10.") || checkIP == "::1" {
-			return true
-		}
-		if x := net.ParseIP(against); x != nil && ip.Equal(x) {
-			return true
-		}
-		if _, ipNet, err := net.ParseCIDR(against); err == nil && ipNet.Contains(ip) {
-			return true
-		}
-		if strings.Contains(against, "-") {
-			ipRange := strings.Split(against, "-")
-			start, _ := strconv.Atoi(strings.Split(ipRange[0], ".")[3])
-			end, _ := strconv.Atoi(ipRange[1])
-			between, _ := strconv.Atoi(strings.Split(checkIP, ".")[3])
-			if between >= start && between <= end {
-				return true
-			}
-		}
-	}
-	return false
+	return netutils.MatchIP(checkIP, rangeIPs)
 }
 
 func checkEmail(email, host string, timeout time.Duration) error {
-	mx, err := netLookupMX(host)
+	mx, err := lookupMX(host)
 	if err != nil {
 		return err
 	}
+	if len(mx) == 0 {
+		return fmt.Errorf("no MX records found for %s", host)
+	}
 
 	client, err := smtpClient(fmt.Sprintf("%s:%d", mx[0].Host, 25), timeout)
 	if err != nil {
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLookupMXCache(t *testing.T) {
+	defer func(lookup func(string) ([]*net.MX, error), ttl time.Duration) {
+		netLookupMX, MXCacheTTL = lookup, ttl
+	}(netLookupMX, MXCacheTTL)
+
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		domains []string
+		wait    time.Duration
+		fail    bool
+		queries int
+	}{
+		{"same domain", time.Minute, []string{"example.com", "example.com"}, 0, false, 1},
+		{"domain case", time.Minute, []string{"example.com", "EXAMPLE.com"}, 0, false, 1},
+		{"other domain", time.Minute, []string{"example.com", "example.org"}, 0, false, 2},
+		{"expired", time.Millisecond * 10, []string{"example.com", "example.com"}, time.Millisecond * 20, false, 2},
+		{"disabled", 0, []string{"example.com", "example.com"}, 0, false, 2},
+		{"errors are not cached", time.Minute, []string{"example.com", "example.com"}, 0, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mxCacheLock.Lock()
+			mxCache = make(map[string]mxCacheEntry)
+			mxCacheLock.Unlock()
+			MXCacheTTL = tt.ttl
+			queries := 0
+			netLookupMX = func(name string) ([]*net.MX, error) {
+				queries++
+				if tt.fail {
+					return nil, errors.New("no such host")
+				}
+				return []*net.MX{{Host: "mx." + name, Pref: 10}}, nil
+			}
+
+			for i, domain := range tt.domains {
+				if i > 0 {
+					time.Sleep(tt.wait)
+				}
+				mx, err := lookupMX(domain)
+				if tt.fail != (err != nil) {
+					t.Fatalf("expected error %v, got %v", tt.fail, err)
+				}
+				if !tt.fail && (len(mx) != 1 || mx[0].Host == "") {
+					t.Errorf("expected the MX records, got %v", mx)
+				}
+			}
+			if queries != tt.queries {
+				t.Errorf("expected %d lookups, got %d", tt.queries, queries)
+			}
+		})
+	}
+}
+
+func TestLookupMXCacheSize(t *testing.T) {
+	defer func(lookup func(string) ([]*net.MX, error), ttl time.Duration, size int) {
+		netLookupMX, MXCacheTTL, MXCacheSize = lookup, ttl, size
+	}(netLookupMX, MXCacheTTL, MXCacheSize)
+	netLookupMX = func(name string) ([]*net.MX, error) {
+		return []*net.MX{{Host: "mx." + name, Pref: 10}}, nil
+	}
+	MXCacheSize = 3
+
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		wait   time.Duration
+		cached []string
+	}{
+		// the entry expiring first makes room for the new one
+		{"full", time.Minute, 0, []string{"b.com", "c.com", "d.com"}},
+		{"expired", time.Millisecond * 10, time.Millisecond * 20, []string{"d.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mxCacheLock.Lock()
+			mxCache = make(map[string]mxCacheEntry)
+			mxCacheLock.Unlock()
+			MXCacheTTL = tt.ttl
+
+			for _, domain := range []string{"a.com", "b.com", "c.com"} {
+				_, _ = lookupMX(domain)
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(tt.wait)
+			_, _ = lookupMX("d.com")
+
+			mxCacheLock.Lock()
+			defer mxCacheLock.Unlock()
+			if len(mxCache) != len(tt.cached) {
+				t.Errorf("expected %d cached domains, got %d", len(tt.cached), len(mxCache))
+			}
+			for _, domain := range tt.cached {
+				if _, ok := mxCache[domain]; !ok {
+					t.Errorf("expected %s to be cached", domain)
+				}
+			}
+		})
+	}
+}
+
+func TestIsIPInRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []string
+		ip     string
+		want   bool
+	}{
+		{"cross octet range start", []string{"10.0.0.255-10.0.1.5"}, "10.0.0.255", true},
+		{"cross octet range middle", []string{"10.0.0.255-10.0.1.5"}, "10.0.1.0", true},
+		{"cross octet range end", []string{"10.0.0.255-10.0.1.5"}, "10.0.1.5", true},
+		{"cross octet range after", []string{"10.0.0.255-10.0.1.5"}, "10.0.1.6", false},
+		{"cross octet range same last octet", []string{"10.0.0.255-10.0.1.5"}, "10.0.2.3", false},
+		{"cross octet range before", []string{"10.0.0.255-10.0.1.5"}, "10.0.0.254", false},
+		{"short range", []string{"10.0.0.1-50"}, "10.0.0.50", true},
+		{"short range other network", []string{"10.0.0.1-50"}, "10.0.1.20", false},
+		{"reversed range", []string{"10.0.1.5-10.0.0.255"}, "10.0.1.0", false},
+		{"no private network shortcut", []string{"203.0.113.0/24"}, "192.168.1.1", false},
+		{"no loopback shortcut", []string{"203.0.113.0/24"}, "127.0.0.1", false},
+		{"no 10 network shortcut", []string{"203.0.113.1"}, "10.1.2.3", false},
+		{"cidr", []string{"203.0.113.0/24"}, "203.0.113.200", true},
+		{"wildcard", []string{"10.0.*.*"}, "10.0.200.1", true},
+		{"wildcard other network", []string{"10.0.*.*"}, "10.1.0.1", false},
+		{"exact address", []string{"203.0.113.1"}, "203.0.113.1", true},
+		{"ipv6 range", []string{"2001:db8::1-2001:db8::1:0"}, "2001:db8::ffff", true},
+		{"ipv6 outside range", []string{"2001:db8::1-2001:db8::1:0"}, "2001:db8::1:1", false},
+		{"mixed families", []string{"10.0.0.1-2001:db8::1"}, "10.0.0.2", false},
+		{"any", []string{"*"}, "203.0.113.1", true},
+		{"invalid address", []string{"*"}, "invalid", false},
+		{"second entry", []string{"203.0.113.1", "10.0.0.0/8"}, "10.1.2.3", true},
+	}
+	for _, tt := range tests {
+		if got := IsIPInRange(tt.ranges, tt.ip); got != tt.want {
+			t.Errorf("%s: expected %v for %s in %v, got %v", tt.name, tt.want, tt.ip, tt.ranges, got)
+		}
+	}
+}
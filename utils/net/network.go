@@ -4,11 +4,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math/big"
 	"net"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,17 +18,59 @@ const (
 	HeaderXForwardedFor = "X-Forwarded-For"
 )
 
+// trusted proxies, a []string of MatchIP entries
+var trustedProxies atomic.Value
+
+// SetTrustedProxies sets the addresses of the proxies trusted to report the client IP in the
+// X-Forwarded-For and X-Real-Ip headers. Entries can be anything supported by MatchIP. Without trusted
+// proxies the headers are ignored
+func SetTrustedProxies(list []string) {
+	res := make([]string, 0, len(list))
+	for _, p := range list {
+		if p = strings.TrimSpace(p); p != "" {
+			res = append(res, p)
+		}
+	}
+	trustedProxies.Store(res)
+}
+
+// isTrustedProxy returns true if the ip is one of the trusted proxies
+func isTrustedProxy(ip string) bool {
+	list, _ := trustedProxies.Load().([]string)
+	return len(list) != 0 && MatchIP(ip, list)
+}
+
+// GetClientIP returns the IP of the client that sent the request. The forwarding headers are used only
+// when the request comes from a trusted proxy, in which case X-Forwarded-For is read from right to left
+// skipping the trusted proxies, so a client can't spoof its address by sending the headers itself
 func GetClientIP(r *http.Request) string {
 	if r == nil {
 		return ""
 	}
 	ra := r.RemoteAddr
-	if ip := r.Header.Get(HeaderXForwardedFor); ip != "" {
-		ra = strings.TrimSpace(strings.Split(ip, ",")[0])
-	} else if ip := r.Header.Get(HeaderXRealIP); ip != "" {
-		ra = strings.TrimSpace(strings.Split(ip, ",")[0])
-	} else if strings.Contains(ra, ":") {
-		ra, _, _ = net.SplitHostPort(ra)
+	if host, _, err := net.SplitHostPort(ra); err == nil {
+		ra = host
+	}
+	if !isTrustedProxy(ra) {
+		return ra
+	}
+
+	if xff := r.Header.Values(HeaderXForwardedFor); len(xff) != 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			if ra = hop; !isTrustedProxy(hop) {
+				break
+			}
+		}
+		// when all the hops are trusted the first one is the client
+		return ra
+	}
+	if ip := strings.TrimSpace(r.Header.Get(HeaderXRealIP)); ip != "" {
+		return ip
 	}
 	return ra
 }
@@ -59,10 +102,17 @@ func GetLocalIP() string {
 	return ""
 }
 
+// CheckConnect returns an error if a TCP connection to host (host:port) can't be opened in 3 seconds
 func CheckConnect(host string) error {
-	conn, err := net.DialTimeout("tcp", host, time.Second*3)
+	return CheckConnectTimeout(host, time.Second*3)
+}
+
+// CheckConnectTimeout returns an error if a TCP connection to host (host:port) can't be opened before
+// the timeout expires
+func CheckConnectTimeout(host string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", host, timeout)
 	if err != nil {
-		return nil
+		return err
 	}
 	_ = conn.Close()
 	return nil
@@ -103,30 +153,40 @@ func BytesToMAC(mac []byte) string {
 	return strings.Join(res, ":")
 }
 
+// IPInRange returns true if the ip matches any entry of the list, or if the list is empty. See MatchIP
+// for the supported entries
 func IPInRange(ip string, list []string) bool {
-	userIP := net.ParseIP(ip)
 	if len(list) == 0 {
 		return true
 	}
+	return MatchIP(ip, list)
+}
 
+// MatchIP returns true if the ip matches any entry of the list. Entries can be IPv4 or IPv6 addresses,
+// CIDR ranges (10.0.0.0/8, 2001:db8::/32), address ranges (10.0.0.1-10.0.0.50 or the short form
+// 10.0.0.1-50), IPv4 wildcards (10.0.*.*) and * which matches any valid address
+func MatchIP(ip string, list []string) bool {
+	userIP := net.ParseIP(strings.TrimSpace(ip))
+	if userIP == nil {
+		return false
+	}
 	for _, against := range list {
+		against = strings.TrimSpace(against)
 		switch {
+		case against == "":
+			continue
 		case against == "*":
 			return true
 		case strings.Contains(against, "/"):
-			_, in, e := net.ParseCIDR(against)
-			if e != nil {
-				continue
-			}
-			if in.Contains(userIP) {
+			if CIDRMatch(userIP, against) {
 				return true
 			}
 		case strings.Contains(against, "-"):
-			ipRange := strings.Split(against, "-")
-			start, _ := strconv.Atoi(strings.Split(ipRange[0], ".")[3])
-			end, _ := strconv.Atoi(ipRange[1])
-			between, _ := strconv.Atoi(strings.Split(ip, ".")[3])
-			if between >= start && between <= end {
+			if BetweenMatch(userIP, against) {
+				return true
+			}
+		case strings.Contains(against, "*"):
+			if WildcardMatch(userIP, against) {
 				return true
 			}
 		default:
@@ -145,22 +205,55 @@ func MACToByte(mac string) []byte {
 	return res
 }
 
-// IPToInt encodes a string representation of a IP to a uint32
-func IPToInt(ip string) uint32 {
-	i := net.ParseIP(ip)
-	if len(i) == 16 {
-		return binary.BigEndian.Uint32(i[12:16])
+// IPToInt encodes a string representation of an IPv4 address to a uint32. It returns false for IPv6
+// addresses and invalid IPs, use IPToBytes or IPToBigInt for those
+func IPToInt(ip string) (uint32, bool) {
+	i := net.ParseIP(ip).To4()
+	if i == nil {
+		return 0, false
 	}
-	return binary.BigEndian.Uint32(i)
+	return binary.BigEndian.Uint32(i), true
 }
 
-// IntToIP decodes a uint32 encoded IP to a string representation
+// IntToIP decodes a uint32 encoded IPv4 address to a string representation
 func IntToIP(ip uint32) string {
 	i := make(net.IP, 4)
 	binary.BigEndian.PutUint32(i, ip)
 	return i.String()
 }
 
+// IPToBytes encodes a string representation of an IP to 4 bytes for IPv4 addresses and 16 bytes for
+// IPv6 addresses. It returns nil for invalid IPs
+func IPToBytes(ip string) []byte {
+	i := net.ParseIP(ip)
+	if i == nil {
+		return nil
+	}
+	if v4 := i.To4(); v4 != nil {
+		return []byte(v4)
+	}
+	return []byte(i)
+}
+
+// BytesToIP decodes a 4 or 16 bytes encoded IP to a string representation. It returns an empty
+// string for any other length
+func BytesToIP(b []byte) string {
+	if len(b) != net.IPv4len && len(b) != net.IPv6len {
+		return ""
+	}
+	return net.IP(b).String()
+}
+
+// IPToBigInt encodes a string representation of an IPv4 or IPv6 address to an integer. It returns
+// nil for invalid IPs
+func IPToBigInt(ip string) *big.Int {
+	b := IPToBytes(ip)
+	if b == nil {
+		return nil
+	}
+	return new(big.Int).SetBytes(b)
+}
+
 // CIDRMatch checks if specific ip belongs to specific cidr block (ip/mask)
 func CIDRMatch(ip net.IP, cidr string) bool {
 	_, cidrNet, err := net.ParseCIDR(cidr)
@@ -215,15 +308,47 @@ func GetLocalAddr() string {
 	return "0.0.0.0"
 }
 
-// BetweenMatch checks if ip belongs to specific IP Start-End range (ip-ip)
+// BetweenMatch checks if ip belongs to specific IP Start-End range (ip-ip). The end can be given as
+// the last part of the address only, like 10.0.0.1-50 or 2001:db8::1-ff
 func BetweenMatch(addr net.IP, block string) bool {
-	cleanStr := strings.Join(strings.Fields(block), "")
-	ips := strings.Split(cleanStr, "-")
-	res := IPBetween(net.ParseIP(ips[0]), net.ParseIP(ips[1]), addr)
+	from, to, ok := ParseIPRange(block)
+	if !ok {
+		return false
+	}
+	res := IPBetween(from, to, addr)
 
 	return res
 }
 
+// ParseIPRange parses a Start-End range of addresses (ip-ip). The end can be given as the last part
+// of the address only, like 10.0.0.1-50 or 2001:db8::1-ff. The range is valid if both ends are
+// addresses of the same family and the start is not after the end
+func ParseIPRange(block string) (from, to net.IP, ok bool) {
+	cleanStr := strings.Join(strings.Fields(block), "")
+	ips := strings.SplitN(cleanStr, "-", 2)
+	if len(ips) != 2 {
+		return nil, nil, false
+	}
+	from, to = net.ParseIP(ips[0]), net.ParseIP(ips[1])
+	if from != nil && to == nil {
+		// short form, the end replaces the last part of the start address
+		sep := "."
+		if from.To4() == nil {
+			sep = ":"
+		}
+		if i := strings.LastIndex(ips[0], sep); i >= 0 {
+			to = net.ParseIP(ips[0][:i+1] + ips[1])
+		}
+	}
+	if from == nil || to == nil || (from.To4() == nil) != (to.To4() == nil) {
+		return nil, nil, false
+	}
+	if bytes.Compare(from.To16(), to.To16()) > 0 {
+		return nil, nil, false
+	}
+	return from, to, true
+}
+
 // IPBetween does determine if a given ip is between two others (inclusive)
 func IPBetween(from net.IP, to net.IP, userIp net.IP) bool {
 	if from == nil || to == nil || userIp == nil {
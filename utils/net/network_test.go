@@ -0,0 +1,88 @@
+package net
+
+import (
+	"bytes"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIPEncoding(t *testing.T) {
+	v6Int, _ := new(big.Int).SetString("20010db8000000000000000000000001", 16)
+	tests := []struct {
+		ip     string
+		v4     uint32
+		isV4   bool
+		bytes  []byte
+		bigInt *big.Int
+	}{
+		{"10.0.1.5", 0x0a000105, true, []byte{10, 0, 1, 5}, big.NewInt(0x0a000105)},
+		{"255.255.255.255", 0xffffffff, true, []byte{255, 255, 255, 255}, big.NewInt(0xffffffff)},
+		{"::ffff:192.0.2.1", 0xc0000201, true, []byte{192, 0, 2, 1}, big.NewInt(0xc0000201)},
+		{"2001:db8::1", 0, false, []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, v6Int},
+		{"invalid", 0, false, nil, nil},
+	}
+	for _, tt := range tests {
+		v4, ok := IPToInt(tt.ip)
+		if ok != tt.isV4 || v4 != tt.v4 {
+			t.Errorf("%s: expected IPToInt %x %v, got %x %v", tt.ip, tt.v4, tt.isV4, v4, ok)
+		}
+		if ok {
+			if got, want := IntToIP(v4), BytesToIP(tt.bytes); got != want {
+				t.Errorf("%s: expected IntToIP %s, got %s", tt.ip, want, got)
+			}
+		}
+		b := IPToBytes(tt.ip)
+		if !bytes.Equal(b, tt.bytes) {
+			t.Errorf("%s: expected IPToBytes %v, got %v", tt.ip, tt.bytes, b)
+		}
+		if b != nil {
+			if got := IPToBytes(BytesToIP(b)); !bytes.Equal(got, b) {
+				t.Errorf("%s: expected BytesToIP to round trip, got %v", tt.ip, got)
+			}
+		}
+		if got := IPToBigInt(tt.ip); (got == nil) != (tt.bigInt == nil) || got != nil && got.Cmp(tt.bigInt) != 0 {
+			t.Errorf("%s: expected IPToBigInt %v, got %v", tt.ip, tt.bigInt, got)
+		}
+	}
+
+	if got := BytesToIP([]byte{1, 2, 3}); got != "" {
+		t.Errorf("expected an empty string for an invalid length, got %q", got)
+	}
+}
+
+func TestCheckConnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	open := ln.Addr().String()
+
+	// the port of a closed listener doesn't accept connections
+	closedLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := closedLn.Addr().String()
+	_ = closedLn.Close()
+
+	tests := []struct {
+		name    string
+		host    string
+		wantErr bool
+	}{
+		{"open port", open, false},
+		{"closed port", closed, true},
+		{"missing port", "127.0.0.1", true},
+	}
+	for _, tt := range tests {
+		if err := CheckConnectTimeout(tt.host, time.Second); (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+		}
+		if err := CheckConnect(tt.host); (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected CheckConnect error %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
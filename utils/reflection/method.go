@@ -31,6 +31,8 @@ func (m *Method) Out(i int) reflect.Type {
 	return m.paramsOut[i]
 }
 
+// Call invokes the method and turns a panic into a 500 status and the recovered value, which are
+// returned as the last two results
 func (m *Method) Call(args ...reflect.Value) (res []interface{}) {
 	defer func() {
 		err := recover()
@@ -41,6 +43,11 @@ func (m *Method) Call(args ...reflect.Value) (res []interface{}) {
 			res[len(res)-2] = http.StatusInternalServerError
 		}
 	}()
+	return m.Invoke(args...)
+}
+
+// Invoke calls the method like Call but lets the panics of the method propagate to the caller
+func (m *Method) Invoke(args ...reflect.Value) (res []interface{}) {
 	if len(m.paramsIn) != len(args) {
 		res = make([]interface{}, len(m.paramsOut))
 		res[len(res)-1] = errors.New("invalid argument")
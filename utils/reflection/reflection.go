@@ -3,8 +3,10 @@ package reflection
 import (
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
 // GetField returns the value of the provided obj field. obj can whether
@@ -63,15 +65,21 @@ func GetFieldTag(obj interface{}, fieldName, tagKey string) (string, error) {
 }
 
 // SetField sets the provided obj field with provided value. obj param has
-// to be a pointer to a struct, otherwise it will soundly fail. Provided
-// value type should match with the struct field you're trying to set.
+// to be a pointer to a struct, otherwise it will soundly fail. name can be
+// a dotted path like "Address.City" to reach nested fields, nil pointers
+// on the way are allocated. The value is converted to the field type when
+// it is assignable or convertible, any other type is rejected. Numbers
+// that don't fit in the field type, like 1.5 or 300 for an int8 field,
+// are rejected as well.
 func SetField(obj interface{}, name string, value interface{}) error {
-	// Fetch the field reflect.Value
-	structValue := reflect.ValueOf(obj).Elem()
-	structFieldValue := structValue.FieldByName(name)
+	if !IsPointer(obj) || reflect.ValueOf(obj).IsNil() {
+		return errors.New("cannot use SetField on a non-pointer interface")
+	}
 
-	if !structFieldValue.IsValid() {
-		return fmt.Errorf("no such field: %s in obj", name)
+	// Fetch the field reflect.Value
+	structFieldValue, err := fieldByPath(reflect.ValueOf(obj).Elem(), name)
+	if err != nil {
+		return err
 	}
 
 	// If obj field value is not settable an error is thrown
@@ -79,17 +87,143 @@ func SetField(obj interface{}, name string, value interface{}) error {
 		return fmt.Errorf("cannot set %s field value", name)
 	}
 
-	structFieldType := structFieldValue.Type()
-	val := reflect.ValueOf(value)
-	if structFieldType != val.Type() {
-		invalidTypeError := errors.New("provided value type didn't match obj field type")
-		return invalidTypeError
+	val, err := convertValue(value, structFieldValue.Type())
+	if err != nil {
+		return err
 	}
 
 	structFieldValue.Set(val)
 	return nil
 }
 
+// fieldByPath walks the dotted path of fields starting from the v struct
+func fieldByPath(v reflect.Value, path string) (reflect.Value, error) {
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set %s field value", path)
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("no such field: %s in obj", path)
+		}
+		v = v.FieldByName(name)
+		if !v.IsValid() {
+			return reflect.Value{}, fmt.Errorf("no such field: %s in obj", path)
+		}
+	}
+	return v, nil
+}
+
+// convertValue returns value as a reflect.Value of the typ type. Numbers are
+// not converted to strings as Go would turn them into runes
+func convertValue(value interface{}, typ reflect.Type) (reflect.Value, error) {
+	invalidTypeError := errors.New("provided value type didn't match obj field type")
+
+	val := reflect.ValueOf(value)
+	if !val.IsValid() {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+			return reflect.Zero(typ), nil
+		}
+		return reflect.Value{}, invalidTypeError
+	}
+
+	switch {
+	case val.Type().AssignableTo(typ):
+		return val, nil
+	case typ.Kind() == reflect.String && val.Kind() != reflect.String:
+		// []byte and []rune are the only sensible conversions to a string
+		if val.Kind() != reflect.Slice {
+			return reflect.Value{}, invalidTypeError
+		}
+	}
+	if !val.Type().ConvertibleTo(typ) {
+		return reflect.Value{}, invalidTypeError
+	}
+	if err := checkNumber(val, typ); err != nil {
+		return reflect.Value{}, err
+	}
+	return val.Convert(typ), nil
+}
+
+// checkNumber returns an error if the numeric value would lose its fraction or overflow when converted
+// to the numeric type typ
+func checkNumber(val reflect.Value, typ reflect.Type) error {
+	overflow := func() error {
+		return fmt.Errorf("value %v overflows %s", val.Interface(), typ)
+	}
+	target := reflect.New(typ).Elem()
+
+	switch val.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := val.Float()
+		switch typ.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if math.IsNaN(f) || math.IsInf(f, 0) || f != math.Trunc(f) {
+				return fmt.Errorf("value %v is not an integer", f)
+			}
+			if isInt(typ.Kind()) {
+				if f < math.MinInt64 || f >= math.MaxInt64 || target.OverflowInt(int64(f)) {
+					return overflow()
+				}
+			} else if f < 0 || f >= math.MaxUint64 || target.OverflowUint(uint64(f)) {
+				return overflow()
+			}
+		case reflect.Float32:
+			if target.OverflowFloat(f) {
+				return overflow()
+			}
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := val.Int()
+		switch {
+		case isInt(typ.Kind()):
+			if target.OverflowInt(i) {
+				return overflow()
+			}
+		case isUint(typ.Kind()):
+			if i < 0 || target.OverflowUint(uint64(i)) {
+				return overflow()
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := val.Uint()
+		switch {
+		case isInt(typ.Kind()):
+			if u > math.MaxInt64 || target.OverflowInt(int64(u)) {
+				return overflow()
+			}
+		case isUint(typ.Kind()):
+			if target.OverflowUint(u) {
+				return overflow()
+			}
+		}
+	}
+	return nil
+}
+
+func isInt(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUint(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
 // HasField checks if the provided field name is part of a struct. obj can whether
 // be a structure or pointer to structure.
 func HasField(obj interface{}, name string) (bool, error) {
@@ -134,6 +268,62 @@ func Items(obj interface{}) (map[string]interface{}, error) {
 	return items, nil
 }
 
+// ItemsByTag returns the struct values as a map keyed by the name found in
+// the tagKey tag, falling back to the field name when the tag is missing.
+// Fields tagged with "-" are skipped and the fields of embedded structs are
+// added to the same map. obj can whether be a structure or pointer to structure.
+func ItemsByTag(obj interface{}, tagKey string) (map[string]interface{}, error) {
+	if !hasValidType(obj, []reflect.Kind{reflect.Struct, reflect.Ptr}) {
+		return nil, errors.New("cannot use ItemsByTag on a non-struct interface")
+	}
+
+	objValue := reflectValue(obj)
+	if objValue.Kind() != reflect.Struct {
+		return nil, errors.New("cannot use ItemsByTag on a non-struct interface")
+	}
+
+	items := make(map[string]interface{})
+	itemsByTag(objValue, tagKey, items)
+	return items, nil
+}
+
+func itemsByTag(objValue reflect.Value, tagKey string, items map[string]interface{}) {
+	objType := objValue.Type()
+	for i := 0; i < objType.NumField(); i++ {
+		field := objType.Field(i)
+		tag := field.Tag.Get(tagKey)
+		if tag == "-" {
+			continue
+		}
+		name, _ := parseTag(tag)
+		fieldValue := objValue.Field(i)
+
+		// embedded structs without an explicit name are flattened, even
+		// when their type is not exported
+		if field.Anonymous && name == "" {
+			embedded := fieldValue
+			if embedded.Kind() == reflect.Ptr {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				itemsByTag(embedded, tagKey, items)
+				continue
+			}
+		}
+
+		if !isExportableField(field) {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		items[name] = fieldValue.Interface()
+	}
+}
+
 // Tags lists the struct tag fields. obj can whether
 // be a structure or pointer to structure.
 func Tags(obj interface{}, key string) (map[string]string, error) {
@@ -292,6 +482,9 @@ func ReflectSimpleValue(str string, typ reflect.Type) (val reflect.Value, err er
 	return reflectValue(typ), nil
 }
 
+// Indirect follows pointers and interfaces until it reaches a non-pointer value.
+// It mutates v: nil pointers on the way are set to newly allocated values so
+// the result can be assigned. Use Deref to only inspect a value
 func Indirect(v reflect.Value, decodingNull bool) reflect.Value {
 	v0 := v
 	haveAddr := false
@@ -340,3 +533,35 @@ func Indirect(v reflect.Value, decodingNull bool) reflect.Value {
 	}
 	return v
 }
+
+// Deref follows pointers and interfaces until it reaches a non-pointer value
+// without modifying v. A nil pointer or interface on the way returns an
+// invalid reflect.Value, so does a pointer cycle
+func Deref(v reflect.Value) reflect.Value {
+	var seen []uintptr
+	for v.IsValid() {
+		switch v.Kind() {
+		case reflect.Interface:
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		case reflect.Ptr:
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			// an interface can point to its own address
+			p := v.Pointer()
+			for _, s := range seen {
+				if s == p {
+					return reflect.Value{}
+				}
+			}
+			seen = append(seen, p)
+			v = v.Elem()
+		default:
+			return v
+		}
+	}
+	return v
+}
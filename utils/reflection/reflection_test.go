@@ -0,0 +1,59 @@
+package reflection
+
+import (
+	"math"
+	"testing"
+)
+
+type numbers struct {
+	Int8    int8
+	Int     int
+	Uint8   uint8
+	Uint    uint
+	Float32 float32
+	Float64 float64
+}
+
+func TestSetFieldNumbers(t *testing.T) {
+	tests := []struct {
+		field   string
+		value   interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"Int", 42.0, 42, false},
+		{"Int", 1.5, nil, true},
+		{"Int", math.NaN(), nil, true},
+		{"Int", math.Inf(1), nil, true},
+		{"Int", 1e20, nil, true},
+		{"Int8", 127, int8(127), false},
+		{"Int8", 128, nil, true},
+		{"Int8", -129, nil, true},
+		{"Int8", uint64(200), nil, true},
+		{"Int", uint64(math.MaxUint64), nil, true},
+		{"Uint8", 255, uint8(255), false},
+		{"Uint8", 256, nil, true},
+		{"Uint8", -1, nil, true},
+		{"Uint", -1.0, nil, true},
+		{"Uint", float64(7), uint(7), false},
+		{"Uint8", uint64(300), nil, true},
+		{"Float32", 1.5, float32(1.5), false},
+		{"Float32", 1e300, nil, true},
+		{"Float64", 3, float64(3), false},
+		{"Float64", int64(math.MaxInt64), float64(math.MaxInt64), false},
+	}
+	for _, tt := range tests {
+		var n numbers
+		err := SetField(&n, tt.field, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s = %v: expected error %v, got %v", tt.field, tt.value, tt.wantErr, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got, _ := GetField(n, tt.field); got != tt.want {
+			t.Errorf("%s = %v: expected %v (%T), got %v (%T)", tt.field, tt.value, tt.want, tt.want, got, got)
+		}
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"io"
 	"os"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/pgtype"
@@ -113,11 +113,16 @@ func (id *UID) DecodeText(ci *pgtype.ConnInfo, src []byte) error {
 }
 
 // UnmarshalText turns *bson.ObjectId into an encoding.TextUnmarshaler.
+// It accepts the 24 chars hex form produced by MarshalText and the 12 bytes raw form
 func (id *UID) UnmarshalText(data []byte) error {
 	if len(data) == 1 && data[0] == ' ' || len(data) == 0 {
 		*id = ""
 		return nil
 	}
+	if len(data) == 12 {
+		*id = UID(data)
+		return nil
+	}
 	if len(data) != 24 {
 		return fmt.Errorf("invalid ObjectId: %s", data)
 	}
@@ -134,10 +139,14 @@ func (id UID) Valid() bool {
 	return len(id) == 12
 }
 
+// NewUID returns a new 12 bytes id made of a timestamp in seconds, the machine id, the process id
+// and a counter. The ids generated by a process are unique and strictly increasing, also within the
+// same second, so they sort in creation order. Ids generated by different processes sort by second
 func NewUID() UID {
 	var b [12]byte
+	ts, counter := nextUIDTime()
 	// Timestamp, 4 bytes, big endian
-	binary.BigEndian.PutUint32(b[:], uint32(time.Now().Unix()))
+	binary.BigEndian.PutUint32(b[:], ts)
 	// Machine, first 3 bytes of md5(hostname)
 	b[4] = machineId[0]
 	b[5] = machineId[1]
@@ -146,13 +155,29 @@ func NewUID() UID {
 	b[7] = byte(processId >> 8)
 	b[8] = byte(processId)
 	// Increment, 3 bytes, big endian
-	i := atomic.AddUint32(&objectIdCounter, 1)
-	b[9] = byte(i >> 16)
-	b[10] = byte(i >> 8)
-	b[11] = byte(i)
+	b[9] = byte(counter >> 16)
+	b[10] = byte(counter >> 8)
+	b[11] = byte(counter)
 	return UID(b[:])
 }
 
+// nextUIDTime returns the timestamp and the counter of the next id. The counter starts at a random
+// value in its lower half every second, when it overflows the timestamp moves to the next second so
+// the ids never repeat nor go back, even if the clock does
+func nextUIDTime() (ts uint32, counter uint32) {
+	uidLock.Lock()
+	defer uidLock.Unlock()
+
+	if now := uint32(time.Now().Unix()); now > uidTime {
+		uidTime = now
+		uidCounter = readRandomUint32() & 0x7fffff
+	} else if uidCounter++; uidCounter > 0xffffff {
+		uidTime++
+		uidCounter = 0
+	}
+	return uidTime, uidCounter
+}
+
 func UIDHex(s string) UID {
 	if IsHexadecimal(s) && IsUIDHex(s) {
 		d, err := hex.DecodeString(s)
@@ -173,9 +198,12 @@ func IsUIDHex(s string) bool {
 	return err == nil
 }
 
-// objectIdCounter is atomically incremented when generating a new ObjectId
-// using NewObjectId() function. It's used as a counter part of an id.
-var objectIdCounter = readRandomUint32()
+// timestamp and counter of the last id generated by NewUID
+var (
+	uidLock    sync.Mutex
+	uidTime    uint32
+	uidCounter uint32
+)
 
 // readRandomUint32 returns a random objectIdCounter.
 func readRandomUint32() uint32 {
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestNewUIDUnique(t *testing.T) {
+	const total, workers = 1000000, 8
+	perWorker := total / workers
+
+	results := make([][]UID, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ids := make([]UID, perWorker)
+			for i := range ids {
+				ids[i] = NewUID()
+			}
+			results[w] = ids
+		}(w)
+	}
+	wg.Wait()
+
+	seen := make(map[UID]struct{}, total)
+	for _, ids := range results {
+		for i, id := range ids {
+			if !id.Valid() {
+				t.Fatalf("invalid id %x", string(id))
+			}
+			if _, ok := seen[id]; ok {
+				t.Fatalf("duplicate id %x", string(id))
+			}
+			seen[id] = struct{}{}
+			// the ids of a goroutine are generated in order so they must be increasing
+			if i > 0 && bytes.Compare([]byte(ids[i-1]), []byte(id)) >= 0 {
+				t.Fatalf("id %x is not after %x", string(id), string(ids[i-1]))
+			}
+		}
+	}
+}
+
+func TestNewUIDCounterOverflow(t *testing.T) {
+	uidLock.Lock()
+	// a second in the future so the clock doesn't reset the counter
+	uidTime += 10
+	uidCounter = 0xfffffe
+	start := uidTime
+	uidLock.Unlock()
+
+	tests := []struct {
+		ts      uint32
+		counter uint32
+	}{
+		{start, 0xffffff},
+		{start + 1, 0},
+		{start + 1, 1},
+	}
+	var prev UID
+	for _, tt := range tests {
+		id := NewUID()
+		ts, counter := uidParts(id)
+		if ts != tt.ts || counter != tt.counter {
+			t.Errorf("expected time %d and counter %x, got %d %x", tt.ts, tt.counter, ts, counter)
+		}
+		if prev != "" && bytes.Compare([]byte(prev), []byte(id)) >= 0 {
+			t.Errorf("id %x is not after %x", string(id), string(prev))
+		}
+		prev = id
+	}
+}
+
+// uidParts returns the timestamp and the counter of the id
+func uidParts(id UID) (ts uint32, counter uint32) {
+	b := []byte(id)
+	ts = uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	counter = uint32(b[9])<<16 | uint32(b[10])<<8 | uint32(b[11])
+	return
+}
+
+func TestUIDTextRoundTrip(t *testing.T) {
+	raw := NewUID()
+	hexID := UID(raw.Hex())
+	tests := []struct {
+		name    string
+		text    []byte
+		want    UID
+		wantErr bool
+	}{
+		{"marshaled raw id", mustMarshalText(t, raw), raw, false},
+		{"marshaled hex id", mustMarshalText(t, hexID), raw, false},
+		{"raw 12 bytes", []byte(raw), raw, false},
+		{"empty", nil, "", false},
+		{"blank", []byte(" "), "", false},
+		{"invalid hex", []byte("zzzzzzzzzzzzzzzzzzzzzzzz"), "", true},
+		{"invalid length", []byte("abcdef"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got UID
+			err := got.UnmarshalText(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %x, got %x", string(tt.want), string(got))
+			}
+			if !tt.wantErr && tt.want != "" && !got.Equals(hexID) {
+				t.Errorf("expected %s to equal %s", got.Hex(), hexID)
+			}
+		})
+	}
+}
+
+func mustMarshalText(t *testing.T, id UID) []byte {
+	t.Helper()
+	text, err := id.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
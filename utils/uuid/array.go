@@ -44,7 +44,7 @@ func (o *Array) Bytes() []byte {
 
 // String prints the uuid array formatted with the standard format
 func (o Array) String() string {
-	return formatter(&o, format)
+	return formatter(&o, GetFormat())
 }
 
 // Format prints the uuid array formatted with the given format
@@ -52,6 +52,12 @@ func (o Array) Format(pFormat string) string {
 	return formatter(&o, pFormat)
 }
 
+// FormatWith prints the uuid array formatted with the given format instead of the default one.
+// It panics if the format is invalid
+func (o Array) FormatWith(pFormat Format) string {
+	return Formatter(&o, pFormat)
+}
+
 // Set the three most significant bits (bits 0, 1 and 2) of the
 // sequenceHiAndVariant equivalent in the array to reservedRFC4122.
 func (o *Array) setRFC4122Variant() {
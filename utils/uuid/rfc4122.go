@@ -29,16 +29,16 @@ var (
 		0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8,
 	}
 
-	// The following standard UUIDs are for use with V3 or V5 UUIDs.
+	// The following standard UUIDs, defined in RFC-4122 appendix C, are for use with V3 or V5 UUIDs.
 
-	// NamespaceDNS ...
-	NamespaceDNS = &Struct{0x6ba7b810, 0x9dad, 0x11d1, 0x80, 0xb4, nodeID, length}
-	// NamespaceURL ...
-	NamespaceURL = &Struct{0x6ba7b811, 0x9dad, 0x11d1, 0x80, 0xb4, nodeID, length}
-	// NamespaceOID ...
-	NamespaceOID = &Struct{0x6ba7b812, 0x9dad, 0x11d1, 0x80, 0xb4, nodeID, length}
-	// NamespaceX500 ...
-	NamespaceX500 = &Struct{0x6ba7b814, 0x9dad, 0x11d1, 0x80, 0xb4, nodeID, length}
+	// NamespaceDNS is the namespace of fully qualified domain names
+	NamespaceDNS = MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	// NamespaceURL is the namespace of URLs
+	NamespaceURL = MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	// NamespaceOID is the namespace of ISO object identifiers
+	NamespaceOID = MustParse("6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+	// NamespaceX500 is the namespace of X.500 distinguished names
+	NamespaceX500 = MustParse("6ba7b814-9dad-11d1-80b4-00c04fd430c8")
 
 	state State
 )
@@ -81,20 +81,31 @@ func NewV3(pNs UUID, pName UniqueName) UUID {
 // NewV4 will generate a new RFC4122 version 4 UUID
 // A cryptographically secure random UUID.
 func NewV4() UUID {
-	o := new(Array)
-	// Read random values (or pseudo-randomly) into Array type.
-	_, err := rand.Read(o[:length])
+	o, err := newV4()
 	if err != nil {
 		panic(err)
 	}
+	return o
+}
+
+// newV4 generates a version 4 UUID, returning the error of the random source
+func newV4() (*Array, error) {
+	o := new(Array)
+	// Read random values (or pseudo-randomly) into Array type.
+	if _, err := rand.Read(o[:length]); err != nil {
+		return nil, err
+	}
 	o.setRFC4122Variant()
 	o.setVersion(4)
-	return o
+	return o, nil
 }
 
 // NewV5 will generate a new RFC4122 version 5 UUID
 // Generate a UUID based on the SHA-1 hash of a namespace
-// identifier and a name.
+// identifier and a name. The same namespace and name always
+// give the same UUID
+//
+//	NewV5(NamespaceDNS, Name("example.com")) // cfbff0d1-9375-5685-968c-48ce8b15ae17
 func NewV5(pNs UUID, pName UniqueName) UUID {
 	o := new(Array)
 	Digest(o, pNs, pName, sha1.New())
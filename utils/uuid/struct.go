@@ -75,7 +75,7 @@ func (o *Struct) UnmarshalBinary(pData []byte) error {
 
 // Formats the UUID struct into the default string format
 func (o Struct) String() string {
-	return formatter(&o, format)
+	return formatter(&o, GetFormat())
 }
 
 // Format formats the struct into the given format
@@ -83,6 +83,12 @@ func (o Struct) Format(pFormat string) string {
 	return formatter(&o, pFormat)
 }
 
+// FormatWith formats the struct with the given format instead of the default one.
+// It panics if the format is invalid
+func (o Struct) FormatWith(pFormat Format) string {
+	return Formatter(&o, pFormat)
+}
+
 // Set the three most significant bits (bits 0, 1 and 2) of the
 // sequenceHiAndVariant to variant mask 0x80.
 func (o *Struct) setRFC4122Variant() {
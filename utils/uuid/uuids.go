@@ -11,7 +11,6 @@ package uuid
 
 import (
 	"bytes"
-	"crypto/rand"
 	"encoding"
 	"encoding/hex"
 	"errors"
@@ -19,6 +18,7 @@ import (
 	"hash"
 	"regexp"
 	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -40,7 +40,8 @@ const (
 
 var (
 	parseUUIDRegex = regexp.MustCompile(hexPattern)
-	format         string
+	// default format, a string, read by every String call
+	format atomic.Value
 )
 
 func init() {
@@ -85,14 +86,13 @@ type UUID interface {
 	String() string
 }
 
-// NewUUID creates a hex encoded uuid from a newly created random byte array
+// NewUUID creates a new RFC4122 version 4 UUID formatted with the default format
 func NewUUID() (*string, error) {
-	byt := make([]byte, 16)
-	_, err := rand.Read(byt)
+	o, err := newV4()
 	if err != nil {
 		return nil, err
 	}
-	resp := New(byt).String()
+	resp := o.String()
 	return &resp, nil
 }
 
@@ -132,6 +132,21 @@ func Parse(pUUID string) (UUID, error) {
 	return NewHex(md[2] + md[3] + md[4] + md[5] + md[6]), nil
 }
 
+// MustParse is like Parse but panics if the string is not a valid UUID.
+// Use it for constants and tests, never for user input
+func MustParse(pUUID string) UUID {
+	o, err := Parse(pUUID)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// IsValid returns true if the string is a UUID in any of the formats accepted by Parse
+func IsValid(pUUID string) bool {
+	return parseUUIDRegex.MatchString(pUUID)
+}
+
 // Digest a namespace UUID and a UniqueName, which then marshals to
 // a new UUID
 func Digest(o, pNs UUID, pName UniqueName, pHash hash.Hash) {
@@ -207,17 +222,19 @@ const (
 
 // GetFormat returns the current default format pattern
 func GetFormat() string {
-	return format
+	return format.Load().(string)
 }
 
-// SwitchFormat switches the default printing format for ALL UUID strings
+// SwitchFormat switches the default printing format for ALL UUID strings. It is safe to call while
+// other goroutines print UUIDs, but prefer FormatWith or Formatter to print a single UUID with another
+// format since the change affects the whole program
 // A valid format will have 6 groups if the supplied Format does not
 func SwitchFormat(pFormat Format) {
 	form := string(pFormat)
 	if strings.Count(form, "%") != 6 {
 		panic(errors.New("uuid.switchFormat: invalid formatting"))
 	}
-	format = form
+	format.Store(form)
 }
 
 // SwitchFormatUpperCase is same as SwitchFormat but will make it uppercase
@@ -0,0 +1,94 @@
+package uuid
+
+import "testing"
+
+func TestVersionAndVariant(t *testing.T) {
+	tests := []struct {
+		name    string
+		uuid    func() UUID
+		version int
+	}{
+		{"v1", NewV1, 1},
+		{"v3", func() UUID { return NewV3(NamespaceURL, Name("https://example.com")) }, 3},
+		{"v4", NewV4, 4},
+		{"v5", func() UUID { return NewV5(NamespaceDNS, Name("example.com")) }, 5},
+		{"NewUUID", func() UUID {
+			s, err := NewUUID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return MustParse(*s)
+		}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				u := tt.uuid()
+				if u.Version() != tt.version {
+					t.Fatalf("%s: expected version %d, got %d", u, tt.version, u.Version())
+				}
+				if u.Variant() != reservedRFC4122 {
+					t.Fatalf("%s: expected the RFC4122 variant, got %x", u, u.Variant())
+				}
+			}
+		})
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"6ba7b8149dad11d180b400c04fd430c8", true},
+		{"6ba7b814-9dad-11d1-80b4-00c04fd430c8", true},
+		{"{6ba7b814-9dad-11d1-80b4-00c04fd430c8}", true},
+		{"urn:uuid:6ba7b814-9dad-11d1-80b4-00c04fd430c8", true},
+		{"[6BA7B814-9DAD-11D1-80B4-00C04FD430C8]", true},
+		{"", false},
+		{"6ba7b814-9dad-11d1-80b4", false},
+		{"6ba7b814-9dad-11d1-80b4-00c04fd430c8ff", false},
+		{"6ba7b814-9dad-61d1-80b4-00c04fd430c8", false},
+		{"zba7b814-9dad-11d1-80b4-00c04fd430c8", false},
+	}
+	for _, tt := range tests {
+		if got := IsValid(tt.in); got != tt.want {
+			t.Errorf("%q: expected IsValid %v, got %v", tt.in, tt.want, got)
+		}
+		_, err := Parse(tt.in)
+		if (err == nil) != tt.want {
+			t.Errorf("%q: expected Parse to agree with IsValid, got %v", tt.in, err)
+		}
+	}
+}
+
+func TestMustParse(t *testing.T) {
+	if got := MustParse("{6BA7B814-9DAD-11D1-80B4-00C04FD430C8}").String(); got != "6ba7b814-9dad-11d1-80b4-00c04fd430c8" {
+		t.Errorf("expected the parsed UUID, got %s", got)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected MustParse to panic on an invalid UUID")
+		}
+	}()
+	MustParse("invalid")
+}
+
+func TestNewV5Vectors(t *testing.T) {
+	tests := []struct {
+		ns   UUID
+		name string
+		want string
+	}{
+		{NamespaceDNS, "example.com", "cfbff0d1-9375-5685-968c-48ce8b15ae17"},
+		{NamespaceDNS, "python.org", "886313e1-3b8a-5372-9b90-0c9aee199e5d"},
+		{NamespaceURL, "https://example.com/", "dd2c1780-811a-5296-81c5-178a0ef488bc"},
+		{NamespaceOID, "1.3.6.1", "1447fa61-5277-5fef-a9b3-fbc6e44f4af3"},
+		{NamespaceX500, "cn=John,o=Example", "30a80e16-8b8c-5289-97d0-c91ee8060760"},
+	}
+	for _, tt := range tests {
+		if got := NewV5(tt.ns, Name(tt.name)).String(); got != tt.want {
+			t.Errorf("%s in %s: expected %s, got %s", tt.name, tt.ns, tt.want, got)
+		}
+	}
+}